@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/roasted99/hospital-middleware/internal/config"
+	"github.com/roasted99/hospital-middleware/internal/services"
+)
+
+// runCommand runs a maintenance subcommand instead of the HTTP server
+func runCommand(db *sql.DB, name string, args []string) error {
+	switch name {
+	case "bootstrap-admin":
+		return bootstrapAdmin(db, args)
+	case "verify-audit":
+		return verifyAudit(db, args)
+	default:
+		return fmt.Errorf("unknown command %q", name)
+	}
+}
+
+// bootstrapAdmin creates the first admin of a hospital. Further staff are
+// invited by that admin. The password may be passed in BOOTSTRAP_ADMIN_PASSWORD
+// to keep it out of the shell history.
+func bootstrapAdmin(db *sql.DB, args []string) error {
+	flags := flag.NewFlagSet("bootstrap-admin", flag.ContinueOnError)
+	username := flags.String("username", "", "admin username")
+	password := flags.String("password", os.Getenv("BOOTSTRAP_ADMIN_PASSWORD"), "admin password")
+	hospital := flags.String("hospital", "", "hospital the admin belongs to")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *username == "" || *password == "" || *hospital == "" {
+		return errors.New("username, password, and hospital are required")
+	}
+
+	policy := services.NewPasswordPolicy(config.GetPasswordPolicyConfig())
+	if err := policy.Validate(*password, *username); err != nil {
+		return err
+	}
+
+	var admins int
+	if err := db.QueryRow("SELECT COUNT(*) FROM staff WHERE hospital = $1 AND role = $2", *hospital, services.RoleAdmin).Scan(&admins); err != nil {
+		return err
+	}
+	if admins > 0 {
+		return fmt.Errorf("%s already has an admin; invite further staff instead", *hospital)
+	}
+
+	hashedPassword, err := services.HashPassword(*password)
+	if err != nil {
+		return err
+	}
+
+	var staffID int
+	err = db.QueryRow("INSERT INTO staff (username, password, hospital, role, created_at, updated_at) VALUES ($1, $2, $3, $4, NOW(), NOW()) RETURNING id",
+		*username, hashedPassword, *hospital, services.RoleAdmin).Scan(&staffID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Created admin %s (id %d) for %s\n", *username, staffID, *hospital)
+	return nil
+}
+
+// verifyAudit checks the hash chain and signed checkpoints of the audit log
+// between -from and -to (YYYY-MM-DD or RFC 3339, -to exclusive) and fails at
+// the first broken link
+func verifyAudit(db *sql.DB, args []string) error {
+	flags := flag.NewFlagSet("verify-audit", flag.ContinueOnError)
+	fromFlag := flags.String("from", "", "start of the range; defaults to the first entry")
+	toFlag := flags.String("to", "", "end of the range, exclusive; defaults to now")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	from, err := parseAuditTime(*fromFlag, time.Time{})
+	if err != nil {
+		return fmt.Errorf("-from: %w", err)
+	}
+	to, err := parseAuditTime(*toFlag, time.Now())
+	if err != nil {
+		return fmt.Errorf("-to: %w", err)
+	}
+
+	// Checkpoints are signed with the access token keys; a checkpoint whose key
+	// was removed from JWT_KEYS fails verification
+	keyConfigs, err := config.GetJWTKeyConfigs()
+	if err != nil {
+		return err
+	}
+	keyset, err := services.LoadKeyset(keyConfigs, time.Now())
+	if err != nil {
+		return err
+	}
+	services.SetKeyset(keyset)
+
+	result, err := services.NewSQLAuditLogger(db).Verify(context.Background(), from, to)
+	if err != nil {
+		return err
+	}
+	if result.Entries == 0 {
+		fmt.Printf("No audit entries in range: link to the next entry and %d checkpoints verified\n", result.Checkpoints)
+		return nil
+	}
+
+	fmt.Printf("Verified audit entries %d to %d: %d entries, %d checkpoints\n", result.FirstEntryID, result.LastEntryID, result.Entries, result.Checkpoints)
+	if result.Unchained > 0 {
+		fmt.Printf("%d entries predate the hash chain and were not verified\n", result.Unchained)
+	}
+	return nil
+}
+
+func parseAuditTime(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
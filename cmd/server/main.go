@@ -1,9 +1,11 @@
 package main
 
 import (
+  "context"
   "log"
   "net/http"
   "os"
+  "time"
   "fmt"
 
   "github.com/gorilla/mux"
@@ -11,6 +13,8 @@ import (
   "github.com/roasted99/hospital-middleware/internal/db"
   "github.com/roasted99/hospital-middleware/internal/api/handlers"
   "github.com/roasted99/hospital-middleware/internal/api/middleware"
+  "github.com/roasted99/hospital-middleware/internal/models"
+  "github.com/roasted99/hospital-middleware/internal/services"
 )
 
 func main() {
@@ -26,17 +30,117 @@ func main() {
   }
   defer db.Close()
 
+  // Maintenance subcommands, e.g. "server bootstrap-admin -username ... -hospital ..."
+  if len(os.Args) > 1 {
+    if err := runCommand(db, os.Args[1], os.Args[2:]); err != nil {
+      log.Fatalf("Error running %s: %v", os.Args[1], err)
+    }
+    return
+  }
+
+  // Load the keys that sign access tokens; there is no fallback secret
+  keyConfigs, err := config.GetJWTKeyConfigs()
+  if err != nil {
+    log.Fatalf("Error configuring JWT signing keys: %v", err)
+  }
+  keyset, err := services.LoadKeyset(keyConfigs, time.Now())
+  if err != nil {
+    log.Fatalf("Error loading JWT signing keys: %v", err)
+  }
+  services.SetKeyset(keyset)
+
+  // Register upstream hospital adapters; lookups need the patient's consent
+  hospitals, err := services.NewHospitalRegistryFromConfig(config.GetHospitalConfigs())
+  if err != nil {
+    log.Fatalf("Error configuring hospital adapters: %v", err)
+  }
+  hospitals.RequireConsent(services.NewSQLConsentStore(db))
+
+  // Sensitive patient fields are masked according to the caller's role and hospital
+  disclosure, err := services.NewDisclosurePolicy(config.GetDisclosureConfig())
+  if err != nil {
+    log.Fatalf("Error configuring PII disclosure: %v", err)
+  }
+  services.SetDisclosurePolicy(disclosure)
+
+  // Hospitals whose staff sign in with their own identity provider
+  oidcProviders, err := services.NewOIDCProviders(config.GetOIDCConfigs())
+  if err != nil {
+    log.Fatalf("Error configuring single sign-on: %v", err)
+  }
+
+  // Revoked access tokens are checked on every request and pruned once expired.
+  // Emergency access tokens can outlive regular ones, so both lifetimes count.
+  revocations := services.NewSQLTokenRevocationStore(db, max(config.GetAccessTokenTTL(), config.GetBreakGlassTTL()))
+  go services.PruneRevocations(context.Background(), revocations, config.GetRevocationPruneInterval())
+  apiKeys := services.NewSQLAPIKeyStore(db)
+  // Audit entries are hash-chained and sealed with a signed checkpoint every interval
+  auditLog := services.NewSQLAuditLogger(db)
+  go services.SealAuditLog(context.Background(), auditLog, config.GetAuditCheckpointInterval())
+
   // Initialize router
   router := mux.NewRouter()
+  router.Use(middleware.RequestID)
 
 	// Public routes
-	router.HandleFunc("/staff/create", handlers.CreateStaff(db)).Methods("POST")
+	router.HandleFunc("/.well-known/jwks.json", handlers.JWKS(keyset)).Methods("GET")
 	router.HandleFunc("/staff/login", handlers.LoginStaff(db)).Methods("POST")
+	router.HandleFunc("/staff/login/mfa", handlers.VerifyMFALogin(db)).Methods("POST")
+	router.HandleFunc("/staff/login/mfa/enroll", handlers.EnrollMFAForLogin(db)).Methods("POST")
+	router.HandleFunc("/staff/login/oidc", handlers.StartOIDCLogin(db, oidcProviders)).Methods("GET")
+	router.HandleFunc("/staff/login/oidc/callback", handlers.OIDCCallback(db, oidcProviders)).Methods("GET")
+	router.HandleFunc("/staff/token/refresh", handlers.RefreshToken(db)).Methods("POST")
+	router.HandleFunc("/staff/invitations/redeem", handlers.RedeemStaffInvitation(db)).Methods("POST")
 	
-	// Protected routes
+	// Protected routes; each declares the permission its role must grant
+	staffRouter := router.PathPrefix("/staff").Subrouter()
+	staffRouter.Use(middleware.Authenticate(revocations, nil))
+	staffRouter.HandleFunc("/logout", handlers.Logout(db, revocations)).Methods("POST")
+	staffRouter.HandleFunc("/password/change", handlers.ChangePassword(db, revocations)).Methods("POST")
+	staffRouter.HandleFunc("/mfa/enroll", handlers.EnrollMFA(db)).Methods("POST")
+	staffRouter.HandleFunc("/mfa/confirm", handlers.ConfirmMFA(db)).Methods("POST")
+	staffRouter.HandleFunc("/invitations", middleware.RequirePermission(handlers.CreateStaffInvitation(db), services.PermStaffManage)).Methods("POST")
+	staffRouter.HandleFunc("/{id:[0-9]+}/role", middleware.RequirePermission(handlers.AssignStaffRole(db, revocations), services.PermStaffManage)).Methods("PUT")
+	staffRouter.HandleFunc("/break-glass", middleware.Audit(middleware.RequirePermission(handlers.StartBreakGlass(db), services.PermBreakGlass), auditLog, models.AuditActionBreakGlassStart)).Methods("POST")
+
 	patientRouter := router.PathPrefix("/patient").Subrouter()
-	patientRouter.Use(middleware.Authenticate)
-	patientRouter.HandleFunc("/search", handlers.SearchPatient(db)).Methods("GET")  
+	// Integration clients may also use API keys, limited to their scopes
+	patientRouter.Use(middleware.Authenticate(revocations, apiKeys))
+	// Every patient request is audited, including those turned away
+	patientRouter.HandleFunc("/search", middleware.Audit(middleware.RequirePermission(handlers.SearchPatient(db, hospitals), services.PermPatientSearch), auditLog, models.AuditActionPatientSearch)).Methods("GET")
+	patientRouter.HandleFunc("/search/federated", middleware.Audit(middleware.RequirePermission(handlers.FederatedSearchPatient(db, hospitals), services.PermPatientSearchFederated), auditLog, models.AuditActionPatientSearchFederated)).Methods("GET")
+	patientRouter.HandleFunc("", middleware.Audit(middleware.RequirePermission(handlers.CreatePatient(db), services.PermPatientWrite), auditLog, models.AuditActionPatientCreate)).Methods("POST")
+	patientRouter.HandleFunc("/{id:[0-9]+}", middleware.Audit(middleware.RequirePermission(handlers.GetPatient(db), services.PermPatientRead), auditLog, models.AuditActionPatientRead)).Methods("GET")
+	patientRouter.HandleFunc("/{id:[0-9]+}", middleware.Audit(middleware.RequirePermission(handlers.UpdatePatient(db), services.PermPatientWrite), auditLog, models.AuditActionPatientUpdate)).Methods("PUT", "PATCH")
+	patientRouter.HandleFunc("/{id:[0-9]+}", middleware.Audit(middleware.RequirePermission(handlers.DeletePatient(db), services.PermPatientDelete), auditLog, models.AuditActionPatientDelete)).Methods("DELETE")
+
+	auditRouter := router.PathPrefix("/audit").Subrouter()
+	auditRouter.Use(middleware.Authenticate(revocations, nil))
+	auditRouter.HandleFunc("", middleware.Audit(middleware.RequirePermission(handlers.ListAuditLog(db), services.PermAuditRead), auditLog, models.AuditActionAuditRead)).Methods("GET")
+
+	consentRouter := router.PathPrefix("/consents").Subrouter()
+	consentRouter.Use(middleware.Authenticate(revocations, nil))
+	consentRouter.HandleFunc("", middleware.Audit(middleware.RequirePermission(handlers.RecordConsent(db), services.PermConsentManage), auditLog, models.AuditActionConsentGrant)).Methods("POST")
+	consentRouter.HandleFunc("", middleware.Audit(middleware.RequirePermission(handlers.ListConsents(db), services.PermConsentManage), auditLog, models.AuditActionConsentList)).Methods("GET")
+	consentRouter.HandleFunc("/{id:[0-9]+}", middleware.Audit(middleware.RequirePermission(handlers.RevokeConsent(db), services.PermConsentManage), auditLog, models.AuditActionConsentRevoke)).Methods("DELETE")
+
+	breakGlassRouter := router.PathPrefix("/break-glass").Subrouter()
+	breakGlassRouter.Use(middleware.Authenticate(revocations, nil))
+	breakGlassRouter.HandleFunc("/reviews", middleware.Audit(middleware.RequirePermission(handlers.ListBreakGlassReviews(db), services.PermBreakGlassReview), auditLog, models.AuditActionBreakGlassReviewList)).Methods("GET")
+	breakGlassRouter.HandleFunc("/reviews/{id:[0-9]+}", middleware.Audit(middleware.RequirePermission(handlers.ReviewBreakGlass(db), services.PermBreakGlassReview), auditLog, models.AuditActionBreakGlassReview)).Methods("POST")
+
+	adminRouter := router.PathPrefix("/admin").Subrouter()
+	adminRouter.Use(middleware.Authenticate(revocations, nil))
+	adminRouter.HandleFunc("/staff/{id:[0-9]+}/sessions", middleware.RequirePermission(handlers.RevokeStaffSessions(db, revocations), services.PermStaffManage)).Methods("DELETE")
+	adminRouter.HandleFunc("/staff/{id:[0-9]+}/lockout", middleware.RequirePermission(handlers.UnlockStaff(db), services.PermStaffManage)).Methods("DELETE")
+	adminRouter.HandleFunc("/staff/{id:[0-9]+}/password/reset", middleware.RequirePermission(handlers.ResetStaffPassword(db, revocations), services.PermStaffManage)).Methods("POST")
+	adminRouter.HandleFunc("/staff/{id:[0-9]+}/cross-hospital", middleware.Audit(middleware.RequirePermission(handlers.SetStaffCrossHospital(db, revocations), services.PermStaffManage), auditLog, models.AuditActionStaffCrossHospital)).Methods("PUT")
+	adminRouter.HandleFunc("/staff/{id:[0-9]+}/mfa", middleware.RequirePermission(handlers.ResetStaffMFA(db), services.PermStaffManage)).Methods("DELETE")
+	adminRouter.HandleFunc("/api-keys", middleware.RequirePermission(handlers.CreateAPIKey(db), services.PermHospitalManage)).Methods("POST")
+	adminRouter.HandleFunc("/api-keys", middleware.RequirePermission(handlers.ListAPIKeys(db), services.PermHospitalManage)).Methods("GET")
+	adminRouter.HandleFunc("/api-keys/{id:[0-9]+}", middleware.RequirePermission(handlers.RevokeAPIKey(db), services.PermHospitalManage)).Methods("DELETE")
+	adminRouter.HandleFunc("/cache/patients/{patient_id}", middleware.RequirePermission(handlers.InvalidatePatientCache(hospitals), services.PermHospitalManage)).Methods("DELETE")
+	adminRouter.HandleFunc("/hospitals/breakers", middleware.RequirePermission(handlers.HospitalBreakers(hospitals), services.PermHospitalManage)).Methods("GET")
 
   // Start server
   port := os.Getenv("PORT")
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/roasted99/hospital-middleware/internal/api/middleware"
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/roasted99/hospital-middleware/internal/utils"
+)
+
+// InvalidatePatientCache drops cached hospital lookups for a patient at the
+// caller's hospital
+func InvalidatePatientCache(hospitals *services.HospitalRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		staffCtx := r.Context().Value(middleware.StaffKey)
+		if staffCtx == nil {
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		staff := staffCtx.(*models.Staff)
+
+		patientID := mux.Vars(r)["patient_id"]
+		if patientID == "" {
+			utils.ResponseWithError(w, http.StatusBadRequest, "Patient ID is required")
+			return
+		}
+
+		invalidated, ok := hospitals.InvalidatePatient(staff.Hospital, patientID)
+		if !ok {
+			utils.ResponseWithError(w, http.StatusNotFound, "No cache configured for "+staff.Hospital)
+			return
+		}
+
+		utils.ResponseWithSuccess(w, http.StatusOK, models.CacheInvalidationResponse{
+			Hospital:    staff.Hospital,
+			PatientID:   patientID,
+			Invalidated: invalidated,
+		})
+	}
+}
+
+// HospitalBreakers reports the circuit breaker state of every upstream hospital
+func HospitalBreakers(hospitals *services.HospitalRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		utils.ResponseWithSuccess(w, http.StatusOK, hospitals.BreakerStatuses())
+	}
+}
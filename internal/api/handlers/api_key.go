@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/roasted99/hospital-middleware/internal/utils"
+)
+
+// CreateAPIKey issues an API key for the caller's hospital. The key is only
+// returned once; afterwards it is identified by its prefix.
+func CreateAPIKey(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		staff, ok := staffFromContext(r)
+		if !ok {
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		var request models.APIKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			utils.ResponseWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		request.Name = strings.TrimSpace(request.Name)
+		if request.Name == "" || len(request.Scopes) == 0 {
+			utils.ResponseWithError(w, http.StatusBadRequest, "Name and scopes are required")
+			return
+		}
+		for _, scope := range request.Scopes {
+			if !services.IsValidAPIKeyScope(scope) {
+				utils.ResponseWithError(w, http.StatusBadRequest, "Scope "+scope+" cannot be granted to an API key")
+				return
+			}
+		}
+		if request.ExpiresAt != nil && !request.ExpiresAt.After(time.Now()) {
+			utils.ResponseWithError(w, http.StatusBadRequest, "Expiry must be in the future")
+			return
+		}
+
+		key, prefix, keyHash, err := services.GenerateAPIKey()
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Failed to generate API key")
+			return
+		}
+
+		created := models.APIKey{
+			Name:      request.Name,
+			Hospital:  staff.Hospital,
+			Prefix:    prefix,
+			Scopes:    request.Scopes,
+			ExpiresAt: request.ExpiresAt,
+			CreatedBy: &staff.ID,
+		}
+		err = db.QueryRowContext(r.Context(), "INSERT INTO api_keys (name, hospital, key_prefix, key_hash, scopes, expires_at, created_by) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at",
+			created.Name, created.Hospital, prefix, keyHash, pq.Array(created.Scopes), created.ExpiresAt, staff.ID).Scan(&created.ID, &created.CreatedAt)
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+
+		utils.ResponseWithSuccess(w, http.StatusCreated, models.APIKeyCreatedResponse{APIKey: created, Key: key})
+	}
+}
+
+// ListAPIKeys returns the API keys of the caller's hospital, including revoked
+// and expired ones, without the keys themselves
+func ListAPIKeys(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		staff, ok := staffFromContext(r)
+		if !ok {
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		rows, err := db.QueryContext(r.Context(), "SELECT id, name, hospital, key_prefix, scopes, expires_at, last_used_at, revoked_at, created_by, created_at FROM api_keys WHERE hospital = $1 ORDER BY created_at DESC, id DESC",
+			staff.Hospital)
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		defer rows.Close()
+
+		keys := []models.APIKey{}
+		for rows.Next() {
+			var key models.APIKey
+			var expiresAt, lastUsedAt, revokedAt sql.NullTime
+			var createdBy sql.NullInt64
+			if err := rows.Scan(&key.ID, &key.Name, &key.Hospital, &key.Prefix, pq.Array(&key.Scopes), &expiresAt, &lastUsedAt, &revokedAt, &createdBy, &key.CreatedAt); err != nil {
+				utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+				return
+			}
+			key.ExpiresAt = timePtr(expiresAt)
+			key.LastUsedAt = timePtr(lastUsedAt)
+			key.RevokedAt = timePtr(revokedAt)
+			if createdBy.Valid {
+				id := int(createdBy.Int64)
+				key.CreatedBy = &id
+			}
+			keys = append(keys, key)
+		}
+		if err := rows.Err(); err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+
+		utils.ResponseWithSuccess(w, http.StatusOK, keys)
+	}
+}
+
+// RevokeAPIKey stops an API key of the caller's hospital from authenticating
+func RevokeAPIKey(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		staff, ok := staffFromContext(r)
+		if !ok {
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		keyID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil || keyID <= 0 {
+			utils.ResponseWithError(w, http.StatusBadRequest, "Invalid API key ID")
+			return
+		}
+
+		result, err := db.ExecContext(r.Context(), "UPDATE api_keys SET revoked_at = COALESCE(revoked_at, NOW()) WHERE id = $1 AND hospital = $2", keyID, staff.Hospital)
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if updated, _ := result.RowsAffected(); updated == 0 {
+			utils.ResponseWithError(w, http.StatusNotFound, "API key not found")
+			return
+		}
+
+		utils.ResponseWithJSON(w, http.StatusOK, "API key revoked", nil)
+	}
+}
+
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
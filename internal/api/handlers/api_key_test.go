@@ -0,0 +1,119 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/roasted99/hospital-middleware/internal/api/handlers"
+	"github.com/roasted99/hospital-middleware/internal/api/middleware"
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func apiKeyRequest(method, target, body string, vars map[string]string) *http.Request {
+	admin := &models.Staff{ID: 1, Username: "admin", Hospital: "Hospital A", Role: "admin"}
+	req := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+	req = req.WithContext(context.WithValue(req.Context(), middleware.StaffKey, admin))
+	if vars != nil {
+		req = mux.SetURLVars(req, vars)
+	}
+	return req
+}
+
+func TestCreateAPIKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("INSERT INTO api_keys \\(name, hospital, key_prefix, key_hash, scopes, expires_at, created_by\\)").
+		WithArgs("lobby kiosk", "Hospital A", sqlmock.AnyArg(), sqlmock.AnyArg(), "{\"patient:search\"}", nil, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(4, time.Now()))
+
+	rr := httptest.NewRecorder()
+	handlers.CreateAPIKey(db)(rr, apiKeyRequest(http.MethodPost, "/admin/api-keys", `{"name":"lobby kiosk","scopes":["patient:search"]}`, nil))
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	var response struct {
+		Data models.APIKeyCreatedResponse `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, 4, response.Data.ID)
+	assert.True(t, strings.HasPrefix(response.Data.Key, response.Data.Prefix))
+	assert.Equal(t, []string{"patient:search"}, response.Data.Scopes)
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "admin scopes cannot be granted", body: `{"name":"batch","scopes":["staff:manage"]}`},
+		{name: "scopes are required", body: `{"name":"batch","scopes":[]}`},
+		{name: "expiry must be in the future", body: `{"name":"batch","scopes":["patient:read"],"expires_at":"2020-01-01T00:00:00Z"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handlers.CreateAPIKey(db)(rr, apiKeyRequest(http.MethodPost, "/admin/api-keys", tt.body, nil))
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+		})
+	}
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListAPIKeys(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	lastUsed := time.Now().Add(-time.Hour)
+	mock.ExpectQuery("SELECT id, name, hospital, key_prefix, scopes, expires_at, last_used_at, revoked_at, created_by, created_at FROM api_keys WHERE hospital = \\$1").
+		WithArgs("Hospital A").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "hospital", "key_prefix", "scopes", "expires_at", "last_used_at", "revoked_at", "created_by", "created_at"}).
+			AddRow(4, "lobby kiosk", "Hospital A", "hmk_abcdefgh", "{patient:search}", nil, lastUsed, nil, 1, time.Now()))
+
+	rr := httptest.NewRecorder()
+	handlers.ListAPIKeys(db)(rr, apiKeyRequest(http.MethodGet, "/admin/api-keys", "", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var response struct {
+		Data []map[string]interface{} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	require.Len(t, response.Data, 1)
+	assert.Equal(t, "hmk_abcdefgh", response.Data[0]["prefix"])
+	assert.NotContains(t, response.Data[0], "key")
+	assert.Contains(t, response.Data[0], "last_used_at")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectExec("UPDATE api_keys SET revoked_at = COALESCE\\(revoked_at, NOW\\(\\)\\) WHERE id = \\$1 AND hospital = \\$2").
+		WithArgs(4, "Hospital A").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE api_keys SET revoked_at").
+		WithArgs(5, "Hospital A").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	rr := httptest.NewRecorder()
+	handlers.RevokeAPIKey(db)(rr, apiKeyRequest(http.MethodDelete, "/admin/api-keys/4", "", map[string]string{"id": "4"}))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = httptest.NewRecorder()
+	handlers.RevokeAPIKey(db)(rr, apiKeyRequest(http.MethodDelete, "/admin/api-keys/5", "", map[string]string{"id": "5"}))
+	assert.Equal(t, http.StatusNotFound, rr.Code, "keys of other hospitals are not found")
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/roasted99/hospital-middleware/internal/api/middleware"
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/roasted99/hospital-middleware/internal/utils"
+)
+
+// auditEntry returns the entry recorded for the request. Outside of
+// middleware.Audit, e.g. in tests, it returns a throwaway entry.
+func auditEntry(r *http.Request) *models.AuditEntry {
+	if entry := middleware.AuditEntryFromContext(r.Context()); entry != nil {
+		return entry
+	}
+	return &models.AuditEntry{Criteria: map[string]string{}}
+}
+
+// searchCriteria lists the search parameters that were set
+func searchCriteria(query models.PatientSearchRequest) map[string]string {
+	criteria := map[string]string{}
+	for name, value := range map[string]string{
+		"national_id":   query.NationalID,
+		"passport_id":   query.PassportID,
+		"patient_hn":    query.PatientHN,
+		"first_name":    query.FirstName,
+		"middle_name":   query.MiddleName,
+		"last_name":     query.LastName,
+		"date_of_birth": query.DateOfBirth,
+		"phone_number":  query.PhoneNumber,
+		"email":         query.Email,
+	} {
+		if value != "" {
+			criteria[name] = value
+		}
+	}
+	return criteria
+}
+
+// patientAuditID identifies a patient in the audit log. Upstream hospitals do
+// not return our IDs, so their patients are identified by HN.
+func patientAuditID(patient models.Patient) string {
+	switch {
+	case patient.ID != 0:
+		return strconv.Itoa(patient.ID)
+	case patient.PatientHN != "":
+		return "hn:" + patient.PatientHN
+	case patient.NationalID != "":
+		return "national_id:" + patient.NationalID
+	default:
+		return "passport_id:" + patient.PassportID
+	}
+}
+
+func patientAuditIDs(patients []models.Patient) []string {
+	ids := make([]string, 0, len(patients))
+	for _, patient := range patients {
+		ids = append(ids, patientAuditID(patient))
+	}
+	return ids
+}
+
+// ListAuditLog returns audit entries of the caller's hospital, newest first.
+// Entries can be filtered by staff_id, username, action, outcome, patient_id,
+// request_id, break_glass_id or break_glass=true and an RFC 3339 from/to
+// range, and are paged by cursor. Patient identifiers in the recorded search
+// criteria and patient IDs are disclosed by the caller's role like patient
+// records.
+func ListAuditLog(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		staff, ok := staffFromContext(r)
+		if !ok {
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		query := r.URL.Query()
+		audit := auditEntry(r)
+		for name := range query {
+			audit.Criteria[name] = query.Get(name)
+		}
+
+		conditions := []string{"hospital = $1"}
+		args := []interface{}{staff.Hospital}
+		where := func(condition string, arg interface{}) {
+			args = append(args, arg)
+			conditions = append(conditions, strings.Replace(condition, "?", "$"+strconv.Itoa(len(args)), 1))
+		}
+
+		if value := query.Get("break_glass_id"); value != "" {
+			breakGlassID, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				utils.ResponseWithError(w, http.StatusBadRequest, "break_glass_id must be a number")
+				return
+			}
+			where("break_glass_id = ?", breakGlassID)
+		}
+		if emergency, _ := strconv.ParseBool(query.Get("break_glass")); emergency {
+			conditions = append(conditions, "break_glass_id IS NOT NULL")
+		}
+		if value := query.Get("staff_id"); value != "" {
+			staffID, err := strconv.Atoi(value)
+			if err != nil {
+				utils.ResponseWithError(w, http.StatusBadRequest, "staff_id must be a number")
+				return
+			}
+			where("staff_id = ?", staffID)
+		}
+		for _, filter := range []struct{ param, condition string }{
+			{"username", "username = ?"},
+			{"action", "action = ?"},
+			{"outcome", "outcome = ?"},
+			{"patient_id", "? = ANY(patient_ids)"},
+			{"request_id", "request_id = ?"},
+		} {
+			if value := query.Get(filter.param); value != "" {
+				where(filter.condition, value)
+			}
+		}
+		for _, filter := range []struct{ param, condition string }{
+			{"from", "occurred_at >= ?"},
+			{"to", "occurred_at < ?"},
+		} {
+			if value := query.Get(filter.param); value != "" {
+				at, err := time.Parse(time.RFC3339, value)
+				if err != nil {
+					utils.ResponseWithError(w, http.StatusBadRequest, filter.param+" must be an RFC 3339 time")
+					return
+				}
+				where(filter.condition, at)
+			}
+		}
+
+		limit := defaultPageLimit
+		if value := query.Get("limit"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 1 || parsed > maxPageLimit {
+				utils.ResponseWithError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxPageLimit))
+				return
+			}
+			limit = parsed
+		}
+		// The cursor is the ID of the last entry of the previous page
+		if value := query.Get("cursor"); value != "" {
+			before, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				utils.ResponseWithError(w, http.StatusBadRequest, "cursor is invalid")
+				return
+			}
+			where("id < ?", before)
+		}
+
+		rows, err := db.QueryContext(r.Context(),
+			"SELECT "+services.AuditEntryColumns+" FROM audit_log WHERE "+
+				strings.Join(conditions, " AND ")+" ORDER BY id DESC LIMIT "+strconv.Itoa(limit+1),
+			args...)
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		defer rows.Close()
+
+		policy := services.CurrentDisclosurePolicy()
+		entries := []models.AuditEntry{}
+		for rows.Next() {
+			entry, err := services.ScanAuditEntry(rows)
+			if err != nil {
+				utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+				return
+			}
+			entry.Criteria = policy.DiscloseCriteria(staff.Hospital, disclosureRole(r, staff), entry.Criteria)
+			entry.PatientIDs = policy.DisclosePatientIDs(staff.Hospital, disclosureRole(r, staff), entry.PatientIDs)
+			entries = append(entries, *entry)
+		}
+		if err := rows.Err(); err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+
+		meta := models.PageMeta{Limit: limit}
+		if len(entries) > limit {
+			entries = entries[:limit]
+			meta.HasMore = true
+			meta.NextCursor = strconv.FormatInt(entries[limit-1].ID, 10)
+		}
+		utils.ResponseWithPage(w, http.StatusOK, entries, meta)
+	}
+}
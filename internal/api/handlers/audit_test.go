@@ -0,0 +1,91 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/roasted99/hospital-middleware/internal/api/handlers"
+	"github.com/roasted99/hospital-middleware/internal/api/middleware"
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var auditColumns = []string{"id", "occurred_at", "request_id", "action", "staff_id", "api_key_id", "username", "hospital", "role", "criteria", "patient_ids", "data_source", "client_ip", "outcome", "status_code", "break_glass_id", "prev_hash", "entry_hash"}
+
+func auditorRequest(target string) *http.Request {
+	auditor := &models.Staff{ID: 8, Username: "auditor1", Hospital: "Hospital A", Role: services.RoleAuditor}
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	return req.WithContext(context.WithValue(req.Context(), middleware.StaffKey, auditor))
+}
+
+func TestListAuditLog(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT .+ FROM audit_log WHERE hospital = \\$1 AND staff_id = \\$2 AND \\$3 = ANY\\(patient_ids\\) AND occurred_at >= \\$4 AND id < \\$5 ORDER BY id DESC LIMIT 3").
+		WithArgs("Hospital A", 3, "7", from, int64(40)).
+		WillReturnRows(sqlmock.NewRows(auditColumns).
+			AddRow(39, time.Now(), "req-3", "patient.read", 3, nil, "nurse1", "Hospital A", "nurse", []byte(`{"patient_id":"7"}`), "{7}", "local", "192.0.2.1", "success", 200, nil, nil, nil).
+			AddRow(35, time.Now(), "req-2", "patient.search", 3, nil, "nurse1", "Hospital A", "nurse", []byte(`{"national_id":"1101500234564","purpose":"treatment"}`), "{national_id:1101500234564,\"Hospital B:passport_id:AA1234567\"}", "federated", "192.0.2.1", "success", 200, nil, nil, nil).
+			AddRow(30, time.Now(), "req-1", "patient.search", 3, nil, "nurse1", "Hospital A", "nurse", []byte(`{"last_name":"Meesuk"}`), "{7}", "local", "192.0.2.1", "success", 200, nil, nil, nil))
+
+	rr := httptest.NewRecorder()
+	handlers.ListAuditLog(db)(rr, auditorRequest("/audit?staff_id=3&patient_id=7&from=2024-05-01T00:00:00Z&cursor=40&limit=2"))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var response struct {
+		Data []models.AuditEntry `json:"data"`
+		Meta models.PageMeta     `json:"meta"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	require.Len(t, response.Data, 2)
+	assert.Equal(t, "req-3", response.Data[0].RequestID)
+	assert.Equal(t, map[string]string{"patient_id": "7"}, response.Data[0].Criteria)
+	// Auditors see which identifier was searched for, but not its value
+	assert.Equal(t, map[string]string{"national_id": "", "purpose": "treatment"}, response.Data[1].Criteria)
+	assert.Equal(t, []string{"national_id:", "Hospital B:passport_id:"}, response.Data[1].PatientIDs)
+	assert.NotContains(t, rr.Body.String(), "1101500234564")
+	assert.NotContains(t, rr.Body.String(), "AA1234567")
+	assert.True(t, response.Meta.HasMore)
+	assert.Equal(t, "35", response.Meta.NextCursor)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	rr = httptest.NewRecorder()
+	handlers.ListAuditLog(db)(rr, auditorRequest("/audit?from=yesterday"))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestSearchPatientIsAudited(t *testing.T) {
+	client := &stubHospitalClient{patients: []models.Patient{{PatientHN: "HN-00123", LastNameEN: "Meesuk"}}}
+
+	var recorded *models.AuditEntry
+	logger := auditLoggerFunc(func(entry *models.AuditEntry) { recorded = entry })
+	handler := middleware.Audit(handlers.SearchPatient(nil, newStubRegistry("Hospital A", client)), logger, models.AuditActionPatientSearch)
+
+	rr := httptest.NewRecorder()
+	handler(rr, patientRecordRequest(http.MethodGet, "/patient/search?last_name=Meesuk", "", ""))
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NotNil(t, recorded)
+	assert.Equal(t, map[string]string{"last_name": "Meesuk", "purpose": "treatment"}, recorded.Criteria)
+	assert.Equal(t, "Hospital A", recorded.DataSource)
+	assert.Equal(t, []string{"hn:HN-00123"}, recorded.PatientIDs)
+	assert.Equal(t, 1, *recorded.StaffID)
+	assert.Equal(t, models.AuditOutcomeSuccess, recorded.Outcome)
+}
+
+type auditLoggerFunc func(entry *models.AuditEntry)
+
+func (f auditLoggerFunc) Record(ctx context.Context, entry *models.AuditEntry) error {
+	f(entry)
+	return nil
+}
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/roasted99/hospital-middleware/internal/config"
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/roasted99/hospital-middleware/internal/utils"
+)
+
+// StartBreakGlass gives a clinician emergency access: a short-lived token that
+// may search and read any patient, across hospitals and without consent.
+// Every request made with it is flagged in the audit log and the event waits
+// in the privacy officers' review queue.
+func StartBreakGlass(db *sql.DB) http.HandlerFunc {
+	trustProxy := config.GetTrustProxyHeaders()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		staff, ok := staffFromContext(r)
+		if !ok {
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		if staff.BreakGlassID != 0 {
+			utils.ResponseWithError(w, http.StatusBadRequest, "Emergency access is already active")
+			return
+		}
+
+		var request models.BreakGlassRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			utils.ResponseWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		request.ReasonCode = strings.TrimSpace(request.ReasonCode)
+		request.Justification = strings.TrimSpace(request.Justification)
+		audit := auditEntry(r)
+		audit.Criteria["reason_code"] = request.ReasonCode
+
+		if err := services.ValidateBreakGlassRequest(request); err != nil {
+			utils.ResponseWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		ttl := config.GetBreakGlassTTL()
+		response := models.BreakGlassResponse{ExpiresIn: int(ttl.Seconds()), ExpiresAt: time.Now().Add(ttl)}
+		err := db.QueryRowContext(r.Context(), "INSERT INTO break_glass_events (staff_id, username, hospital, role, reason_code, justification, client_ip, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id",
+			staff.ID, staff.Username, staff.Hospital, staff.Role, request.ReasonCode, request.Justification, utils.ClientIP(r, trustProxy), response.ExpiresAt).
+			Scan(&response.BreakGlassID)
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		audit.BreakGlassID = &response.BreakGlassID
+
+		response.Token, err = services.GenerateBreakGlassJWT(*staff, response.BreakGlassID, response.ExpiresAt)
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Failed to generate token")
+			return
+		}
+
+		utils.ResponseWithSuccess(w, http.StatusCreated, response)
+	}
+}
+
+// ListBreakGlassReviews is the review queue of the caller's hospital: emergency
+// accesses oldest first, by default only those not reviewed yet
+// (status=unreviewed, reviewed or all), paged by cursor
+func ListBreakGlassReviews(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		staff, ok := staffFromContext(r)
+		if !ok {
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		query := r.URL.Query()
+		audit := auditEntry(r)
+		for name := range query {
+			audit.Criteria[name] = query.Get(name)
+		}
+
+		conditions := []string{"e.hospital = $1"}
+		args := []interface{}{staff.Hospital}
+
+		switch query.Get("status") {
+		case "", "unreviewed":
+			conditions = append(conditions, "e.reviewed_at IS NULL")
+		case "reviewed":
+			conditions = append(conditions, "e.reviewed_at IS NOT NULL")
+		case "all":
+		default:
+			utils.ResponseWithError(w, http.StatusBadRequest, "status must be one of unreviewed, reviewed or all")
+			return
+		}
+
+		limit := defaultPageLimit
+		if value := query.Get("limit"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 1 || parsed > maxPageLimit {
+				utils.ResponseWithError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxPageLimit))
+				return
+			}
+			limit = parsed
+		}
+		// The cursor is the ID of the last event of the previous page
+		if value := query.Get("cursor"); value != "" {
+			after, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				utils.ResponseWithError(w, http.StatusBadRequest, "cursor is invalid")
+				return
+			}
+			args = append(args, after)
+			conditions = append(conditions, "e.id > $"+strconv.Itoa(len(args)))
+		}
+
+		rows, err := db.QueryContext(r.Context(),
+			"SELECT e.id, e.staff_id, e.username, e.hospital, e.role, e.reason_code, e.justification, e.client_ip, e.started_at, e.expires_at, "+
+				"(SELECT COUNT(*) FROM audit_log a WHERE a.break_glass_id = e.id), e.reviewed_at, e.reviewed_by, e.review_decision, e.review_notes "+
+				"FROM break_glass_events e WHERE "+strings.Join(conditions, " AND ")+" ORDER BY e.id LIMIT "+strconv.Itoa(limit+1),
+			args...)
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		defer rows.Close()
+
+		events := []models.BreakGlassEvent{}
+		for rows.Next() {
+			var event models.BreakGlassEvent
+			var staffID, reviewedBy sql.NullInt64
+			var reviewedAt sql.NullTime
+			var decision, notes sql.NullString
+			if err := rows.Scan(&event.ID, &staffID, &event.Username, &event.Hospital, &event.Role, &event.ReasonCode, &event.Justification, &event.ClientIP,
+				&event.StartedAt, &event.ExpiresAt, &event.Accesses, &reviewedAt, &reviewedBy, &decision, &notes); err != nil {
+				utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+				return
+			}
+			if staffID.Valid {
+				id := int(staffID.Int64)
+				event.StaffID = &id
+			}
+			if reviewedBy.Valid {
+				id := int(reviewedBy.Int64)
+				event.ReviewedBy = &id
+			}
+			event.ReviewedAt = timePtr(reviewedAt)
+			event.ReviewDecision = decision.String
+			event.ReviewNotes = notes.String
+			events = append(events, event)
+		}
+		if err := rows.Err(); err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+
+		meta := models.PageMeta{Limit: limit}
+		if len(events) > limit {
+			events = events[:limit]
+			meta.HasMore = true
+			meta.NextCursor = strconv.FormatInt(events[limit-1].ID, 10)
+		}
+		utils.ResponseWithPage(w, http.StatusOK, events, meta)
+	}
+}
+
+// ReviewBreakGlass records a privacy officer's decision on an emergency access
+// of the caller's hospital. Nobody reviews their own emergency access.
+func ReviewBreakGlass(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		staff, ok := staffFromContext(r)
+		if !ok {
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		eventID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil || eventID <= 0 {
+			utils.ResponseWithError(w, http.StatusBadRequest, "Invalid break-glass ID")
+			return
+		}
+
+		audit := auditEntry(r)
+		audit.Criteria["break_glass_id"] = strconv.FormatInt(eventID, 10)
+
+		var request models.BreakGlassReviewRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			utils.ResponseWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		request.Notes = strings.TrimSpace(request.Notes)
+		audit.Criteria["decision"] = request.Decision
+		if !services.IsValidBreakGlassDecision(request.Decision) {
+			utils.ResponseWithError(w, http.StatusBadRequest, "decision must be justified or unjustified")
+			return
+		}
+		if request.Decision == services.BreakGlassUnjustified && request.Notes == "" {
+			utils.ResponseWithError(w, http.StatusBadRequest, "notes are required for an unjustified access")
+			return
+		}
+
+		var staffID sql.NullInt64
+		var reviewedAt sql.NullTime
+		err = db.QueryRowContext(r.Context(), "SELECT staff_id, reviewed_at FROM break_glass_events WHERE id = $1 AND hospital = $2", eventID, staff.Hospital).
+			Scan(&staffID, &reviewedAt)
+		if err == sql.ErrNoRows {
+			utils.ResponseWithError(w, http.StatusNotFound, "Break-glass event not found")
+			return
+		}
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if staffID.Valid && int(staffID.Int64) == staff.ID {
+			utils.ResponseWithError(w, http.StatusForbidden, "You cannot review your own emergency access")
+			return
+		}
+
+		result, err := db.ExecContext(r.Context(), "UPDATE break_glass_events SET reviewed_at = NOW(), reviewed_by = $1, review_decision = $2, review_notes = $3 WHERE id = $4 AND reviewed_at IS NULL",
+			staff.ID, request.Decision, nullIfEmpty(request.Notes), eventID)
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if updated, _ := result.RowsAffected(); reviewedAt.Valid || updated == 0 {
+			utils.ResponseWithError(w, http.StatusConflict, "Break-glass event was already reviewed")
+			return
+		}
+
+		utils.ResponseWithJSON(w, http.StatusOK, "Break-glass event reviewed", nil)
+	}
+}
@@ -0,0 +1,183 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/roasted99/hospital-middleware/internal/api/handlers"
+	"github.com/roasted99/hospital-middleware/internal/api/middleware"
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func privacyOfficerRequest(method, target, body, id string) *http.Request {
+	officer := &models.Staff{ID: 9, Username: "privacy1", Hospital: "Hospital A", Role: services.RolePrivacyOfficer}
+	req := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+	req = req.WithContext(context.WithValue(req.Context(), middleware.StaffKey, officer))
+	if id != "" {
+		req = mux.SetURLVars(req, map[string]string{"id": id})
+	}
+	return req
+}
+
+func TestStartBreakGlass(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("INSERT INTO break_glass_events \\(staff_id, username, hospital, role, reason_code, justification, client_ip, expires_at\\)").
+		WithArgs(1, "doctor1", "Hospital A", services.RoleDoctor, services.BreakGlassReasonLifeThreatening, "Unconscious patient brought in by ambulance", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+
+	staff := &models.Staff{ID: 1, Username: "doctor1", Hospital: "Hospital A", Role: services.RoleDoctor}
+	body := `{"reason_code":"life_threatening","justification":"  Unconscious patient brought in by ambulance "}`
+	req := httptest.NewRequest(http.MethodPost, "/staff/break-glass", bytes.NewBufferString(body))
+	req = req.WithContext(context.WithValue(req.Context(), middleware.StaffKey, staff))
+
+	var recorded *models.AuditEntry
+	logger := auditLoggerFunc(func(entry *models.AuditEntry) { recorded = entry })
+	rr := httptest.NewRecorder()
+	middleware.Audit(handlers.StartBreakGlass(db), logger, models.AuditActionBreakGlassStart)(rr, req)
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	var response struct {
+		Data models.BreakGlassResponse `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, int64(42), response.Data.BreakGlassID)
+
+	claims, err := services.ValidateToken(response.Data.Token)
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), claims.BreakGlassID)
+	assert.True(t, claims.CrossHospital)
+	assert.WithinDuration(t, response.Data.ExpiresAt, claims.ExpiresAt.Time, time.Second)
+
+	require.NotNil(t, recorded)
+	require.NotNil(t, recorded.BreakGlassID)
+	assert.Equal(t, int64(42), *recorded.BreakGlassID)
+	assert.Equal(t, "life_threatening", recorded.Criteria["reason_code"])
+
+	// A justification is mandatory
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/staff/break-glass", bytes.NewBufferString(`{"reason_code":"other","justification":"because"}`))
+	handlers.StartBreakGlass(db)(rr, req.WithContext(context.WithValue(req.Context(), middleware.StaffKey, staff)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "justification")
+
+	// An emergency token cannot start another emergency access
+	elevated := *staff
+	elevated.BreakGlassID = 42
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/staff/break-glass", bytes.NewBufferString(body))
+	handlers.StartBreakGlass(db)(rr, req.WithContext(context.WithValue(req.Context(), middleware.StaffKey, &elevated)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListBreakGlassReviews(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	columns := []string{"id", "staff_id", "username", "hospital", "role", "reason_code", "justification", "client_ip", "started_at", "expires_at", "accesses", "reviewed_at", "reviewed_by", "review_decision", "review_notes"}
+	mock.ExpectQuery("SELECT .+ FROM break_glass_events e WHERE e.hospital = \\$1 AND e.reviewed_at IS NULL ORDER BY e.id LIMIT 3").
+		WithArgs("Hospital A").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(41, 1, "doctor1", "Hospital A", services.RoleDoctor, "life_threatening", "Unconscious patient brought in by ambulance", "10.0.0.1", time.Now(), time.Now(), 3, nil, nil, nil, nil).
+			AddRow(42, 2, "nurse1", "Hospital A", services.RoleNurse, "urgent_treatment", "Allergy history needed before surgery", "10.0.0.2", time.Now(), time.Now(), 1, nil, nil, nil, nil).
+			AddRow(43, 1, "doctor1", "Hospital A", services.RoleDoctor, "other", "Patient transferred without any records", "10.0.0.1", time.Now(), time.Now(), 0, nil, nil, nil, nil))
+
+	rr := httptest.NewRecorder()
+	handlers.ListBreakGlassReviews(db)(rr, privacyOfficerRequest(http.MethodGet, "/break-glass/reviews?limit=2", "", ""))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var response struct {
+		Data []models.BreakGlassEvent `json:"data"`
+		Meta models.PageMeta          `json:"meta"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	require.Len(t, response.Data, 2)
+	assert.Equal(t, 3, response.Data[0].Accesses)
+	assert.True(t, response.Meta.HasMore)
+	assert.Equal(t, "42", response.Meta.NextCursor)
+
+	rr = httptest.NewRecorder()
+	handlers.ListBreakGlassReviews(db)(rr, privacyOfficerRequest(http.MethodGet, "/break-glass/reviews?status=pending", "", ""))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReviewBreakGlass(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT staff_id, reviewed_at FROM break_glass_events WHERE id = \\$1 AND hospital = \\$2").
+		WithArgs(int64(42), "Hospital A").
+		WillReturnRows(sqlmock.NewRows([]string{"staff_id", "reviewed_at"}).AddRow(1, nil))
+	mock.ExpectExec("UPDATE break_glass_events SET reviewed_at = NOW\\(\\), reviewed_by = \\$1, review_decision = \\$2, review_notes = \\$3 WHERE id = \\$4 AND reviewed_at IS NULL").
+		WithArgs(9, services.BreakGlassJustified, nil, int64(42)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	var recorded *models.AuditEntry
+	logger := auditLoggerFunc(func(entry *models.AuditEntry) { recorded = entry })
+	rr := httptest.NewRecorder()
+	middleware.Audit(handlers.ReviewBreakGlass(db), logger, models.AuditActionBreakGlassReview)(rr,
+		privacyOfficerRequest(http.MethodPost, "/break-glass/reviews/42", `{"decision":"justified"}`, "42"))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	require.NotNil(t, recorded)
+	assert.Equal(t, map[string]string{"break_glass_id": "42", "decision": "justified"}, recorded.Criteria)
+
+	// Already reviewed
+	mock.ExpectQuery("SELECT staff_id, reviewed_at FROM break_glass_events").
+		WillReturnRows(sqlmock.NewRows([]string{"staff_id", "reviewed_at"}).AddRow(1, time.Now()))
+	mock.ExpectExec("UPDATE break_glass_events").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	rr = httptest.NewRecorder()
+	handlers.ReviewBreakGlass(db)(rr, privacyOfficerRequest(http.MethodPost, "/break-glass/reviews/42", `{"decision":"justified"}`, "42"))
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	// Nobody reviews their own emergency access
+	mock.ExpectQuery("SELECT staff_id, reviewed_at FROM break_glass_events").
+		WillReturnRows(sqlmock.NewRows([]string{"staff_id", "reviewed_at"}).AddRow(9, nil))
+
+	rr = httptest.NewRecorder()
+	handlers.ReviewBreakGlass(db)(rr, privacyOfficerRequest(http.MethodPost, "/break-glass/reviews/43", `{"decision":"justified"}`, "43"))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	// An unjustified access needs notes
+	rr = httptest.NewRecorder()
+	handlers.ReviewBreakGlass(db)(rr, privacyOfficerRequest(http.MethodPost, "/break-glass/reviews/42", `{"decision":"unjustified"}`, "42"))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSearchPatientWithBreakGlassSkipsConsent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	client := &stubHospitalClient{patients: []models.Patient{{NationalID: "1101500234564", PatientHN: "HN-00123"}}}
+	registry := newStubRegistry("Hospital A", client)
+	registry.RequireConsent(noConsentStore{})
+
+	staff := &models.Staff{ID: 1, Username: "doctor1", Hospital: "Hospital A", Role: services.RoleDoctor, CrossHospital: true, BreakGlassID: 42}
+	rr := httptest.NewRecorder()
+	handlers.SearchPatient(db, registry)(rr, createAuthenticatedRequest(http.MethodGet, "/patient/search?national_id=1101500234564", staff))
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "HN-00123")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
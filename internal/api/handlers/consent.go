@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/roasted99/hospital-middleware/internal/utils"
+)
+
+const consentColumns = "id, national_id, passport_id, requesting_hospital, purpose, granted_at, expires_at, revoked_at, hospital, recorded_by, created_at"
+
+// RecordConsent records a patient's consent, taken at the caller's hospital,
+// to let a hospital look up their records in other hospital systems
+func RecordConsent(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		staff, ok := staffFromContext(r)
+		if !ok {
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		var request models.ConsentRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			utils.ResponseWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		request.NationalID = strings.TrimSpace(request.NationalID)
+		request.PassportID = strings.TrimSpace(request.PassportID)
+		request.RequestingHospital = strings.TrimSpace(request.RequestingHospital)
+		if request.RequestingHospital == "" {
+			request.RequestingHospital = staff.Hospital
+		}
+		auditConsent(r, request.NationalID, request.PassportID)
+
+		if err := services.ValidateConsentRequest(request, time.Now()); err != nil {
+			utils.ResponseWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		consent := models.Consent{
+			NationalID:         request.NationalID,
+			PassportID:         request.PassportID,
+			RequestingHospital: request.RequestingHospital,
+			Purpose:            request.Purpose,
+			ExpiresAt:          request.ExpiresAt,
+			Hospital:           staff.Hospital,
+			RecordedBy:         &staff.ID,
+		}
+		err := db.QueryRowContext(r.Context(), "INSERT INTO patient_consents (national_id, passport_id, requesting_hospital, purpose, expires_at, hospital, recorded_by) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, granted_at, created_at",
+			nullIfEmpty(consent.NationalID), nullIfEmpty(consent.PassportID), consent.RequestingHospital, consent.Purpose, consent.ExpiresAt, consent.Hospital, staff.ID).
+			Scan(&consent.ID, &consent.GrantedAt, &consent.CreatedAt)
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+
+		utils.ResponseWithSuccess(w, http.StatusCreated, consent)
+	}
+}
+
+// ListConsents returns the consents of the patient identified by national_id or
+// passport_id that were recorded at or granted to the caller's hospital,
+// including expired and revoked ones
+func ListConsents(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		staff, ok := staffFromContext(r)
+		if !ok {
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		nationalID := strings.TrimSpace(r.URL.Query().Get("national_id"))
+		passportID := strings.TrimSpace(r.URL.Query().Get("passport_id"))
+		auditConsent(r, nationalID, passportID)
+		if nationalID == "" && passportID == "" {
+			utils.ResponseWithError(w, http.StatusBadRequest, "national_id or passport_id is required")
+			return
+		}
+
+		rows, err := db.QueryContext(r.Context(), "SELECT "+consentColumns+" FROM patient_consents WHERE (national_id = $1 OR passport_id = $2) AND (hospital = $3 OR requesting_hospital = $3) ORDER BY granted_at DESC, id DESC",
+			nullIfEmpty(nationalID), nullIfEmpty(passportID), staff.Hospital)
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		defer rows.Close()
+
+		consents := []models.Consent{}
+		for rows.Next() {
+			consent, err := scanConsent(rows)
+			if err != nil {
+				utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+				return
+			}
+			consents = append(consents, consent)
+		}
+		if err := rows.Err(); err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+
+		utils.ResponseWithSuccess(w, http.StatusOK, consents)
+	}
+}
+
+// RevokeConsent withdraws a consent recorded at or granted to the caller's
+// hospital; lookups relying on it are refused from then on
+func RevokeConsent(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		staff, ok := staffFromContext(r)
+		if !ok {
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		consentID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil || consentID <= 0 {
+			utils.ResponseWithError(w, http.StatusBadRequest, "Invalid consent ID")
+			return
+		}
+		auditEntry(r).Criteria = map[string]string{"consent_id": strconv.Itoa(consentID)}
+
+		var nationalID, passportID sql.NullString
+		err = db.QueryRowContext(r.Context(), "UPDATE patient_consents SET revoked_at = COALESCE(revoked_at, NOW()) WHERE id = $1 AND (hospital = $2 OR requesting_hospital = $2) RETURNING national_id, passport_id",
+			consentID, staff.Hospital).Scan(&nationalID, &passportID)
+		if err == sql.ErrNoRows {
+			utils.ResponseWithError(w, http.StatusNotFound, "Consent not found")
+			return
+		}
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		auditEntry(r).PatientIDs = consentAuditIDs(nationalID.String, passportID.String)
+
+		utils.ResponseWithJSON(w, http.StatusOK, "Consent revoked", nil)
+	}
+}
+
+// auditConsent notes the patient a consent request is about in its audit entry
+func auditConsent(r *http.Request, nationalID, passportID string) {
+	audit := auditEntry(r)
+	audit.DataSource = LocalSource
+	audit.PatientIDs = consentAuditIDs(nationalID, passportID)
+	for name, value := range map[string]string{"national_id": nationalID, "passport_id": passportID} {
+		if value != "" {
+			audit.Criteria[name] = value
+		}
+	}
+}
+
+func consentAuditIDs(nationalID, passportID string) []string {
+	if nationalID == "" && passportID == "" {
+		return []string{}
+	}
+	return patientAuditIDs([]models.Patient{{NationalID: nationalID, PassportID: passportID}})
+}
+
+func scanConsent(rows *sql.Rows) (models.Consent, error) {
+	var consent models.Consent
+	var nationalID, passportID sql.NullString
+	var expiresAt, revokedAt sql.NullTime
+	var recordedBy sql.NullInt64
+	err := rows.Scan(&consent.ID, &nationalID, &passportID, &consent.RequestingHospital, &consent.Purpose, &consent.GrantedAt, &expiresAt, &revokedAt,
+		&consent.Hospital, &recordedBy, &consent.CreatedAt)
+	if err != nil {
+		return consent, err
+	}
+	consent.NationalID = nationalID.String
+	consent.PassportID = passportID.String
+	consent.ExpiresAt = timePtr(expiresAt)
+	consent.RevokedAt = timePtr(revokedAt)
+	if recordedBy.Valid {
+		id := int(recordedBy.Int64)
+		consent.RecordedBy = &id
+	}
+	return consent, nil
+}
@@ -0,0 +1,152 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/roasted99/hospital-middleware/internal/api/handlers"
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noConsentStore has no consents at all
+type noConsentStore struct{}
+
+func (noConsentStore) HasConsent(ctx context.Context, nationalID, passportID, requestingHospital, purpose string, at time.Time) (bool, error) {
+	return false, nil
+}
+
+func TestRecordConsent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("INSERT INTO patient_consents \\(national_id, passport_id, requesting_hospital, purpose, expires_at, hospital, recorded_by\\)").
+		WithArgs("1101500234564", nil, "Hospital A", "treatment", nil, "Hospital A", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "granted_at", "created_at"}).AddRow(5, time.Now(), time.Now()))
+
+	rr := httptest.NewRecorder()
+	handlers.RecordConsent(db)(rr, patientRecordRequest(http.MethodPost, "/consents", `{"national_id":"1101500234564","purpose":"treatment"}`, ""))
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	var response struct {
+		Data models.Consent `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, 5, response.Data.ID)
+	assert.Equal(t, "Hospital A", response.Data.RequestingHospital, "defaults to the caller's hospital")
+
+	rr = httptest.NewRecorder()
+	handlers.RecordConsent(db)(rr, patientRecordRequest(http.MethodPost, "/consents", `{"national_id":"1101500234564","purpose":"marketing"}`, ""))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "purpose")
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListConsents(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	columns := []string{"id", "national_id", "passport_id", "requesting_hospital", "purpose", "granted_at", "expires_at", "revoked_at", "hospital", "recorded_by", "created_at"}
+	mock.ExpectQuery("SELECT .+ FROM patient_consents WHERE \\(national_id = \\$1 OR passport_id = \\$2\\) AND \\(hospital = \\$3 OR requesting_hospital = \\$3\\)").
+		WithArgs("1101500234564", nil, "Hospital A").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(5, "1101500234564", nil, "Hospital A", "treatment", time.Now(), nil, time.Now(), "Hospital A", 1, time.Now()))
+
+	rr := httptest.NewRecorder()
+	handlers.ListConsents(db)(rr, patientRecordRequest(http.MethodGet, "/consents?national_id=1101500234564", "", ""))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var response struct {
+		Data []models.Consent `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	require.Len(t, response.Data, 1)
+	assert.NotNil(t, response.Data[0].RevokedAt)
+
+	rr = httptest.NewRecorder()
+	handlers.ListConsents(db)(rr, patientRecordRequest(http.MethodGet, "/consents", "", ""))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRevokeConsent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("UPDATE patient_consents SET revoked_at = COALESCE\\(revoked_at, NOW\\(\\)\\) WHERE id = \\$1 AND \\(hospital = \\$2 OR requesting_hospital = \\$2\\)").
+		WithArgs(5, "Hospital A").
+		WillReturnRows(sqlmock.NewRows([]string{"national_id", "passport_id"}).AddRow("1101500234564", nil))
+	mock.ExpectQuery("UPDATE patient_consents SET revoked_at").
+		WithArgs(6, "Hospital A").
+		WillReturnRows(sqlmock.NewRows([]string{"national_id", "passport_id"}))
+
+	rr := httptest.NewRecorder()
+	handlers.RevokeConsent(db)(rr, patientRecordRequest(http.MethodDelete, "/consents/5", "", "5"))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = httptest.NewRecorder()
+	handlers.RevokeConsent(db)(rr, patientRecordRequest(http.MethodDelete, "/consents/6", "", "6"))
+	assert.Equal(t, http.StatusNotFound, rr.Code, "consents of other hospitals are not found")
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSearchPatientRequiresConsent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	client := &stubHospitalClient{patients: []models.Patient{{NationalID: "1101500234564", PatientHN: "HN-00123"}}}
+	registry := newStubRegistry("Hospital A", client)
+	registry.RequireConsent(noConsentStore{})
+
+	// The local records are still searched, and have no match
+	mock.ExpectQuery("SELECT .+ FROM patient WHERE hospital = \\$1 AND deleted_at IS NULL AND \\(national_id = \\$2\\)").
+		WillReturnRows(sqlmock.NewRows(patientRecordColumns))
+
+	rr := httptest.NewRecorder()
+	handlers.SearchPatient(db, registry)(rr, patientRecordRequest(http.MethodGet, "/patient/search?national_id=1101500234564", "", ""))
+
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Consent required")
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	rr = httptest.NewRecorder()
+	handlers.SearchPatient(db, registry)(rr, patientRecordRequest(http.MethodGet, "/patient/search?national_id=1101500234564&purpose=marketing", "", ""))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestFederatedSearchReportsMissingConsent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	registry := newStubRegistry("Hospital B", &stubHospitalClient{patients: []models.Patient{{PatientHN: "B-1"}}})
+	registry.RequireConsent(noConsentStore{})
+	mock.ExpectQuery("SELECT .+ FROM patient").WillReturnRows(sqlmock.NewRows(patientRecordColumns))
+
+	staff := &models.Staff{ID: 1, Username: "doctor1", Hospital: "Hospital A", Role: services.RoleDoctor, CrossHospital: true}
+	rr := httptest.NewRecorder()
+	handlers.FederatedSearchPatient(db, registry)(rr, createAuthenticatedRequest(http.MethodGet, "/patient/search/federated?national_id=1101500234564", staff))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var response struct {
+		Data models.FederatedSearchResponse `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Empty(t, response.Data.Patients)
+	require.Len(t, response.Data.Sources, 2)
+	assert.Equal(t, models.SourceStatusConsentRequired, response.Data.Sources[1].Status)
+}
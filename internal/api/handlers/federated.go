@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/roasted99/hospital-middleware/internal/api/middleware"
+	"github.com/roasted99/hospital-middleware/internal/config"
+	"github.com/roasted99/hospital-middleware/internal/db/patientquery"
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/roasted99/hospital-middleware/internal/utils"
+)
+
+// LocalSource identifies results that came from the middleware's own patient table
+const LocalSource = "local"
+
+// FederatedSearchPatient searches every registered hospital and the local patient
+// table concurrently. A failing source is reported in the response instead of
+// failing the whole request.
+func FederatedSearchPatient(db *sql.DB, hospitals *services.HospitalRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		staffCtx := r.Context().Value(middleware.StaffKey)
+		if staffCtx == nil {
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		staff := staffCtx.(*models.Staff)
+
+		if !staff.CrossHospital {
+			utils.ResponseWithError(w, http.StatusForbidden, "Cross-hospital search is not permitted")
+			return
+		}
+
+		query := patientSearchRequestFromQuery(r)
+		audit := auditEntry(r)
+		audit.Criteria = searchCriteria(query)
+		audit.DataSource = "federated"
+
+		builder := patientquery.AcrossHospitals().Match(query)
+		if !builder.HasCriteria() {
+			utils.ResponseWithError(w, http.StatusBadRequest, "At least one search parameter is required")
+			return
+		}
+
+		purpose, ok := consentPurposeFromQuery(w, r, staff)
+		if !ok {
+			return
+		}
+		audit.Criteria["purpose"] = purpose
+		ctx := services.WithConsentPurpose(r.Context(), staff.Hospital, purpose)
+
+		sources := []federatedSource{{
+			name:    LocalSource,
+			timeout: config.GetFederatedSearchTimeout(""),
+			search: func(ctx context.Context) ([]models.Patient, error) {
+				// Local matches are capped like the largest page of /patient/search
+				sqlQuery, queryArgs := builder.Limit(maxPageLimit).Select(patientColumns)
+				return queryPatients(ctx, db, sqlQuery, queryArgs...)
+			},
+		}}
+
+		for _, hospital := range hospitals.Hospitals() {
+			client, _ := hospitals.Client(hospital)
+			sources = append(sources, federatedSource{
+				name:    hospital,
+				timeout: config.GetFederatedSearchTimeout(hospital),
+				search:  hospitalSearch(client, query),
+			})
+		}
+
+		response := searchSources(ctx, sources)
+		for i, patient := range response.Patients {
+			audit.PatientIDs = append(audit.PatientIDs, patient.Source+":"+patientAuditID(patient.Patient))
+			response.Patients[i].Patient = disclosePatient(r, staff, patient.Patient)
+		}
+		utils.ResponseWithSuccess(w, http.StatusOK, response)
+	}
+}
+
+type federatedSource struct {
+	name    string
+	timeout time.Duration
+	search  func(ctx context.Context) ([]models.Patient, error)
+}
+
+func hospitalSearch(client services.HospitalClient, query models.PatientSearchRequest) func(ctx context.Context) ([]models.Patient, error) {
+	return func(ctx context.Context) ([]models.Patient, error) {
+		patients, err := client.SearchPatients(ctx, query)
+		if errors.Is(err, services.ErrPatientNotFound) {
+			return []models.Patient{}, nil
+		}
+		return patients, err
+	}
+}
+
+// searchSources runs every source under its own deadline and merges the results
+// in source order
+func searchSources(ctx context.Context, sources []federatedSource) models.FederatedSearchResponse {
+	results := make([]models.FederatedSourceResult, len(sources))
+	found := make([][]models.Patient, len(sources))
+
+	var wg sync.WaitGroup
+	for i, source := range sources {
+		wg.Add(1)
+		go func(i int, source federatedSource) {
+			defer wg.Done()
+
+			sourceCtx, cancel := context.WithTimeout(ctx, source.timeout)
+			defer cancel()
+
+			start := time.Now()
+			patients, err := source.search(sourceCtx)
+			result := models.FederatedSourceResult{
+				Source:     source.name,
+				Status:     models.SourceStatusOK,
+				Count:      len(patients),
+				DurationMS: time.Since(start).Milliseconds(),
+			}
+
+			var consentErr *services.ConsentRequiredError
+			switch {
+			case errors.As(err, &consentErr):
+				result.Status = models.SourceStatusConsentRequired
+				result.Error = consentErr.Reason
+			case err != nil && errors.Is(sourceCtx.Err(), context.DeadlineExceeded):
+				result.Status = models.SourceStatusTimeout
+				result.Error = "deadline of " + source.timeout.String() + " exceeded"
+			case err != nil:
+				// Upstream errors can name internal hosts, so only the log has the detail
+				log.Printf("Error searching %s in a federated search: %v", source.name, err)
+				result.Status = models.SourceStatusError
+				result.Error = "search failed"
+			}
+
+			results[i] = result
+			if err == nil {
+				found[i] = patients
+			}
+		}(i, source)
+	}
+	wg.Wait()
+
+	response := models.FederatedSearchResponse{
+		Patients: []models.FederatedPatient{},
+		Sources:  results,
+	}
+	for i, patients := range found {
+		for _, patient := range patients {
+			response.Patients = append(response.Patients, models.FederatedPatient{
+				Source:  sources[i].name,
+				Patient: patient,
+			})
+		}
+	}
+	return response
+}
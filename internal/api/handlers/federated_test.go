@@ -0,0 +1,90 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/roasted99/hospital-middleware/internal/api/handlers"
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type blockingHospitalClient struct{}
+
+func (c *blockingHospitalClient) SearchPatients(ctx context.Context, query models.PatientSearchRequest) ([]models.Patient, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestFederatedSearchPatient(t *testing.T) {
+	t.Setenv("FEDERATED_SEARCH_TIMEOUT", "2s")
+	t.Setenv("HOSPITAL_C_SEARCH_TIMEOUT", "50ms")
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT .+ FROM patient WHERE deleted_at IS NULL AND \\(national_id = \\$1\\)").
+		WithArgs("1234567890123").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "first_name_th", "middle_name_th", "last_name_th", "first_name_en", "middle_name_en", "last_name_en", "date_of_birth", "patient_hn", "national_id", "passport_id", "phone_number", "email", "gender", "hospital", "created_at", "updated_at"}).
+			AddRow(7, "ทดสอบ", nil, "สุดท้าย", "Test", nil, "Last", time.Now(), "HN-7", "1234567890123", nil, "0123456789", "test@email.com", "M", "Hospital Local", time.Now(), time.Now()))
+
+	registry := services.NewHospitalRegistry()
+	registry.Register("Hospital A", &stubHospitalClient{patients: []models.Patient{{NationalID: "1234567890123", Hospital: "Hospital A"}}})
+	registry.Register("Hospital B", &stubHospitalClient{err: errors.New("connection refused")})
+	registry.Register("Hospital C", &blockingHospitalClient{})
+
+	staff := &models.Staff{ID: 1, Username: "staff1", Hospital: "Hospital A", CrossHospital: true}
+	req := createAuthenticatedRequest("GET", "/patient/search/federated?national_id=1234567890123", staff)
+	rr := httptest.NewRecorder()
+
+	handlers.FederatedSearchPatient(db, registry)(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var response struct {
+		Data models.FederatedSearchResponse `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+
+	statuses := map[string]string{}
+	for _, source := range response.Data.Sources {
+		statuses[source.Source] = source.Status
+		if source.Source == "Hospital B" {
+			assert.Equal(t, "search failed", source.Error)
+		}
+	}
+	assert.Equal(t, map[string]string{
+		handlers.LocalSource: models.SourceStatusOK,
+		"Hospital A":         models.SourceStatusOK,
+		"Hospital B":         models.SourceStatusError,
+		"Hospital C":         models.SourceStatusTimeout,
+	}, statuses)
+
+	require.Len(t, response.Data.Patients, 2)
+	assert.Equal(t, handlers.LocalSource, response.Data.Patients[0].Source)
+	assert.Equal(t, 7, response.Data.Patients[0].ID)
+	assert.Equal(t, "Hospital A", response.Data.Patients[1].Source)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFederatedSearchPatientRequiresCrossHospitalPrivilege(t *testing.T) {
+	db, _, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	staff := &models.Staff{ID: 1, Username: "staff1", Hospital: "Hospital A"}
+	req := createAuthenticatedRequest("GET", "/patient/search/federated?national_id=1234567890123", staff)
+	rr := httptest.NewRecorder()
+
+	handlers.FederatedSearchPatient(db, services.NewHospitalRegistry())(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+}
@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/roasted99/hospital-middleware/internal/services"
+)
+
+// JWKS publishes the public keys that verify access tokens. It is served as a
+// bare JWK Set rather than in the usual response envelope so that standard JWT
+// libraries can consume it.
+func JWKS(keyset *services.Keyset) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		json.NewEncoder(w).Encode(keyset.JWKS(time.Now()))
+	}
+}
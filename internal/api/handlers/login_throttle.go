@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/roasted99/hospital-middleware/internal/utils"
+)
+
+// Reasons recorded in login_attempts
+const (
+	loginSucceeded          = "success"
+	loginInvalidCredentials = "invalid_credentials"
+	loginThrottled          = "throttled"
+	loginMFARequired        = "mfa_required"
+	loginInvalidMFACode     = "invalid_mfa_code"
+	loginSSO                = "sso"
+)
+
+// dummyPasswordHash is compared against when the account does not exist, so
+// unknown usernames take as long to reject as wrong passwords
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := services.HashPassword("dummy password for unknown accounts")
+	return hash
+})
+
+func accountThrottleKey(hospital, username string) string {
+	return "account:" + hospital + ":" + strings.ToLower(username)
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+func loadLoginThrottle(ctx context.Context, db *sql.DB, key string) (services.LoginThrottle, error) {
+	var throttle services.LoginThrottle
+	var lastFailedAt, lockedUntil sql.NullTime
+	err := db.QueryRowContext(ctx, "SELECT failed_count, last_failed_at, locked_until FROM login_throttles WHERE key = $1", key).
+		Scan(&throttle.FailedCount, &lastFailedAt, &lockedUntil)
+	if err == sql.ErrNoRows {
+		return throttle, nil
+	}
+	throttle.LastFailedAt = lastFailedAt.Time
+	throttle.LockedUntil = lockedUntil.Time
+	return throttle, err
+}
+
+// recordLoginFailure adds a failed attempt to the throttle of key. The row is
+// locked while the failure is counted, so concurrent failures all count.
+func recordLoginFailure(ctx context.Context, db *sql.DB, key string, policy services.LoginThrottlePolicy, now time.Time) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "INSERT INTO login_throttles (key) VALUES ($1) ON CONFLICT (key) DO NOTHING", key); err != nil {
+		return err
+	}
+	var throttle services.LoginThrottle
+	var lastFailedAt, lockedUntil sql.NullTime
+	err = tx.QueryRowContext(ctx, "SELECT failed_count, last_failed_at, locked_until FROM login_throttles WHERE key = $1 FOR UPDATE", key).
+		Scan(&throttle.FailedCount, &lastFailedAt, &lockedUntil)
+	if err != nil {
+		return err
+	}
+	throttle.LastFailedAt = lastFailedAt.Time
+	throttle.LockedUntil = lockedUntil.Time
+
+	throttle = policy.RecordFailure(throttle, now)
+	_, err = tx.ExecContext(ctx, "UPDATE login_throttles SET failed_count = $2, last_failed_at = $3, locked_until = $4 WHERE key = $1",
+		key, throttle.FailedCount, nullTime(throttle.LastFailedAt), nullTime(throttle.LockedUntil))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func recordLoginAttempt(ctx context.Context, db *sql.DB, username, hospital string, staffID int, ip string, reason string) error {
+	var staff sql.NullInt64
+	if staffID > 0 {
+		staff = sql.NullInt64{Int64: int64(staffID), Valid: true}
+	}
+	_, err := db.ExecContext(ctx, "INSERT INTO login_attempts (username, hospital, staff_id, ip_address, succeeded, reason) VALUES ($1, $2, $3, $4, $5, $6)",
+		username, hospital, staff, ip, reason == loginSucceeded || reason == loginSSO, reason)
+	return err
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// retryAfterSeconds rounds a wait up to whole seconds for the Retry-After header
+func retryAfterSeconds(wait time.Duration) string {
+	return strconv.Itoa(int((wait + time.Second - 1) / time.Second))
+}
+
+// UnlockStaff clears the failed login attempts and lockout of a staff member
+// of the caller's hospital
+func UnlockStaff(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		staff, ok := staffFromContext(r)
+		if !ok {
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		staffID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil || staffID <= 0 {
+			utils.ResponseWithError(w, http.StatusBadRequest, "Invalid staff ID")
+			return
+		}
+
+		var username string
+		err = db.QueryRowContext(r.Context(), "SELECT username FROM staff WHERE id = $1 AND hospital = $2", staffID, staff.Hospital).Scan(&username)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				utils.ResponseWithError(w, http.StatusNotFound, "Staff not found")
+			} else {
+				utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			}
+			return
+		}
+
+		if _, err := db.ExecContext(r.Context(), "DELETE FROM login_throttles WHERE key = $1", accountThrottleKey(staff.Hospital, username)); err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+
+		utils.ResponseWithJSON(w, http.StatusOK, "Account unlocked", nil)
+	}
+}
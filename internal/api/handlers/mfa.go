@@ -0,0 +1,407 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/roasted99/hospital-middleware/internal/config"
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/roasted99/hospital-middleware/internal/utils"
+)
+
+const (
+	// maxMFAAttempts is how many wrong codes a challenge accepts before the
+	// password has to be entered again
+	maxMFAAttempts    = 5
+	recoveryCodeCount = 10
+)
+
+var errMFAAlreadyEnabled = errors.New("MFA is already enabled")
+
+type mfaChallenge struct {
+	id        int
+	attempts  int
+	expiresAt time.Time
+	staff     models.Staff
+	secret    sql.NullString
+	lastStep  sql.NullInt64
+}
+
+func (c mfaChallenge) usable(now time.Time) bool {
+	return c.attempts < maxMFAAttempts && now.Before(c.expiresAt)
+}
+
+func createMFAChallenge(ctx context.Context, db *sql.DB, staffID int) (string, error) {
+	token, tokenHash, err := services.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	_, err = db.ExecContext(ctx, "INSERT INTO mfa_challenges (token_hash, staff_id, expires_at) VALUES ($1, $2, $3)",
+		tokenHash, staffID, time.Now().Add(config.GetMFAChallengeTTL()))
+	return token, err
+}
+
+func loadMFAChallenge(ctx context.Context, store sessionStore, token string) (mfaChallenge, error) {
+	var c mfaChallenge
+	err := store.QueryRowContext(ctx, "SELECT c.id, c.attempts, c.expires_at, s.id, s.username, s.hospital, s.cross_hospital, s.role, s.mfa_enabled, s.password_change_required, s.mfa_secret, s.mfa_last_step FROM mfa_challenges c JOIN staff s ON s.id = c.staff_id WHERE c.token_hash = $1 FOR UPDATE OF c",
+		services.HashOpaqueToken(token)).Scan(&c.id, &c.attempts, &c.expiresAt, &c.staff.ID, &c.staff.Username, &c.staff.Hospital, &c.staff.CrossHospital, &c.staff.Role, &c.staff.MFAEnabled, &c.staff.PasswordChangeRequired, &c.secret, &c.lastStep)
+	return c, err
+}
+
+// startMFAEnrollment gives staff a new pending TOTP secret; MFA is enabled once
+// a code from it is confirmed
+func startMFAEnrollment(ctx context.Context, store sessionStore, staff models.Staff) (models.MFAEnrollmentResponse, error) {
+	secret, err := services.GenerateTOTPSecret()
+	if err != nil {
+		return models.MFAEnrollmentResponse{}, err
+	}
+
+	result, err := store.ExecContext(ctx, "UPDATE staff SET mfa_secret = $1, updated_at = NOW() WHERE id = $2 AND mfa_enabled = FALSE", secret, staff.ID)
+	if err != nil {
+		return models.MFAEnrollmentResponse{}, err
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return models.MFAEnrollmentResponse{}, errMFAAlreadyEnabled
+	}
+
+	return models.MFAEnrollmentResponse{
+		Secret:          secret,
+		ProvisioningURI: services.TOTPProvisioningURI(config.GetMFAIssuer(), staff.Username+"@"+staff.Hospital, secret),
+	}, nil
+}
+
+// replaceRecoveryCodes invalidates the recovery codes of a staff member and
+// returns new ones; only their hashes are stored
+func replaceRecoveryCodes(ctx context.Context, store sessionStore, staffID int) ([]string, error) {
+	codes, err := services.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := store.ExecContext(ctx, "DELETE FROM staff_recovery_codes WHERE staff_id = $1", staffID); err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		if _, err := store.ExecContext(ctx, "INSERT INTO staff_recovery_codes (staff_id, code_hash) VALUES ($1, $2)",
+			staffID, services.HashOpaqueToken(services.NormalizeRecoveryCode(code))); err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// VerifyMFALogin completes a login with a TOTP or recovery code and issues the
+// tokens. The first TOTP code of a staff member who enrolled during login
+// enables MFA and returns their recovery codes. Wrong codes count against the
+// account's login throttle, which is only cleared once a code is accepted.
+func VerifyMFALogin(db *sql.DB) http.HandlerFunc {
+	accountPolicy := services.NewLoginThrottlePolicy(config.GetAccountLoginThrottleConfig())
+	trustProxy := config.GetTrustProxyHeaders()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request models.MFALoginRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			utils.ResponseWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		if request.MFAToken == "" || (request.Code == "" && request.RecoveryCode == "") {
+			utils.ResponseWithError(w, http.StatusBadRequest, "MFA token and a code or recovery code are required")
+			return
+		}
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		defer tx.Rollback()
+
+		now := time.Now()
+		challenge, err := loadMFAChallenge(r.Context(), tx, request.MFAToken)
+		if err != nil && err != sql.ErrNoRows {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if err == sql.ErrNoRows || !challenge.usable(now) {
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Invalid or expired MFA challenge")
+			return
+		}
+		staff := challenge.staff
+		accountKey := accountThrottleKey(staff.Hospital, staff.Username)
+
+		accountThrottle, err := loadLoginThrottle(r.Context(), db, accountKey)
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if wait := accountPolicy.RetryAfter(accountThrottle, now); wait > 0 {
+			w.Header().Set("Retry-After", retryAfterSeconds(wait))
+			utils.ResponseWithError(w, http.StatusTooManyRequests, "Too many login attempts, try again later")
+			return
+		}
+
+		var verified bool
+		var step int64
+		switch {
+		case request.Code != "":
+			if challenge.secret.Valid {
+				var ok bool
+				step, ok = services.VerifyTOTP(challenge.secret.String, request.Code, now)
+				// A code is accepted once, even within its validity window. The
+				// challenge lock does not cover the staff row, so the step is
+				// claimed with a conditional update that concurrent logins with
+				// the same code cannot both pass.
+				if ok && (!challenge.lastStep.Valid || step > challenge.lastStep.Int64) {
+					result, err := tx.ExecContext(r.Context(), "UPDATE staff SET mfa_enabled = TRUE, mfa_last_step = $1 WHERE id = $2 AND (mfa_last_step IS NULL OR mfa_last_step < $1)", step, staff.ID)
+					if err != nil {
+						utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+						return
+					}
+					claimed, _ := result.RowsAffected()
+					verified = claimed > 0
+				}
+			}
+		case staff.MFAEnabled:
+			result, err := tx.ExecContext(r.Context(), "UPDATE staff_recovery_codes SET used_at = NOW() WHERE staff_id = $1 AND code_hash = $2 AND used_at IS NULL",
+				staff.ID, services.HashOpaqueToken(services.NormalizeRecoveryCode(request.RecoveryCode)))
+			if err != nil {
+				utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+				return
+			}
+			used, _ := result.RowsAffected()
+			verified = used > 0
+		}
+
+		if !verified {
+			if _, err := tx.ExecContext(r.Context(), "UPDATE mfa_challenges SET attempts = attempts + 1 WHERE id = $1", challenge.id); err != nil {
+				utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+				return
+			}
+			if err := tx.Commit(); err != nil {
+				utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+				return
+			}
+			if err := recordLoginFailure(r.Context(), db, accountKey, accountPolicy, now); err != nil {
+				utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+				return
+			}
+			if err := recordLoginAttempt(r.Context(), db, staff.Username, staff.Hospital, staff.ID, utils.ClientIP(r, trustProxy), loginInvalidMFACode); err != nil {
+				utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+				return
+			}
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Invalid MFA code")
+			return
+		}
+
+		var recoveryCodes []string
+		if request.Code != "" && !staff.MFAEnabled {
+			if recoveryCodes, err = replaceRecoveryCodes(r.Context(), tx, staff.ID); err != nil {
+				utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+				return
+			}
+			staff.MFAEnabled = true
+		}
+
+		if _, err := tx.ExecContext(r.Context(), "DELETE FROM mfa_challenges WHERE id = $1", challenge.id); err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+
+		if _, err := db.ExecContext(r.Context(), "DELETE FROM login_throttles WHERE key = $1", accountKey); err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if err := recordLoginAttempt(r.Context(), db, staff.Username, staff.Hospital, staff.ID, utils.ClientIP(r, trustProxy), loginSucceeded); err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+
+		response, _, err := issueSession(r.Context(), db, staff, "")
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Failed to generate token")
+			return
+		}
+		response.RecoveryCodes = recoveryCodes
+
+		utils.ResponseWithSuccess(w, http.StatusOK, response)
+	}
+}
+
+// EnrollMFAForLogin starts enrollment for a staff member whose hospital
+// requires MFA but who has not enrolled yet, using their login challenge
+func EnrollMFAForLogin(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request models.MFAEnrollmentRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			utils.ResponseWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		challenge, err := loadMFAChallenge(r.Context(), db, request.MFAToken)
+		if err != nil && err != sql.ErrNoRows {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if err == sql.ErrNoRows || !challenge.usable(time.Now()) {
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Invalid or expired MFA challenge")
+			return
+		}
+
+		respondMFAEnrollment(w, r, db, challenge.staff)
+	}
+}
+
+// EnrollMFA starts MFA enrollment for the signed-in staff member
+func EnrollMFA(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		staff, ok := staffFromContext(r)
+		if !ok {
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		respondMFAEnrollment(w, r, db, *staff)
+	}
+}
+
+func respondMFAEnrollment(w http.ResponseWriter, r *http.Request, db *sql.DB, staff models.Staff) {
+	enrollment, err := startMFAEnrollment(r.Context(), db, staff)
+	if err != nil {
+		if err == errMFAAlreadyEnabled {
+			utils.ResponseWithError(w, http.StatusConflict, "MFA is already enabled")
+		} else {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+		}
+		return
+	}
+
+	utils.ResponseWithSuccess(w, http.StatusOK, enrollment)
+}
+
+// ConfirmMFA enables MFA for the signed-in staff member once they prove their
+// authenticator produces valid codes, and returns their recovery codes
+func ConfirmMFA(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		staff, ok := staffFromContext(r)
+		if !ok {
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		var request models.MFAConfirmRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			utils.ResponseWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		defer tx.Rollback()
+
+		var secret sql.NullString
+		var enabled bool
+		if err := tx.QueryRowContext(r.Context(), "SELECT mfa_secret, mfa_enabled FROM staff WHERE id = $1 FOR UPDATE", staff.ID).Scan(&secret, &enabled); err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if enabled {
+			utils.ResponseWithError(w, http.StatusConflict, "MFA is already enabled")
+			return
+		}
+		if !secret.Valid {
+			utils.ResponseWithError(w, http.StatusBadRequest, "Start MFA enrollment first")
+			return
+		}
+
+		step, ok := services.VerifyTOTP(secret.String, request.Code, time.Now())
+		if !ok {
+			utils.ResponseWithError(w, http.StatusBadRequest, "Invalid MFA code")
+			return
+		}
+
+		if _, err := tx.ExecContext(r.Context(), "UPDATE staff SET mfa_enabled = TRUE, mfa_last_step = $1, updated_at = NOW() WHERE id = $2", step, staff.ID); err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		codes, err := replaceRecoveryCodes(r.Context(), tx, staff.ID)
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+
+		utils.ResponseWithSuccess(w, http.StatusOK, models.MFARecoveryCodesResponse{RecoveryCodes: codes})
+	}
+}
+
+// ResetStaffMFA removes the authenticator and recovery codes of a staff member
+// of the caller's hospital, e.g. after a lost phone. If their hospital requires
+// MFA they enroll again at the next login.
+func ResetStaffMFA(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		staff, ok := staffFromContext(r)
+		if !ok {
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		staffID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil || staffID <= 0 {
+			utils.ResponseWithError(w, http.StatusBadRequest, "Invalid staff ID")
+			return
+		}
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		defer tx.Rollback()
+
+		result, err := tx.ExecContext(r.Context(), "UPDATE staff SET mfa_secret = NULL, mfa_enabled = FALSE, mfa_last_step = NULL, updated_at = NOW() WHERE id = $1 AND hospital = $2", staffID, staff.Hospital)
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if updated, _ := result.RowsAffected(); updated == 0 {
+			utils.ResponseWithError(w, http.StatusNotFound, "Staff not found")
+			return
+		}
+
+		if _, err := tx.ExecContext(r.Context(), "DELETE FROM staff_recovery_codes WHERE staff_id = $1", staffID); err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if _, err := tx.ExecContext(r.Context(), "DELETE FROM mfa_challenges WHERE staff_id = $1", staffID); err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+
+		utils.ResponseWithJSON(w, http.StatusOK, "MFA reset", nil)
+	}
+}
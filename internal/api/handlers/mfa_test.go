@@ -0,0 +1,235 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/roasted99/hospital-middleware/internal/api/handlers"
+	"github.com/roasted99/hospital-middleware/internal/api/middleware"
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var mfaChallengeColumns = []string{"id", "attempts", "expires_at", "id", "username", "hospital", "cross_hospital", "role", "mfa_enabled", "password_change_required", "mfa_secret", "mfa_last_step"}
+
+func TestLoginStaffRequiresMFA(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password123"), 10)
+
+	expectLoginThrottles(mock, sqlmock.NewRows(loginThrottleColumns))
+	mock.ExpectQuery("SELECT id, username, password, hospital, cross_hospital, role, mfa_enabled, password_change_required FROM staff").
+		WithArgs("testuser", "Test Hospital").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "password", "hospital", "cross_hospital", "role", "mfa_enabled", "password_change_required"}).AddRow(1, "testuser", string(hashedPassword), "Test Hospital", false, "doctor", true, false))
+	// The account's failed attempts are kept until the second step succeeds
+	mock.ExpectExec("INSERT INTO mfa_challenges").
+		WithArgs(sqlmock.AnyArg(), 1, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO login_attempts").
+		WithArgs("testuser", "Test Hospital", sqlmock.AnyArg(), "192.0.2.1", false, "mfa_required").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	body := `{"username":"testuser","password":"password123","hospital":"Test Hospital"}`
+	rr := httptest.NewRecorder()
+	handlers.LoginStaff(db)(rr, httptest.NewRequest(http.MethodPost, "/staff/login", bytes.NewBufferString(body)))
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	var response struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, true, response.Data["mfa_required"])
+	assert.Equal(t, false, response.Data["enrollment_required"])
+	assert.NotEmpty(t, response.Data["mfa_token"])
+	assert.NotContains(t, response.Data, "token")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestVerifyMFALogin(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	secret, err := services.GenerateTOTPSecret()
+	require.NoError(t, err)
+	now := time.Now()
+	code, err := services.TOTPCode(secret, now)
+	require.NoError(t, err)
+	step := now.Unix() / 30
+	challengeHash := services.HashOpaqueToken("mfa-token")
+
+	challengeRow := func(lastStep interface{}) *sqlmock.Rows {
+		return sqlmock.NewRows(mfaChallengeColumns).
+			AddRow(4, 0, now.Add(time.Minute), 1, "testuser", "Test Hospital", false, "doctor", true, false, secret, lastStep)
+	}
+	expectAccountThrottle := func(rows *sqlmock.Rows) {
+		mock.ExpectQuery("SELECT failed_count, last_failed_at, locked_until FROM login_throttles WHERE key = \\$1").
+			WithArgs("account:Test Hospital:testuser").
+			WillReturnRows(rows)
+	}
+
+	tests := []struct {
+		name           string
+		body           string
+		mockSetup      func()
+		expectedStatus int
+	}{
+		{
+			name: "Valid code issues tokens",
+			body: `{"mfa_token":"mfa-token","code":"` + code + `"}`,
+			mockSetup: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT c.id, c.attempts, c.expires_at, .+ FROM mfa_challenges c JOIN staff s ON s.id = c.staff_id WHERE c.token_hash = \\$1 FOR UPDATE OF c").
+					WithArgs(challengeHash).
+					WillReturnRows(challengeRow(step - 5))
+				expectAccountThrottle(sqlmock.NewRows(loginThrottleColumns).AddRow(2, now.Add(-time.Hour), nil))
+				mock.ExpectExec("UPDATE staff SET mfa_enabled = TRUE, mfa_last_step = \\$1 WHERE id = \\$2 AND \\(mfa_last_step IS NULL OR mfa_last_step < \\$1\\)").
+					WithArgs(sqlmock.AnyArg(), 1).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("DELETE FROM mfa_challenges WHERE id = \\$1").WithArgs(4).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+				mock.ExpectExec("DELETE FROM login_throttles WHERE key = \\$1").
+					WithArgs("account:Test Hospital:testuser").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("INSERT INTO login_attempts").
+					WithArgs("testuser", "Test Hospital", sqlmock.AnyArg(), sqlmock.AnyArg(), true, "success").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectQuery("INSERT INTO refresh_tokens").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "Replayed code is rejected and counted",
+			body: `{"mfa_token":"mfa-token","code":"` + code + `"}`,
+			mockSetup: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT c.id, c.attempts").
+					WithArgs(challengeHash).
+					WillReturnRows(challengeRow(step + 1))
+				expectAccountThrottle(sqlmock.NewRows(loginThrottleColumns))
+				mock.ExpectExec("UPDATE mfa_challenges SET attempts = attempts \\+ 1 WHERE id = \\$1").WithArgs(4).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+				expectLoginFailure(mock, "account:Test Hospital:testuser")
+				mock.ExpectExec("INSERT INTO login_attempts").
+					WithArgs("testuser", "Test Hospital", sqlmock.AnyArg(), sqlmock.AnyArg(), false, "invalid_mfa_code").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "Code claimed by a concurrent login is rejected",
+			body: `{"mfa_token":"mfa-token","code":"` + code + `"}`,
+			mockSetup: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT c.id, c.attempts").
+					WithArgs(challengeHash).
+					WillReturnRows(challengeRow(step - 5))
+				expectAccountThrottle(sqlmock.NewRows(loginThrottleColumns))
+				mock.ExpectExec("UPDATE staff SET mfa_enabled = TRUE, mfa_last_step = \\$1 WHERE id = \\$2 AND \\(mfa_last_step IS NULL OR mfa_last_step < \\$1\\)").
+					WithArgs(sqlmock.AnyArg(), 1).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec("UPDATE mfa_challenges SET attempts = attempts \\+ 1 WHERE id = \\$1").WithArgs(4).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+				expectLoginFailure(mock, "account:Test Hospital:testuser")
+				mock.ExpectExec("INSERT INTO login_attempts").
+					WithArgs("testuser", "Test Hospital", sqlmock.AnyArg(), sqlmock.AnyArg(), false, "invalid_mfa_code").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "Recovery code is used up",
+			body: `{"mfa_token":"mfa-token","recovery_code":"ABCDE-FGHIJ"}`,
+			mockSetup: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT c.id, c.attempts").
+					WithArgs(challengeHash).
+					WillReturnRows(challengeRow(nil))
+				expectAccountThrottle(sqlmock.NewRows(loginThrottleColumns))
+				mock.ExpectExec("UPDATE staff_recovery_codes SET used_at = NOW\\(\\) WHERE staff_id = \\$1 AND code_hash = \\$2 AND used_at IS NULL").
+					WithArgs(1, services.HashOpaqueToken("abcdefghij")).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("DELETE FROM mfa_challenges WHERE id = \\$1").WithArgs(4).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+				mock.ExpectExec("DELETE FROM login_throttles WHERE key = \\$1").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("INSERT INTO login_attempts").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectQuery("INSERT INTO refresh_tokens").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "Locked account is refused before the code is checked",
+			body: `{"mfa_token":"mfa-token","code":"` + code + `"}`,
+			mockSetup: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT c.id, c.attempts").
+					WithArgs(challengeHash).
+					WillReturnRows(challengeRow(nil))
+				expectAccountThrottle(sqlmock.NewRows(loginThrottleColumns).AddRow(5, now, now.Add(time.Minute)))
+				mock.ExpectRollback()
+			},
+			expectedStatus: http.StatusTooManyRequests,
+		},
+		{
+			name: "Exhausted challenge is rejected",
+			body: `{"mfa_token":"mfa-token","code":"` + code + `"}`,
+			mockSetup: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT c.id, c.attempts").
+					WithArgs(challengeHash).
+					WillReturnRows(sqlmock.NewRows(mfaChallengeColumns).
+						AddRow(4, 5, now.Add(time.Minute), 1, "testuser", "Test Hospital", false, "doctor", true, false, secret, nil))
+				mock.ExpectRollback()
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mockSetup()
+
+			rr := httptest.NewRecorder()
+			handlers.VerifyMFALogin(db)(rr, httptest.NewRequest(http.MethodPost, "/staff/login/mfa", bytes.NewBufferString(tt.body)))
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestResetStaffMFA(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	admin := &models.Staff{ID: 1, Hospital: "Test Hospital", Role: "admin"}
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE staff SET mfa_secret = NULL, mfa_enabled = FALSE, mfa_last_step = NULL, updated_at = NOW\\(\\) WHERE id = \\$1 AND hospital = \\$2").
+		WithArgs(7, "Test Hospital").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM staff_recovery_codes WHERE staff_id = \\$1").WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 10))
+	mock.ExpectExec("DELETE FROM mfa_challenges WHERE staff_id = \\$1").WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	req := httptest.NewRequest(http.MethodDelete, "/admin/staff/7/mfa", nil)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.StaffKey, admin))
+	req = mux.SetURLVars(req, map[string]string{"id": "7"})
+	rr := httptest.NewRecorder()
+	handlers.ResetStaffMFA(db)(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
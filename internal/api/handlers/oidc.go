@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/roasted99/hospital-middleware/internal/config"
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/roasted99/hospital-middleware/internal/utils"
+)
+
+// oidcStateCookie binds a pending SSO login to the browser that started it
+const oidcStateCookie = "oidc_state"
+
+// ssoPassword is stored for staff provisioned through SSO. It is not a bcrypt
+// hash, so these accounts cannot sign in with a password.
+const ssoPassword = "!sso"
+
+var errSSOUsernameTaken = errors.New("username is already taken")
+
+// StartOIDCLogin sends the user to the identity provider of the hospital in
+// the query string, e.g. /staff/login/oidc?hospital=Hospital%20A
+func StartOIDCLogin(db *sql.DB, providers map[string]*services.OIDCProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider, ok := services.LookupOIDCProvider(providers, r.URL.Query().Get("hospital"))
+		if !ok {
+			utils.ResponseWithError(w, http.StatusNotFound, "Single sign-on is not configured for this hospital")
+			return
+		}
+
+		state, stateHash, err := services.GenerateOpaqueToken()
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Failed to start login")
+			return
+		}
+		nonce, _, err := services.GenerateOpaqueToken()
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Failed to start login")
+			return
+		}
+		verifier, err := services.GeneratePKCEVerifier()
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Failed to start login")
+			return
+		}
+
+		authURL, err := provider.AuthorizationURL(r.Context(), state, nonce, services.PKCEChallenge(verifier))
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusBadGateway, "Identity provider unavailable")
+			return
+		}
+
+		ttl := config.GetOIDCLoginTTL()
+		_, err = db.ExecContext(r.Context(), "INSERT INTO oidc_login_states (state_hash, hospital, nonce, code_verifier, expires_at) VALUES ($1, $2, $3, $4, $5)",
+			stateHash, provider.Config.Hospital, nonce, verifier, time.Now().Add(ttl))
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     oidcStateCookie,
+			Value:    state,
+			Path:     "/staff/login/oidc",
+			MaxAge:   int(ttl.Seconds()),
+			HttpOnly: true,
+			Secure:   strings.HasPrefix(provider.Config.RedirectURL, "https://"),
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, authURL, http.StatusFound)
+	}
+}
+
+// OIDCCallback completes an SSO login: it redeems the authorization code,
+// provisions the staff member on first sign-in and issues our own tokens
+func OIDCCallback(db *sql.DB, providers map[string]*services.OIDCProvider) http.HandlerFunc {
+	trustProxy := config.GetTrustProxyHeaders()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("error") != "" {
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Single sign-on failed")
+			return
+		}
+
+		state := query.Get("state")
+		cookie, err := r.Cookie(oidcStateCookie)
+		if state == "" || err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+			utils.ResponseWithError(w, http.StatusBadRequest, "Invalid login state")
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/staff/login/oidc", MaxAge: -1, HttpOnly: true})
+
+		// Each state can be used once
+		var hospital, nonce, verifier string
+		var expiresAt time.Time
+		err = db.QueryRowContext(r.Context(), "DELETE FROM oidc_login_states WHERE state_hash = $1 RETURNING hospital, nonce, code_verifier, expires_at",
+			services.HashOpaqueToken(state)).Scan(&hospital, &nonce, &verifier, &expiresAt)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				utils.ResponseWithError(w, http.StatusBadRequest, "Invalid login state")
+			} else {
+				utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			}
+			return
+		}
+		if !time.Now().Before(expiresAt) {
+			utils.ResponseWithError(w, http.StatusBadRequest, "Login expired, try again")
+			return
+		}
+
+		provider, ok := services.LookupOIDCProvider(providers, hospital)
+		if !ok {
+			utils.ResponseWithError(w, http.StatusNotFound, "Single sign-on is not configured for this hospital")
+			return
+		}
+
+		identity, err := provider.Exchange(r.Context(), query.Get("code"), verifier, nonce)
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Single sign-on failed")
+			return
+		}
+
+		staff, err := provisionSSOStaff(r.Context(), db, provider, identity)
+		if err != nil {
+			if errors.Is(err, errSSOUsernameTaken) {
+				utils.ResponseWithError(w, http.StatusConflict, "Username is already taken")
+			} else {
+				utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			}
+			return
+		}
+
+		// The IdP's own MFA is not visible here, so hospitals that require MFA
+		// get the same TOTP challenge as a password login
+		if staff.MFAEnabled || config.HospitalRequiresMFA(staff.Hospital) {
+			mfaToken, err := createMFAChallenge(r.Context(), db, staff.ID)
+			if err != nil {
+				utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+				return
+			}
+			if err := recordLoginAttempt(r.Context(), db, staff.Username, staff.Hospital, staff.ID, utils.ClientIP(r, trustProxy), loginMFARequired); err != nil {
+				utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+				return
+			}
+			utils.ResponseWithSuccess(w, http.StatusOK, models.MFAChallengeResponse{
+				MFARequired:        true,
+				EnrollmentRequired: !staff.MFAEnabled,
+				MFAToken:           mfaToken,
+				ExpiresIn:          int(config.GetMFAChallengeTTL().Seconds()),
+			})
+			return
+		}
+
+		if err := recordLoginAttempt(r.Context(), db, staff.Username, staff.Hospital, staff.ID, utils.ClientIP(r, trustProxy), loginSSO); err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+
+		response, _, err := issueSession(r.Context(), db, staff, "")
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Failed to generate token")
+			return
+		}
+
+		utils.ResponseWithSuccess(w, http.StatusOK, response)
+	}
+}
+
+// provisionSSOStaff returns the staff member linked to identity, creating it on
+// first sign-in. When the IdP sends roles they replace the stored role, so role
+// changes at the IdP apply from the next sign-in.
+func provisionSSOStaff(ctx context.Context, db *sql.DB, provider *services.OIDCProvider, identity services.OIDCIdentity) (models.Staff, error) {
+	role := provider.Role(identity)
+
+	var staff models.Staff
+	err := db.QueryRowContext(ctx, "SELECT id, username, hospital, cross_hospital, role, mfa_enabled, password_change_required FROM staff WHERE oidc_issuer = $1 AND oidc_subject = $2 AND hospital = $3",
+		identity.Issuer, identity.Subject, provider.Config.Hospital).
+		Scan(&staff.ID, &staff.Username, &staff.Hospital, &staff.CrossHospital, &staff.Role, &staff.MFAEnabled, &staff.PasswordChangeRequired)
+	if err == sql.ErrNoRows {
+		staff = models.Staff{Username: identity.Username, Hospital: provider.Config.Hospital, Role: role}
+		err = db.QueryRowContext(ctx, "INSERT INTO staff (username, password, hospital, role, oidc_issuer, oidc_subject, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, NOW(), NOW()) RETURNING id",
+			staff.Username, ssoPassword, staff.Hospital, staff.Role, identity.Issuer, identity.Subject).Scan(&staff.ID)
+		// Existing password accounts are never linked by username alone
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return models.Staff{}, errSSOUsernameTaken
+		}
+		return staff, err
+	}
+	if err != nil {
+		return models.Staff{}, err
+	}
+
+	if provider.Config.RoleClaim != "" && role != staff.Role {
+		if _, err := db.ExecContext(ctx, "UPDATE staff SET role = $1, updated_at = NOW() WHERE id = $2", role, staff.ID); err != nil {
+			return models.Staff{}, err
+		}
+		staff.Role = role
+	}
+	return staff, nil
+}
@@ -0,0 +1,192 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/roasted99/hospital-middleware/internal/api/handlers"
+	"github.com/roasted99/hospital-middleware/internal/config"
+	"github.com/roasted99/hospital-middleware/internal/oidctest"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const oidcRedirectURL = "https://middleware.example/staff/login/oidc/callback"
+
+func newOIDCProviders(t *testing.T, idp *oidctest.Server) map[string]*services.OIDCProvider {
+	providers, err := services.NewOIDCProviders([]config.OIDCConfig{{
+		Hospital:    "Hospital A",
+		Issuer:      idp.Issuer(),
+		ClientID:    idp.ClientID,
+		RedirectURL: oidcRedirectURL,
+		Scopes:      []string{"openid", "profile"},
+		RoleClaim:   "roles",
+		RoleMap:     map[string]string{"ward-nurse": services.RoleNurse},
+	}})
+	require.NoError(t, err)
+	return providers
+}
+
+func TestStartOIDCLogin(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	idp := oidctest.NewServer("middleware", "")
+	defer idp.Close()
+	providers := newOIDCProviders(t, idp)
+
+	mock.ExpectExec("INSERT INTO oidc_login_states \\(state_hash, hospital, nonce, code_verifier, expires_at\\)").
+		WithArgs(sqlmock.AnyArg(), "Hospital A", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	req := httptest.NewRequest(http.MethodGet, "/staff/login/oidc?hospital=hospital+a", nil)
+	rr := httptest.NewRecorder()
+	handlers.StartOIDCLogin(db, providers)(rr, req)
+
+	require.Equal(t, http.StatusFound, rr.Code)
+	location, err := url.Parse(rr.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, idp.Issuer()+"/authorize", location.Scheme+"://"+location.Host+location.Path)
+	assert.Equal(t, oidcRedirectURL, location.Query().Get("redirect_uri"))
+	assert.Equal(t, "S256", location.Query().Get("code_challenge_method"))
+	assert.NotEmpty(t, location.Query().Get("nonce"))
+
+	cookies := rr.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, location.Query().Get("state"), cookies[0].Value)
+	assert.True(t, cookies[0].HttpOnly)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	rr = httptest.NewRecorder()
+	handlers.StartOIDCLogin(db, providers)(rr, httptest.NewRequest(http.MethodGet, "/staff/login/oidc?hospital=Hospital+B", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestOIDCCallback(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	idp := oidctest.NewServer("middleware", "")
+	defer idp.Close()
+	providers := newOIDCProviders(t, idp)
+	staffColumns := []string{"id", "username", "hospital", "cross_hospital", "role", "mfa_enabled", "password_change_required"}
+
+	expectState := func() {
+		mock.ExpectQuery("DELETE FROM oidc_login_states WHERE state_hash = \\$1 RETURNING hospital, nonce, code_verifier, expires_at").
+			WithArgs(services.HashOpaqueToken("state-1")).
+			WillReturnRows(sqlmock.NewRows([]string{"hospital", "nonce", "code_verifier", "expires_at"}).
+				AddRow("Hospital A", "nonce-1", "verifier-1", time.Now().Add(time.Minute)))
+	}
+
+	tests := []struct {
+		name           string
+		cookie         string
+		claims         map[string]interface{}
+		mfaRequired    bool
+		mockSetup      func()
+		expectedStatus int
+	}{
+		{
+			name:   "Provisions the staff member on first sign-in",
+			cookie: "state-1",
+			claims: map[string]interface{}{"sub": "idp-7", "preferred_username": "nurse.malee", "roles": []string{"ward-nurse"}},
+			mockSetup: func() {
+				expectState()
+				mock.ExpectQuery("SELECT id, username, hospital, cross_hospital, role, mfa_enabled, password_change_required FROM staff WHERE oidc_issuer = \\$1 AND oidc_subject = \\$2 AND hospital = \\$3").
+					WithArgs(idp.Issuer(), "idp-7", "Hospital A").
+					WillReturnRows(sqlmock.NewRows(staffColumns))
+				mock.ExpectQuery("INSERT INTO staff").
+					WithArgs("nurse.malee", "!sso", "Hospital A", services.RoleNurse, idp.Issuer(), "idp-7").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(12))
+				mock.ExpectExec("INSERT INTO login_attempts").
+					WithArgs("nurse.malee", "Hospital A", sqlmock.AnyArg(), "192.0.2.1", true, "sso").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectQuery("INSERT INTO refresh_tokens").
+					WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 12, sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:   "Applies role changes from the IdP to a returning staff member",
+			cookie: "state-1",
+			claims: map[string]interface{}{"sub": "idp-7", "preferred_username": "nurse.malee"},
+			mockSetup: func() {
+				expectState()
+				mock.ExpectQuery("SELECT id, username, hospital, cross_hospital, role, mfa_enabled, password_change_required FROM staff WHERE oidc_issuer").
+					WithArgs(idp.Issuer(), "idp-7", "Hospital A").
+					WillReturnRows(sqlmock.NewRows(staffColumns).AddRow(12, "nurse.malee", "Hospital A", false, services.RoleNurse, false, false))
+				mock.ExpectExec("UPDATE staff SET role = \\$1, updated_at = NOW\\(\\) WHERE id = \\$2").
+					WithArgs(services.DefaultRole, 12).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("INSERT INTO login_attempts").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectQuery("INSERT INTO refresh_tokens").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:        "Challenges staff of a hospital that requires MFA",
+			cookie:      "state-1",
+			claims:      map[string]interface{}{"sub": "idp-7", "preferred_username": "nurse.malee", "roles": []string{"ward-nurse"}},
+			mfaRequired: true,
+			mockSetup: func() {
+				expectState()
+				mock.ExpectQuery("SELECT id, username, hospital, cross_hospital, role, mfa_enabled, password_change_required FROM staff WHERE oidc_issuer").
+					WillReturnRows(sqlmock.NewRows(staffColumns).AddRow(12, "nurse.malee", "Hospital A", false, services.RoleNurse, false, false))
+				mock.ExpectExec("INSERT INTO mfa_challenges").
+					WithArgs(sqlmock.AnyArg(), 12, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("INSERT INTO login_attempts").
+					WithArgs("nurse.malee", "Hospital A", 12, "192.0.2.1", false, "mfa_required").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:   "Does not take over a password account with the same username",
+			cookie: "state-1",
+			claims: map[string]interface{}{"sub": "idp-8", "preferred_username": "admin"},
+			mockSetup: func() {
+				expectState()
+				mock.ExpectQuery("SELECT id, username, hospital, cross_hospital, role, mfa_enabled, password_change_required FROM staff WHERE oidc_issuer").
+					WillReturnRows(sqlmock.NewRows(staffColumns))
+				mock.ExpectQuery("INSERT INTO staff").WillReturnError(&pq.Error{Code: "23505"})
+			},
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "Rejects a state that was not issued to this browser",
+			cookie:         "state-2",
+			claims:         map[string]interface{}{"sub": "idp-7", "preferred_username": "nurse.malee"},
+			mockSetup:      func() {},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.mfaRequired {
+				t.Setenv("HOSPITAL_A_MFA_REQUIRED", "true")
+			}
+			tt.mockSetup()
+			idp.SetUser(tt.claims)
+			code := idp.IssueCode(oidcRedirectURL, services.PKCEChallenge("verifier-1"), "nonce-1")
+
+			req := httptest.NewRequest(http.MethodGet, "/staff/login/oidc/callback?state=state-1&code="+code, nil)
+			req.AddCookie(&http.Cookie{Name: "oidc_state", Value: tt.cookie})
+			rr := httptest.NewRecorder()
+			handlers.OIDCCallback(db, providers)(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
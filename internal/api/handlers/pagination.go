@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/roasted99/hospital-middleware/internal/db/patientquery"
+	"github.com/roasted99/hospital-middleware/internal/models"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// patientSort is a keyset-friendly ordering of the patient table. Keys are
+// compared as a row value together with id, which breaks ties.
+type patientSort struct {
+	Name string
+	Desc bool
+	// Keys are SQL expressions; Casts convert the cursor's text values to the key types
+	Keys   []string
+	Casts  []string
+	values func(p models.Patient) []string
+	// compare orders patients by the keys in Go, for results not read from the table
+	compare func(a, b models.Patient) int
+}
+
+var patientSorts = map[string]patientSort{
+	"name": {
+		Keys:  []string{"COALESCE(last_name_en, '')", "COALESCE(first_name_en, '')"},
+		Casts: []string{"text", "text"},
+		values: func(p models.Patient) []string {
+			return []string{p.LastNameEN, p.FirstNameEN}
+		},
+		compare: func(a, b models.Patient) int {
+			if c := strings.Compare(a.LastNameEN, b.LastNameEN); c != 0 {
+				return c
+			}
+			return strings.Compare(a.FirstNameEN, b.FirstNameEN)
+		},
+	},
+	"date_of_birth": {
+		Keys:  []string{"COALESCE(date_of_birth, DATE '0001-01-01')"},
+		Casts: []string{"date"},
+		values: func(p models.Patient) []string {
+			return []string{p.DateOfBirth.Format("2006-01-02")}
+		},
+		compare: func(a, b models.Patient) int {
+			return a.DateOfBirth.Compare(b.DateOfBirth)
+		},
+	},
+	"created_at": {
+		Keys:  []string{"created_at"},
+		Casts: []string{"timestamptz"},
+		values: func(p models.Patient) []string {
+			return []string{p.CreatedAt.Format(time.RFC3339Nano)}
+		},
+		compare: func(a, b models.Patient) int {
+			return a.CreatedAt.Compare(b.CreatedAt)
+		},
+	},
+}
+
+// patientCursor marks the last row of a page. Pages of a hospital system's
+// results have no row IDs, so their cursor holds the source and an offset instead.
+type patientCursor struct {
+	Sort   string   `json:"s"`
+	Values []string `json:"v,omitempty"`
+	ID     int      `json:"id,omitempty"`
+	Source string   `json:"src,omitempty"`
+	Offset int      `json:"o,omitempty"`
+}
+
+type patientPage struct {
+	Limit        int
+	Sort         patientSort
+	Cursor       *patientCursor
+	IncludeTotal bool
+}
+
+// patientPageFromQuery reads limit, cursor, sort and include_total
+func patientPageFromQuery(r *http.Request) (patientPage, error) {
+	page := patientPage{Limit: defaultPageLimit}
+
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil || value < 1 || value > maxPageLimit {
+			return page, errors.New("limit must be between 1 and " + strconv.Itoa(maxPageLimit))
+		}
+		page.Limit = value
+	}
+
+	sortName := r.URL.Query().Get("sort")
+	if sortName == "" {
+		sortName = "name"
+	}
+	desc := strings.HasPrefix(sortName, "-")
+	sort, ok := patientSorts[strings.TrimPrefix(sortName, "-")]
+	if !ok {
+		return page, errors.New("sort must be one of name, date_of_birth or created_at, optionally prefixed with -")
+	}
+	sort.Name = sortName
+	sort.Desc = desc
+	page.Sort = sort
+
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		decoded, err := decodePatientCursor(cursor)
+		if err != nil || decoded.Sort != sortName || (decoded.Source == "" && len(decoded.Values) != len(sort.Keys)) {
+			return page, errors.New("cursor is invalid or was issued for a different sort")
+		}
+		page.Cursor = decoded
+	}
+
+	page.IncludeTotal, _ = strconv.ParseBool(r.URL.Query().Get("include_total"))
+	return page, nil
+}
+
+// apply adds the cursor, ordering and limit to a search. One extra row is
+// fetched to detect whether another page follows.
+func (p patientPage) apply(builder *patientquery.Builder) *patientquery.Builder {
+	keys := append(append([]string{}, p.Sort.Keys...), "id")
+
+	direction, operator := "ASC", ">"
+	if p.Sort.Desc {
+		direction, operator = "DESC", "<"
+	}
+
+	if p.Cursor != nil {
+		var placeholders []string
+		var args []interface{}
+		for i, value := range p.Cursor.Values {
+			placeholders = append(placeholders, "?::"+p.Sort.Casts[i])
+			args = append(args, value)
+		}
+		placeholders = append(placeholders, "?")
+		args = append(args, p.Cursor.ID)
+
+		builder.After(patientquery.Cond("("+strings.Join(keys, ", ")+") "+operator+" ("+strings.Join(placeholders, ", ")+")", args...))
+	}
+
+	var order []string
+	for _, key := range keys {
+		order = append(order, key+" "+direction)
+	}
+	return builder.OrderBy(strings.Join(order, ", ")).Limit(p.Limit + 1)
+}
+
+// trim drops the extra row fetched by apply and builds the page metadata
+func (p patientPage) trim(patients []models.Patient) ([]models.Patient, models.PageMeta) {
+	meta := models.PageMeta{Limit: p.Limit, Sort: p.Sort.Name}
+	if len(patients) > p.Limit {
+		patients = patients[:p.Limit]
+		meta.HasMore = true
+		meta.NextCursor = encodePatientCursor(patientCursor{
+			Sort:   p.Sort.Name,
+			Values: p.Sort.values(patients[len(patients)-1]),
+			ID:     patients[len(patients)-1].ID,
+		})
+	}
+	return patients, meta
+}
+
+// slice sorts the results of a hospital system like apply orders local rows,
+// with ties broken by HN, and returns the page that follows the cursor. The
+// patients are copied, so cached hospital results keep their order.
+func (p patientPage) slice(source string, patients []models.Patient) ([]models.Patient, models.PageMeta) {
+	sorted := append([]models.Patient{}, patients...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		c := p.Sort.compare(sorted[i], sorted[j])
+		if c == 0 {
+			c = strings.Compare(sorted[i].PatientHN, sorted[j].PatientHN)
+		}
+		if p.Sort.Desc {
+			return c > 0
+		}
+		return c < 0
+	})
+
+	offset := 0
+	if p.Cursor != nil {
+		offset = min(p.Cursor.Offset, len(sorted))
+	}
+	sorted = sorted[offset:]
+
+	meta := models.PageMeta{Limit: p.Limit, Sort: p.Sort.Name}
+	if len(sorted) > p.Limit {
+		sorted = sorted[:p.Limit]
+		meta.HasMore = true
+		meta.NextCursor = encodePatientCursor(patientCursor{
+			Sort:   p.Sort.Name,
+			Source: source,
+			Offset: offset + p.Limit,
+		})
+	}
+	return sorted, meta
+}
+
+func encodePatientCursor(cursor patientCursor) string {
+	payload, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(payload)
+}
+
+func decodePatientCursor(value string) (*patientCursor, error) {
+	payload, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	var cursor patientCursor
+	if err := json.Unmarshal(payload, &cursor); err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
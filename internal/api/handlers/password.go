@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/roasted99/hospital-middleware/internal/config"
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/roasted99/hospital-middleware/internal/utils"
+)
+
+// passwordReused reports whether password matches the current hash or one of
+// the most recent previous passwords. historySize counts the current password.
+func passwordReused(ctx context.Context, tx *sql.Tx, staffID int, currentHash, password string, historySize int) (bool, error) {
+	if historySize <= 0 {
+		return false, nil
+	}
+	if services.CheckPasswordHash(password, currentHash) {
+		return true, nil
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT password_hash FROM password_history WHERE staff_id = $1 ORDER BY created_at DESC, id DESC LIMIT $2", staffID, historySize-1)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return false, err
+		}
+		if services.CheckPasswordHash(password, hash) {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// replacePassword stores newHash for the staff member, moves the old hash into
+// the history and signs out every refresh token. Access tokens are revoked by
+// the caller once the transaction has committed.
+func replacePassword(ctx context.Context, tx *sql.Tx, staffID int, oldHash, newHash string, changeRequired bool, historySize int) error {
+	if _, err := tx.ExecContext(ctx, "UPDATE staff SET password = $1, password_change_required = $2, password_changed_at = NOW(), updated_at = NOW() WHERE id = $3",
+		newHash, changeRequired, staffID); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "INSERT INTO password_history (staff_id, password_hash) VALUES ($1, $2)", staffID, oldHash); err != nil {
+		return err
+	}
+
+	// Only the hashes the reuse check can reach are kept
+	if _, err := tx.ExecContext(ctx, "DELETE FROM password_history WHERE staff_id = $1 AND id NOT IN (SELECT id FROM password_history WHERE staff_id = $1 ORDER BY created_at DESC, id DESC LIMIT $2)",
+		staffID, max(historySize-1, 0)); err != nil {
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = NOW() WHERE staff_id = $1 AND revoked_at IS NULL", staffID)
+	return err
+}
+
+// ChangePassword replaces the caller's password after checking the current one.
+// Every session of the caller is signed out, so the client signs in again.
+func ChangePassword(db *sql.DB, revocations services.TokenRevocationStore) http.HandlerFunc {
+	policy := services.NewPasswordPolicy(config.GetPasswordPolicyConfig())
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		staff, ok := staffFromContext(r)
+		if !ok {
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		var request models.PasswordChangeRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			utils.ResponseWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		if request.CurrentPassword == "" || request.NewPassword == "" {
+			utils.ResponseWithError(w, http.StatusBadRequest, "Current and new password are required")
+			return
+		}
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		defer tx.Rollback()
+
+		var username, currentHash string
+		err = tx.QueryRowContext(r.Context(), "SELECT username, password FROM staff WHERE id = $1 FOR UPDATE", staff.ID).Scan(&username, &currentHash)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				utils.ResponseWithError(w, http.StatusUnauthorized, "Unauthorized")
+			} else {
+				utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			}
+			return
+		}
+
+		if !services.CheckPasswordHash(request.CurrentPassword, currentHash) {
+			utils.ResponseWithError(w, http.StatusBadRequest, "Current password is incorrect")
+			return
+		}
+
+		if err := policy.Validate(request.NewPassword, username); err != nil {
+			utils.ResponseWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		reused, err := passwordReused(r.Context(), tx, staff.ID, currentHash, request.NewPassword, policy.HistorySize)
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if reused {
+			utils.ResponseWithError(w, http.StatusBadRequest, "password must not match one of the last "+strconv.Itoa(policy.HistorySize)+" passwords")
+			return
+		}
+
+		hashedPassword, err := services.HashPassword(request.NewPassword)
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Error hashing password")
+			return
+		}
+
+		if err := replacePassword(r.Context(), tx, staff.ID, currentHash, hashedPassword, false, policy.HistorySize); err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+
+		if err := revocations.RevokeStaff(r.Context(), staff.ID, time.Now()); err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+
+		utils.ResponseWithJSON(w, http.StatusOK, "Password changed", nil)
+	}
+}
+
+// ResetStaffPassword gives a staff member of the caller's hospital a temporary
+// password, returned once, that must be changed at the next sign-in. Existing
+// sessions are signed out and any login lockout is lifted.
+func ResetStaffPassword(db *sql.DB, revocations services.TokenRevocationStore) http.HandlerFunc {
+	historySize := config.GetPasswordPolicyConfig().HistorySize
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		staff, ok := staffFromContext(r)
+		if !ok {
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		staffID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil || staffID <= 0 {
+			utils.ResponseWithError(w, http.StatusBadRequest, "Invalid staff ID")
+			return
+		}
+
+		if staffID == staff.ID {
+			utils.ResponseWithError(w, http.StatusForbidden, "Cannot reset your own password")
+			return
+		}
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		defer tx.Rollback()
+
+		var username, currentHash string
+		err = tx.QueryRowContext(r.Context(), "SELECT username, password FROM staff WHERE id = $1 AND hospital = $2 FOR UPDATE", staffID, staff.Hospital).Scan(&username, &currentHash)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				utils.ResponseWithError(w, http.StatusNotFound, "Staff not found")
+			} else {
+				utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			}
+			return
+		}
+
+		temporaryPassword, err := services.GenerateTemporaryPassword()
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Failed to generate password")
+			return
+		}
+		hashedPassword, err := services.HashPassword(temporaryPassword)
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Error hashing password")
+			return
+		}
+
+		if err := replacePassword(r.Context(), tx, staffID, currentHash, hashedPassword, true, historySize); err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+
+		if err := revocations.RevokeStaff(r.Context(), staffID, time.Now()); err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+
+		if _, err := db.ExecContext(r.Context(), "DELETE FROM login_throttles WHERE key = $1", accountThrottleKey(staff.Hospital, username)); err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+
+		utils.ResponseWithSuccess(w, http.StatusOK, models.PasswordResetResponse{
+			StaffID:           staffID,
+			TemporaryPassword: temporaryPassword,
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/roasted99/hospital-middleware/internal/api/handlers"
+	"github.com/roasted99/hospital-middleware/internal/api/middleware"
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestChangePassword(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	staff := &models.Staff{ID: 3, Username: "nurse3", Hospital: "Hospital A", Role: "nurse", PasswordChangeRequired: true}
+	currentHash, _ := bcrypt.GenerateFromPassword([]byte("Temporary-Pass-1"), bcrypt.MinCost)
+	previousHash, _ := bcrypt.GenerateFromPassword([]byte("Old-Ward-Pass-9"), bcrypt.MinCost)
+
+	expectStaff := func() {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT username, password FROM staff WHERE id = \\$1 FOR UPDATE").
+			WithArgs(3).
+			WillReturnRows(sqlmock.NewRows([]string{"username", "password"}).AddRow("nurse3", string(currentHash)))
+	}
+
+	tests := []struct {
+		name            string
+		body            string
+		mockSetup       func()
+		expectedStatus  int
+		expectedMessage string
+		revoked         bool
+	}{
+		{
+			name: "Changes the password and signs out every session",
+			body: `{"current_password":"Temporary-Pass-1","new_password":"Night-Shift-42!"}`,
+			mockSetup: func() {
+				expectStaff()
+				mock.ExpectQuery("SELECT password_hash FROM password_history WHERE staff_id = \\$1").
+					WithArgs(3, 4).
+					WillReturnRows(sqlmock.NewRows([]string{"password_hash"}).AddRow(string(previousHash)))
+				mock.ExpectExec("UPDATE staff SET password = \\$1, password_change_required = \\$2").
+					WithArgs(sqlmock.AnyArg(), false, 3).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("INSERT INTO password_history").
+					WithArgs(3, string(currentHash)).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("DELETE FROM password_history").
+					WithArgs(3, 4).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec("UPDATE refresh_tokens SET revoked_at = NOW\\(\\) WHERE staff_id = \\$1").
+					WithArgs(3).
+					WillReturnResult(sqlmock.NewResult(0, 2))
+				mock.ExpectCommit()
+			},
+			expectedStatus:  http.StatusOK,
+			expectedMessage: "Password changed",
+			revoked:         true,
+		},
+		{
+			name: "Rejects a wrong current password",
+			body: `{"current_password":"guess","new_password":"Night-Shift-42!"}`,
+			mockSetup: func() {
+				expectStaff()
+				mock.ExpectRollback()
+			},
+			expectedStatus:  http.StatusBadRequest,
+			expectedMessage: "Current password is incorrect",
+		},
+		{
+			name: "Rejects a password that breaks the policy",
+			body: `{"current_password":"Temporary-Pass-1","new_password":"short"}`,
+			mockSetup: func() {
+				expectStaff()
+				mock.ExpectRollback()
+			},
+			expectedStatus:  http.StatusBadRequest,
+			expectedMessage: "password must be at least 12 characters",
+		},
+		{
+			name: "Rejects a recently used password",
+			body: `{"current_password":"Temporary-Pass-1","new_password":"Old-Ward-Pass-9"}`,
+			mockSetup: func() {
+				expectStaff()
+				mock.ExpectQuery("SELECT password_hash FROM password_history").
+					WithArgs(3, 4).
+					WillReturnRows(sqlmock.NewRows([]string{"password_hash"}).AddRow(string(previousHash)))
+				mock.ExpectRollback()
+			},
+			expectedStatus:  http.StatusBadRequest,
+			expectedMessage: "password must not match one of the last 5 passwords",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mockSetup()
+			store := &recordingRevocationStore{}
+
+			req := httptest.NewRequest(http.MethodPost, "/staff/password/change", bytes.NewBufferString(tt.body))
+			req = req.WithContext(context.WithValue(req.Context(), middleware.StaffKey, staff))
+			rr := httptest.NewRecorder()
+			handlers.ChangePassword(db, store)(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			var response map[string]interface{}
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+			assert.Equal(t, tt.expectedMessage, response["message"])
+			if tt.revoked {
+				assert.Equal(t, []int{3}, store.staff)
+			} else {
+				assert.Empty(t, store.staff)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestResetStaffPassword(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	admin := &models.Staff{ID: 1, Username: "admin", Hospital: "Hospital A", Role: "admin"}
+	store := &recordingRevocationStore{}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT username, password FROM staff WHERE id = \\$1 AND hospital = \\$2 FOR UPDATE").
+		WithArgs(7, "Hospital A").
+		WillReturnRows(sqlmock.NewRows([]string{"username", "password"}).AddRow("Staff7", "old-hash"))
+	mock.ExpectExec("UPDATE staff SET password = \\$1, password_change_required = \\$2").
+		WithArgs(sqlmock.AnyArg(), true, 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO password_history").
+		WithArgs(7, "old-hash").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DELETE FROM password_history").
+		WithArgs(7, 4).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE refresh_tokens SET revoked_at = NOW\\(\\) WHERE staff_id = \\$1").
+		WithArgs(7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec("DELETE FROM login_throttles WHERE key = \\$1").
+		WithArgs("account:Hospital A:staff7").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/staff/7/password/reset", nil)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.StaffKey, admin))
+	req = mux.SetURLVars(req, map[string]string{"id": "7"})
+	rr := httptest.NewRecorder()
+	handlers.ResetStaffPassword(db, store)(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response struct {
+		Data models.PasswordResetResponse `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, 7, response.Data.StaffID)
+	assert.Len(t, response.Data.TemporaryPassword, 20)
+	assert.Equal(t, []int{7}, store.staff)
+
+	// Admins change their own password with the current one instead
+	req = httptest.NewRequest(http.MethodPost, "/admin/staff/1/password/reset", nil)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.StaffKey, admin))
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	rr = httptest.NewRecorder()
+	handlers.ResetStaffPassword(db, store)(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -1,19 +1,21 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
-	"strconv"
-	"strings"
 
 	"github.com/roasted99/hospital-middleware/internal/api/middleware"
+	"github.com/roasted99/hospital-middleware/internal/db/patientquery"
 	"github.com/roasted99/hospital-middleware/internal/models"
 	"github.com/roasted99/hospital-middleware/internal/services"
 	"github.com/roasted99/hospital-middleware/internal/utils"
 )
 
-func SearchPatient(db *sql.DB) http.HandlerFunc {
+func SearchPatient(db *sql.DB, hospitals *services.HospitalRegistry) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		staffCtx := r.Context().Value(middleware.StaffKey)
 		if staffCtx == nil {
@@ -22,144 +24,190 @@ func SearchPatient(db *sql.DB) http.HandlerFunc {
 		}
 		staff := staffCtx.(*models.Staff)
 
-		query := models.PatientSearchRequest{
-			NationalID:  r.URL.Query().Get("national_id"),
-			PassportID:  r.URL.Query().Get("passport_id"),
-			FirstName:   r.URL.Query().Get("first_name"),
-			MiddleName:  r.URL.Query().Get("middle_name"),
-			LastName:    r.URL.Query().Get("last_name"),
-			DateOfBirth: r.URL.Query().Get("date_of_birth"),
-			PhoneNumber: r.URL.Query().Get("phone_number"),
-			Email:       r.URL.Query().Get("email"),
+		query := patientSearchRequestFromQuery(r)
+		audit := auditEntry(r)
+		audit.Criteria = searchCriteria(query)
+
+		page, err := patientPageFromQuery(r)
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusBadRequest, err.Error())
+			return
 		}
 
-		if strings.EqualFold(staff.Hospital, "Hospital A") {
-			if query.NationalID != "" || query.PassportID != "" {
-				searchID := query.NationalID
-				if searchID == "" {
-					searchID = query.PassportID
-				}
+		purpose, ok := consentPurposeFromQuery(w, r, staff)
+		if !ok {
+			return
+		}
+		audit.Criteria["purpose"] = purpose
+
+		// Hospitals without a registered system are served from the local
+		// records alone
+		client, hasClient := hospitals.Client(staff.Hospital)
+
+		// Without consent the hospital system is not contacted; the local
+		// records are still searched and the error is only reported when
+		// they have no match
+		var consentErr *services.ConsentRequiredError
+
+		// A cursor continues the source that issued it: a page of the
+		// hospital's results asks the hospital again, a page of local
+		// results does not
+		upstreamCursor := page.Cursor != nil && page.Cursor.Source != ""
+		if upstreamCursor && (!hasClient || page.Cursor.Source != staff.Hospital) {
+			utils.ResponseWithError(w, http.StatusBadRequest, "cursor is invalid or was issued for a different sort")
+			return
+		}
+		if hasClient && query.HasCriteria() && (page.Cursor == nil || upstreamCursor) {
+			patients, err := client.SearchPatients(services.WithConsentPurpose(r.Context(), staff.Hospital, purpose), query)
+			errors.As(err, &consentErr)
+			if err == nil {
+				audit.DataSource = staff.Hospital
+				total := len(patients)
+				patients, meta := page.slice(staff.Hospital, patients)
+				audit.PatientIDs = patientAuditIDs(patients)
+				meta.Total = &total
+				utils.ResponseWithPage(w, http.StatusOK, disclosePatients(r, staff, patients), meta)
+				return
+			}
+			if upstreamCursor {
+				audit.DataSource = staff.Hospital
+				respondUpstreamPageError(w, staff.Hospital, err, consentErr)
+				return
+			}
+		}
 
-				client := services.NewHospitalAClient()
+		audit.DataSource = LocalSource
+		builder := patientquery.ForHospital(staff.Hospital).Match(query)
 
-				patient, err := client.SearchPatient(searchID)
-				if err == nil {
-					utils.ResponseWithSuccess(w, http.StatusOK, patient)
-					return
-				}
+		var total *int
+		if page.IncludeTotal {
+			var count int
+			countQuery, countArgs := builder.Count()
+			if err := db.QueryRowContext(r.Context(), countQuery, countArgs...).Scan(&count); err != nil {
+				utils.ResponseWithError(w, http.StatusInternalServerError, "Failed to search patient")
+				return
 			}
+			total = &count
+		}
 
-			var queryArgs []interface{}
-			var conditions []string
-			var counter int = 1
+		sqlQuery, queryArgs := page.apply(builder).Select(patientColumns)
+		patients, err := queryPatients(r.Context(), db, sqlQuery, queryArgs...)
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Failed to search patient")
+			return
+		}
+		if len(patients) == 0 && page.Cursor == nil && consentErr != nil {
+			utils.ResponseWithError(w, http.StatusForbidden, "Consent required: "+consentErr.Reason)
+			return
+		}
+		if len(patients) == 0 && page.Cursor == nil {
+			utils.ResponseWithError(w, http.StatusNotFound, "No patient found")
+			return
+		}
 
-			sqlQuery := "SELECT * FROM patient WHERE hospital = $1"
-			queryArgs = append(queryArgs, staff.Hospital)
-			counter++
+		patients, meta := page.trim(patients)
+		audit.PatientIDs = patientAuditIDs(patients)
+		meta.Total = total
+		utils.ResponseWithPage(w, http.StatusOK, disclosePatients(r, staff, patients), meta)
+	}
 
-			if query.NationalID != "" {
-				conditions = append(conditions, "national_id = $"+strconv.Itoa(counter))
-				queryArgs = append(queryArgs, query.NationalID)
-				counter++
-			}
+}
 
-			if query.PassportID != "" {
-				conditions = append(conditions, "passport_id = $"+strconv.Itoa(counter))
-				queryArgs = append(queryArgs, query.PassportID)
-				counter++
-			}
+// respondUpstreamPageError reports a hospital system that failed while a client
+// was paging through its results; the local records are not a continuation
+func respondUpstreamPageError(w http.ResponseWriter, hospital string, err error, consentErr *services.ConsentRequiredError) {
+	switch {
+	case consentErr != nil:
+		utils.ResponseWithError(w, http.StatusForbidden, "Consent required: "+consentErr.Reason)
+	case errors.Is(err, services.ErrPatientNotFound):
+		utils.ResponseWithError(w, http.StatusNotFound, "No patient found")
+	default:
+		log.Printf("Error searching %s for the next page: %v", hospital, err)
+		utils.ResponseWithError(w, http.StatusBadGateway, hospital+" is unavailable, search again later")
+	}
+}
 
-			if query.FirstName != "" {
-				conditions = append(conditions, "first_name_en ILIKE $"+strconv.Itoa(counter)+" OR first_name_th ILIKE $"+strconv.Itoa(counter+1))
-				queryArgs = append(queryArgs, "%"+query.FirstName+"%")
-				queryArgs = append(queryArgs, "%"+query.FirstName+"%")
-				counter +=2
-			}
+// patientColumns are the columns scanned by queryPatients, in order
+const patientColumns = "id, first_name_th, middle_name_th, last_name_th, first_name_en, middle_name_en, last_name_en, date_of_birth, patient_hn, national_id, passport_id, phone_number, email, gender, hospital, created_at, updated_at"
+
+func patientSearchRequestFromQuery(r *http.Request) models.PatientSearchRequest {
+	return models.PatientSearchRequest{
+		NationalID:  r.URL.Query().Get("national_id"),
+		PassportID:  r.URL.Query().Get("passport_id"),
+		PatientHN:   r.URL.Query().Get("patient_hn"),
+		FirstName:   r.URL.Query().Get("first_name"),
+		MiddleName:  r.URL.Query().Get("middle_name"),
+		LastName:    r.URL.Query().Get("last_name"),
+		DateOfBirth: r.URL.Query().Get("date_of_birth"),
+		PhoneNumber: r.URL.Query().Get("phone_number"),
+		Email:       r.URL.Query().Get("email"),
+	}
+}
 
-			if query.MiddleName != "" {
-				conditions = append(conditions, "middle_name_en ILIKE $"+strconv.Itoa(counter)+" OR middle_name_th ILIKE $"+strconv.Itoa(counter+1))
-				queryArgs = append(queryArgs, "%"+query.MiddleName+"%")
-				queryArgs = append(queryArgs, "%"+query.MiddleName+"%")
-				counter += 2
-			}
+// consentPurposeFromQuery reads the purpose of a lookup, which must match the
+// patient's consent before other hospital systems are asked. Lookups with an
+// emergency access token always have the emergency purpose.
+func consentPurposeFromQuery(w http.ResponseWriter, r *http.Request, staff *models.Staff) (string, bool) {
+	if staff.BreakGlassID != 0 {
+		return services.ConsentPurposeEmergency, true
+	}
+	purpose := r.URL.Query().Get("purpose")
+	if purpose == "" {
+		return services.DefaultConsentPurpose, true
+	}
+	if !services.IsValidConsentPurpose(purpose) {
+		utils.ResponseWithError(w, http.StatusBadRequest, "purpose must be one of treatment, billing or research")
+		return "", false
+	}
+	return purpose, true
+}
 
-			if query.LastName != "" {
-				conditions = append(conditions, "last_name_en ILIKE $"+strconv.Itoa(counter)+" OR last_name_th ILIKE $"+strconv.Itoa(counter+1))
-				queryArgs = append(queryArgs, "%"+query.LastName+"%")
-				queryArgs = append(queryArgs, "%"+query.LastName+"%")
-				counter += 2
-			}
+// disclosePatients reduces the sensitive fields of patients to what the caller's
+// role may see at their hospital. The patients are copied, so cached hospital
+// results keep their full values.
+func disclosePatients(r *http.Request, staff *models.Staff, patients []models.Patient) []models.Patient {
+	disclosed := make([]models.Patient, len(patients))
+	for i, patient := range patients {
+		disclosed[i] = disclosePatient(r, staff, patient)
+	}
+	return disclosed
+}
 
-			if query.DateOfBirth != "" {
-				conditions = append(conditions, "date_of_birth::text LIKE $"+strconv.Itoa(counter))
-				queryArgs = append(queryArgs, "%"+query.DateOfBirth+"%")
-				counter++
-			}
+func disclosePatient(r *http.Request, staff *models.Staff, patient models.Patient) models.Patient {
+	return services.CurrentDisclosurePolicy().Disclose(staff.Hospital, disclosureRole(r, staff), patient)
+}
 
-			if query.PhoneNumber != "" {
-				conditions = append(conditions, "phone_number ILIKE $"+strconv.Itoa(counter))
-				queryArgs = append(queryArgs, "%"+query.PhoneNumber+"%")
-				counter++
-			}
+// disclosureRole is the caller's role in the disclosure policy
+func disclosureRole(r *http.Request, staff *models.Staff) string {
+	if r.Context().Value(middleware.APIKeyKey) != nil {
+		return services.DisclosureRoleAPIKey
+	}
+	return staff.Role
+}
 
-			if query.Email != "" {
-				conditions = append(conditions, "email ILIKE $"+strconv.Itoa(counter))
-				queryArgs = append(queryArgs, "%"+query.Email+"%")
-				counter++
-			}
+func queryPatients(ctx context.Context, db *sql.DB, sqlQuery string, queryArgs ...interface{}) ([]models.Patient, error) {
+	rows, err := db.QueryContext(ctx, sqlQuery, queryArgs...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var patients []models.Patient
+	for rows.Next() {
+		var p models.Patient
+		// Optional columns are stored as NULL when they are empty
+		var firstNameTH, middleNameTH, lastNameTH, firstNameEN, middleNameEN, lastNameEN, nationalID, passportID, phoneNumber, email sql.NullString
+		err := rows.Scan(&p.ID, &firstNameTH, &middleNameTH, &lastNameTH, &firstNameEN, &middleNameEN, &lastNameEN, &p.DateOfBirth, &p.PatientHN, &nationalID, &passportID, &phoneNumber, &email, &p.Gender, &p.Hospital, &p.CreatedAt, &p.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan patient: %w", err)
+		}
 
-			if len(conditions) > 0 {
-				sqlQuery += " AND " + strings.Join(conditions, " AND ")
-			}
-			fmt.Println(sqlQuery)
-			fmt.Println(queryArgs)
+		p.FirstNameTH, p.MiddleNameTH, p.LastNameTH = firstNameTH.String, middleNameTH.String, lastNameTH.String
+		p.FirstNameEN, p.MiddleNameEN, p.LastNameEN = firstNameEN.String, middleNameEN.String, lastNameEN.String
+		p.NationalID, p.PassportID = nationalID.String, passportID.String
+		p.PhoneNumber, p.Email = phoneNumber.String, email.String
 
-			rows, err := db.Query(sqlQuery, queryArgs...)
-			if err != nil {
-				fmt.Println(err)
-				utils.ResponseWithError(w, http.StatusInternalServerError, "Failed to search patient")
-				return
-			}
-			defer rows.Close()
-
-			var patients []models.Patient
-			for rows.Next() {
-				var p models.Patient
-				var middleNameTH, middleNameEN, nationalID, passportID sql.NullString
-				err := rows.Scan(&p.ID, &p.FirstNameTH, &middleNameTH, &p.LastNameTH, &p.FirstNameEN, &middleNameEN, &p.LastNameEN, &p.DateOfBirth, &p.PatientHN, &nationalID, &passportID, &p.PhoneNumber, &p.Email, &p.Gender, &p.Hospital, &p.CreatedAt, &p.UpdatedAt)
-
-				if middleNameTH.Valid {
-					p.MiddleNameTH = middleNameTH.String
-				}
-
-				if middleNameEN.Valid {
-					p.MiddleNameEN = middleNameEN.String
-				}
-
-				if nationalID.Valid {
-					p.NationalID = nationalID.String
-				}
-
-				if passportID.Valid {
-					p.PassportID = passportID.String
-				}
-
-				if err != nil {
-					utils.ResponseWithError(w, http.StatusInternalServerError, "Failed to scan patient")
-					fmt.Println(err)
-					return
-				}
-				patients = append(patients, p)
-			}
-			fmt.Println(patients)
-			if len(patients) == 0 {
-				utils.ResponseWithError(w, http.StatusNotFound, "No patient found")
-				return
-			}
-			utils.ResponseWithSuccess(w, http.StatusOK, patients)
-		} else {
-			utils.ResponseWithError(w, http.StatusBadRequest, staff.Hospital+" is not supported yet")
-		}
+		patients = append(patients, p)
 	}
-
+	return patients, rows.Err()
 }
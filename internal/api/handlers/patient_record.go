@@ -0,0 +1,311 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/roasted99/hospital-middleware/internal/api/middleware"
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/roasted99/hospital-middleware/internal/utils"
+)
+
+// CreatePatient adds a locally managed patient record to the caller's hospital
+func CreatePatient(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		staff, ok := staffFromContext(r)
+		if !ok {
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		var request models.PatientRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			utils.ResponseWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		audit := auditEntry(r)
+		audit.DataSource = LocalSource
+		services.NormalizePatientRequest(&request)
+		if err := services.ValidatePatientRequest(request); err != nil {
+			utils.ResponseWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		var id int
+		err := db.QueryRowContext(r.Context(),
+			"INSERT INTO patient (first_name_th, middle_name_th, last_name_th, first_name_en, middle_name_en, last_name_en, date_of_birth, patient_hn, national_id, passport_id, phone_number, email, gender, hospital, created_at, updated_at) "+
+				"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, NOW(), NOW()) RETURNING id",
+			patientRecordArgs(request, staff.Hospital)...).Scan(&id)
+		if err != nil {
+			respondPatientWriteError(w, err)
+			return
+		}
+		audit.PatientIDs = []string{strconv.Itoa(id)}
+
+		patient, err := getPatient(r, db, id, staff.Hospital)
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		utils.ResponseWithSuccess(w, http.StatusCreated, disclosePatient(r, staff, *patient))
+	}
+}
+
+// GetPatient returns a locally managed patient record of the caller's hospital
+func GetPatient(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		staff, ok := staffFromContext(r)
+		if !ok {
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		id, ok := patientIDFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		audit := auditPatientRequest(r, id)
+		patient, err := getPatient(r, db, id, staff.Hospital)
+		if err != nil {
+			respondPatientReadError(w, err)
+			return
+		}
+		audit.PatientIDs = []string{strconv.Itoa(id)}
+		utils.ResponseWithSuccess(w, http.StatusOK, disclosePatient(r, staff, *patient))
+	}
+}
+
+// UpdatePatient replaces (PUT) or partially updates (PATCH) a patient record
+func UpdatePatient(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		staff, ok := staffFromContext(r)
+		if !ok {
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		id, ok := patientIDFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		audit := auditPatientRequest(r, id)
+		var request models.PatientRequest
+		var stored *models.PatientRequest
+		if r.Method == http.MethodPatch {
+			existing, err := getPatient(r, db, id, staff.Hospital)
+			if err != nil {
+				respondPatientReadError(w, err)
+				return
+			}
+
+			var patch models.PatientPatchRequest
+			if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+				utils.ResponseWithError(w, http.StatusBadRequest, "Invalid request payload")
+				return
+			}
+			current := patientRequestFromPatient(*existing)
+			services.NormalizePatientRequest(&current)
+			stored = &current
+			request = applyPatientPatch(current, patch)
+		} else if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			utils.ResponseWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		services.NormalizePatientRequest(&request)
+		validationErr := services.ValidatePatientRequest(request)
+		if stored != nil {
+			validationErr = services.ValidatePatientPatch(*stored, request)
+		}
+		if validationErr != nil {
+			utils.ResponseWithError(w, http.StatusBadRequest, validationErr.Error())
+			return
+		}
+
+		args := append(patientRecordArgs(request, staff.Hospital), id)
+		result, err := db.ExecContext(r.Context(),
+			"UPDATE patient SET first_name_th = $1, middle_name_th = $2, last_name_th = $3, first_name_en = $4, middle_name_en = $5, last_name_en = $6, "+
+				"date_of_birth = $7, patient_hn = $8, national_id = $9, passport_id = $10, phone_number = $11, email = $12, gender = $13, updated_at = NOW() "+
+				"WHERE hospital = $14 AND id = $15 AND deleted_at IS NULL",
+			args...)
+		if err != nil {
+			respondPatientWriteError(w, err)
+			return
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			utils.ResponseWithError(w, http.StatusNotFound, "Patient not found")
+			return
+		}
+		audit.PatientIDs = []string{strconv.Itoa(id)}
+
+		patient, err := getPatient(r, db, id, staff.Hospital)
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		utils.ResponseWithSuccess(w, http.StatusOK, disclosePatient(r, staff, *patient))
+	}
+}
+
+// DeletePatient soft-deletes a patient record; it no longer appears in searches
+func DeletePatient(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		staff, ok := staffFromContext(r)
+		if !ok {
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		id, ok := patientIDFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		audit := auditPatientRequest(r, id)
+		result, err := db.ExecContext(r.Context(),
+			"UPDATE patient SET deleted_at = NOW(), updated_at = NOW() WHERE hospital = $1 AND id = $2 AND deleted_at IS NULL",
+			staff.Hospital, id)
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			utils.ResponseWithError(w, http.StatusNotFound, "Patient not found")
+			return
+		}
+		audit.PatientIDs = []string{strconv.Itoa(id)}
+
+		utils.ResponseWithJSON(w, http.StatusOK, "Patient deleted", nil)
+	}
+}
+
+func staffFromContext(r *http.Request) (*models.Staff, bool) {
+	staff, ok := r.Context().Value(middleware.StaffKey).(*models.Staff)
+	return staff, ok && staff != nil
+}
+
+func patientIDFromPath(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || id <= 0 {
+		utils.ResponseWithError(w, http.StatusBadRequest, "Invalid patient ID")
+		return 0, false
+	}
+	return id, true
+}
+
+// auditPatientRequest notes the requested patient record in the audit entry.
+// Its ID is only added to the patient IDs once the record was found.
+func auditPatientRequest(r *http.Request, id int) *models.AuditEntry {
+	audit := auditEntry(r)
+	audit.DataSource = LocalSource
+	audit.Criteria = map[string]string{"patient_id": strconv.Itoa(id)}
+	return audit
+}
+
+func getPatient(r *http.Request, db *sql.DB, id int, hospital string) (*models.Patient, error) {
+	patients, err := queryPatients(r.Context(), db,
+		"SELECT "+patientColumns+" FROM patient WHERE hospital = $1 AND id = $2 AND deleted_at IS NULL",
+		hospital, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(patients) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &patients[0], nil
+}
+
+// patientRecordArgs are the column values of a patient record. Every optional
+// field is stored as NULL when empty, so created and patched records agree.
+func patientRecordArgs(request models.PatientRequest, hospital string) []interface{} {
+	return []interface{}{
+		nullIfEmpty(request.FirstNameTH), nullIfEmpty(request.MiddleNameTH), nullIfEmpty(request.LastNameTH),
+		nullIfEmpty(request.FirstNameEN), nullIfEmpty(request.MiddleNameEN), nullIfEmpty(request.LastNameEN),
+		request.DateOfBirth, request.PatientHN, nullIfEmpty(request.NationalID), nullIfEmpty(request.PassportID),
+		nullIfEmpty(request.PhoneNumber), nullIfEmpty(request.Email), request.Gender, hospital,
+	}
+}
+
+func patientRequestFromPatient(patient models.Patient) models.PatientRequest {
+	request := models.PatientRequest{
+		FirstNameTH:  patient.FirstNameTH,
+		MiddleNameTH: patient.MiddleNameTH,
+		LastNameTH:   patient.LastNameTH,
+		FirstNameEN:  patient.FirstNameEN,
+		MiddleNameEN: patient.MiddleNameEN,
+		LastNameEN:   patient.LastNameEN,
+		PatientHN:    patient.PatientHN,
+		NationalID:   patient.NationalID,
+		PassportID:   patient.PassportID,
+		PhoneNumber:  patient.PhoneNumber,
+		Email:        patient.Email,
+		Gender:       patient.Gender,
+	}
+	if !patient.DateOfBirth.IsZero() {
+		request.DateOfBirth = patient.DateOfBirth.Format("2006-01-02")
+	}
+	return request
+}
+
+func applyPatientPatch(request models.PatientRequest, patch models.PatientPatchRequest) models.PatientRequest {
+	fields := []struct {
+		value *string
+		patch *string
+	}{
+		{&request.FirstNameTH, patch.FirstNameTH},
+		{&request.MiddleNameTH, patch.MiddleNameTH},
+		{&request.LastNameTH, patch.LastNameTH},
+		{&request.FirstNameEN, patch.FirstNameEN},
+		{&request.MiddleNameEN, patch.MiddleNameEN},
+		{&request.LastNameEN, patch.LastNameEN},
+		{&request.DateOfBirth, patch.DateOfBirth},
+		{&request.PatientHN, patch.PatientHN},
+		{&request.NationalID, patch.NationalID},
+		{&request.PassportID, patch.PassportID},
+		{&request.PhoneNumber, patch.PhoneNumber},
+		{&request.Email, patch.Email},
+		{&request.Gender, patch.Gender},
+	}
+	for _, field := range fields {
+		if field.patch != nil {
+			*field.value = *field.patch
+		}
+	}
+	return request
+}
+
+func nullIfEmpty(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+func respondPatientReadError(w http.ResponseWriter, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.ResponseWithError(w, http.StatusNotFound, "Patient not found")
+		return
+	}
+	log.Printf("Error reading patient record: %v", err)
+	utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+}
+
+func respondPatientWriteError(w http.ResponseWriter, err error) {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		utils.ResponseWithError(w, http.StatusConflict, "A patient with this national ID or passport ID already exists")
+		return
+	}
+	log.Printf("Error writing patient record: %v", err)
+	utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+}
@@ -0,0 +1,185 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/roasted99/hospital-middleware/internal/api/handlers"
+	"github.com/roasted99/hospital-middleware/internal/api/middleware"
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var patientRecordColumns = []string{"id", "first_name_th", "middle_name_th", "last_name_th", "first_name_en", "middle_name_en", "last_name_en", "date_of_birth", "patient_hn", "national_id", "passport_id", "phone_number", "email", "gender", "hospital", "created_at", "updated_at"}
+
+func patientRecordRow(id int, updatedAt time.Time) *sqlmock.Rows {
+	dob := time.Date(1980, 8, 20, 0, 0, 0, 0, time.UTC)
+	return sqlmock.NewRows(patientRecordColumns).
+		AddRow(id, "สมชาย", nil, "มีสุข", "Somchai", nil, "Meesuk", dob, "HN-00123", "1101500234564", nil, "0812345678", "jai@gmail.com", "M", "Hospital A", time.Now(), updatedAt)
+}
+
+func patientRecordRequest(method, url, body string, id string) *http.Request {
+	staff := &models.Staff{ID: 1, Username: "staff1", Hospital: "Hospital A"}
+	req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+	req = req.WithContext(context.WithValue(req.Context(), middleware.StaffKey, staff))
+	if id != "" {
+		req = mux.SetURLVars(req, map[string]string{"id": id})
+	}
+	return req
+}
+
+const validPatientBody = `{"first_name_th":"สมชาย","last_name_th":"มีสุข","first_name_en":"Somchai","last_name_en":"Meesuk","date_of_birth":"1980-08-20","patient_hn":"HN-00123","national_id":"1101500234564","phone_number":"0812345678","email":"jai@gmail.com","gender":"m"}`
+
+func TestCreatePatient(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("INSERT INTO patient").
+		WithArgs("สมชาย", nil, "มีสุข", "Somchai", nil, "Meesuk", "1980-08-20", "HN-00123", "1101500234564", nil, "0812345678", "jai@gmail.com", "M", "Hospital A").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	mock.ExpectQuery("SELECT .+ FROM patient WHERE hospital = \\$1 AND id = \\$2 AND deleted_at IS NULL").
+		WithArgs("Hospital A", 4).
+		WillReturnRows(patientRecordRow(4, time.Now()))
+
+	rr := httptest.NewRecorder()
+	handlers.CreatePatient(db)(rr, patientRecordRequest("POST", "/patient", validPatientBody, ""))
+
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreatePatientRejectsInvalidNationalID(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	body := bytes.Replace([]byte(validPatientBody), []byte("1101500234564"), []byte("1101500234567"), 1)
+	rr := httptest.NewRecorder()
+	handlers.CreatePatient(db)(rr, patientRecordRequest("POST", "/patient", string(body), ""))
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "national_id")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetPatientScopedToHospital(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT .+ FROM patient WHERE hospital = \\$1 AND id = \\$2 AND deleted_at IS NULL").
+		WithArgs("Hospital A", 9).
+		WillReturnRows(sqlmock.NewRows(patientRecordColumns))
+
+	rr := httptest.NewRecorder()
+	handlers.GetPatient(db)(rr, patientRecordRequest("GET", "/patient/9", "", "9"))
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPatchPatientUpdatesTimestamp(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	createdAt := time.Now().Add(-time.Hour)
+	mock.ExpectQuery("SELECT .+ FROM patient WHERE hospital = \\$1 AND id = \\$2 AND deleted_at IS NULL").
+		WithArgs("Hospital A", 4).
+		WillReturnRows(patientRecordRow(4, createdAt))
+	mock.ExpectExec("UPDATE patient SET .+ email = \\$12, gender = \\$13, updated_at = NOW\\(\\) WHERE hospital = \\$14 AND id = \\$15 AND deleted_at IS NULL").
+		WithArgs("สมชาย", nil, "มีสุข", "Somchai", nil, "Meesuk", "1980-08-20", "HN-00123", "1101500234564", nil, "0899999999", "jai@gmail.com", "M", "Hospital A", 4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT .+ FROM patient WHERE hospital = \\$1 AND id = \\$2 AND deleted_at IS NULL").
+		WithArgs("Hospital A", 4).
+		WillReturnRows(patientRecordRow(4, time.Now()))
+
+	rr := httptest.NewRecorder()
+	handlers.UpdatePatient(db)(rr, patientRecordRequest("PATCH", "/patient/4", `{"phone_number":"0899999999"}`, "4"))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPatchPatientStoresClearedFieldsAsNull(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	dob := time.Date(1980, 8, 20, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT .+ FROM patient WHERE hospital = \\$1 AND id = \\$2 AND deleted_at IS NULL").
+		WithArgs("Hospital A", 4).
+		WillReturnRows(patientRecordRow(4, time.Now()))
+	mock.ExpectExec("UPDATE patient SET").
+		WithArgs(nil, nil, nil, "Somchai", nil, "Meesuk", "1980-08-20", "HN-00123", "1101500234564", nil, nil, nil, "M", "Hospital A", 4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT .+ FROM patient WHERE hospital = \\$1 AND id = \\$2 AND deleted_at IS NULL").
+		WithArgs("Hospital A", 4).
+		WillReturnRows(sqlmock.NewRows(patientRecordColumns).
+			AddRow(4, nil, nil, nil, "Somchai", nil, "Meesuk", dob, "HN-00123", "1101500234564", nil, nil, nil, "M", "Hospital A", time.Now(), time.Now()))
+
+	rr := httptest.NewRecorder()
+	handlers.UpdatePatient(db)(rr, patientRecordRequest("PATCH", "/patient/4", `{"first_name_th":"","last_name_th":"","phone_number":"","email":""}`, "4"))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"phone_number":""`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPatchPatientKeepsStoredNationalID(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	dob := time.Date(1980, 8, 20, 0, 0, 0, 0, time.UTC)
+	seeded := func() *sqlmock.Rows {
+		return sqlmock.NewRows(patientRecordColumns).
+			AddRow(5, "สมชาย", nil, "มีสุข", "Somchai", nil, "Meesuk", dob, "HN-00123", "1101500234567", nil, "0812345678", "jai@gmail.com", "M", "Hospital A", time.Now(), time.Now())
+	}
+	mock.ExpectQuery("SELECT .+ FROM patient WHERE hospital = \\$1 AND id = \\$2 AND deleted_at IS NULL").
+		WithArgs("Hospital A", 5).
+		WillReturnRows(seeded())
+	mock.ExpectExec("UPDATE patient SET .+").
+		WithArgs("สมชาย", nil, "มีสุข", "Somchai", nil, "Meesuk", "1980-08-20", "HN-00123", "1101500234567", nil, "0812345678", "somchai@gmail.com", "M", "Hospital A", 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT .+ FROM patient WHERE hospital = \\$1 AND id = \\$2 AND deleted_at IS NULL").
+		WithArgs("Hospital A", 5).
+		WillReturnRows(seeded())
+
+	rr := httptest.NewRecorder()
+	handlers.UpdatePatient(db)(rr, patientRecordRequest("PATCH", "/patient/5", `{"email":"somchai@gmail.com"}`, "5"))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	mock.ExpectQuery("SELECT .+ FROM patient WHERE hospital = \\$1 AND id = \\$2 AND deleted_at IS NULL").
+		WithArgs("Hospital A", 5).
+		WillReturnRows(seeded())
+
+	rr = httptest.NewRecorder()
+	handlers.UpdatePatient(db)(rr, patientRecordRequest("PATCH", "/patient/5", `{"national_id":"2109876543210"}`, "5"))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeletePatientIsSoft(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectExec("UPDATE patient SET deleted_at = NOW\\(\\), updated_at = NOW\\(\\) WHERE hospital = \\$1 AND id = \\$2 AND deleted_at IS NULL").
+		WithArgs("Hospital A", 4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	rr := httptest.NewRecorder()
+	handlers.DeletePatient(db)(rr, patientRecordRequest("DELETE", "/patient/4", "", "4"))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
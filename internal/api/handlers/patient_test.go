@@ -3,6 +3,7 @@ package handlers_test
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	// "fmt"
 	"net/http"
 	"net/http/httptest"
@@ -12,9 +13,26 @@ import (
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/roasted99/hospital-middleware/internal/api/handlers"
 	"github.com/roasted99/hospital-middleware/internal/api/middleware"
+	"github.com/roasted99/hospital-middleware/internal/config"
 	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
 )
 
+type stubHospitalClient struct {
+	patients []models.Patient
+	err      error
+}
+
+func (c *stubHospitalClient) SearchPatients(ctx context.Context, query models.PatientSearchRequest) ([]models.Patient, error) {
+	return c.patients, c.err
+}
+
+func newStubRegistry(hospital string, client services.HospitalClient) *services.HospitalRegistry {
+	registry := services.NewHospitalRegistry()
+	registry.Register(hospital, client)
+	return registry
+}
+
 func createAuthenticatedRequest(method, url string, staff *models.Staff) *http.Request {
 	req, _ := http.NewRequest(method, url, nil)
 	ctx := context.WithValue(req.Context(), middleware.StaffKey, staff)
@@ -32,6 +50,7 @@ func TestSearchPatient(t *testing.T) {
 		name           string
 		staff          *models.Staff
 		url            string
+		client         services.HospitalClient
 		mockSetup      func()
 		expectedStatus int
 		expectedBody   map[string]interface{}
@@ -45,7 +64,7 @@ func TestSearchPatient(t *testing.T) {
 			},
 			url: "/patient/search?national_id=1234567890123",
 			mockSetup: func() {
-				mock.ExpectQuery("SELECT \\* FROM patient WHERE hospital = \\$1 AND national_id = \\$2").
+				mock.ExpectQuery("SELECT .+ FROM patient WHERE hospital = \\$1 AND deleted_at IS NULL AND \\(national_id = \\$2\\)").
 					WithArgs("Hospital A", "1234567890123").
 					WillReturnRows(sqlmock.NewRows([]string{"id", "first_name_th", "middle_name_th", "last_name_th", "first_name_en", "middle_name_en", "last_name_en", "date_of_birth", "patient_hn", "national_id", "passport_id", "phone_number", "email", "gender", "hospital", "created_at", "updated_at"}).
 						AddRow(1, "ทดสอบ", "กลาง", "สุดท้าย", "Test", "Middle", "Last", time.Now(), "HN123456", "1234567890123", "", "0123456789", "test@email.com", "M", "Hospital A", time.Now(), time.Now()))
@@ -84,7 +103,7 @@ func TestSearchPatient(t *testing.T) {
 			},
 			url: "/patient/search?first_name=Test&last_name=Last",
 			mockSetup: func() {
-				mock.ExpectQuery("SELECT \\* FROM patient WHERE hospital = \\$1 AND first_name_en ILIKE \\$2 OR first_name_th ILIKE \\$3 AND last_name_en ILIKE \\$4 OR last_name_th ILIKE \\$5").
+				mock.ExpectQuery("SELECT .+ FROM patient WHERE hospital = \\$1 AND deleted_at IS NULL AND \\(\\(first_name_en ILIKE \\$2 OR first_name_th ILIKE \\$3\\) AND \\(last_name_en ILIKE \\$4 OR last_name_th ILIKE \\$5\\)\\)").
 					WithArgs("Hospital A", "%"+"Test"+"%", "%"+"Test%", "%Last%", "%Last%").
 					WillReturnRows(sqlmock.NewRows([]string{"id", "first_name_th", "middle_name_th", "last_name_th", "first_name_en", "middle_name_en", "last_name_en", "date_of_birth", "patient_hn", "national_id", "passport_id", "phone_number", "email", "gender", "hospital", "created_at", "updated_at"}).
 						AddRow(1, "ทดสอบ", "กลาง", "สุดท้าย", "Test", "Middle", "Last", time.Now(), "HN123456", "1234567890123", "", "0123456789", "test@email.com", "M", "Hospital A", time.Now(), time.Now()))
@@ -123,7 +142,7 @@ func TestSearchPatient(t *testing.T) {
 			},
 			url: "/patient/search?passport_id=12345678",
 			mockSetup: func() {
-				mock.ExpectQuery("SELECT \\* FROM patient WHERE hospital = \\$1 AND passport_id = \\$2").
+				mock.ExpectQuery("SELECT .+ FROM patient WHERE hospital = \\$1 AND deleted_at IS NULL AND \\(passport_id = \\$2\\)").
 					WithArgs("Hospital A", "12345678").
 					WillReturnRows(sqlmock.NewRows([]string{"id", "first_name_th", "middle_name_th", "last_name_th", "first_name_en", "middle_name_en", "last_name_en", "date_of_birth", "patient_hn", "national_id", "passport_id", "phone_number", "email", "gender", "hospital"}))
 			},
@@ -133,6 +152,78 @@ func TestSearchPatient(t *testing.T) {
 				"message": "No patient found",
 			},
 		},
+		{
+			name: "Patient found in hospital system",
+			staff: &models.Staff{
+				Hospital: "hospital a",
+				Username: "staff1",
+				ID:       1,
+			},
+			url:    "/patient/search?national_id=1234567890123",
+			client:         &stubHospitalClient{patients: []models.Patient{{FirstNameEN: "Test", NationalID: "1234567890123", Hospital: "Hospital A"}}},
+			mockSetup:      func() {},
+			expectedStatus: http.StatusOK,
+			expectedBody: map[string]interface{}{
+				"status":  "OK",
+				"message": "Success",
+			},
+		},
+		{
+			name: "Demographic search served by hospital system",
+			staff: &models.Staff{
+				Hospital: "Hospital A",
+				Username: "staff1",
+				ID:       1,
+			},
+			url:            "/patient/search?first_name=Test&date_of_birth=2000-01-01",
+			client:         &stubHospitalClient{patients: []models.Patient{{FirstNameEN: "Test", Hospital: "Hospital A"}}},
+			mockSetup:      func() {},
+			expectedStatus: http.StatusOK,
+			expectedBody: map[string]interface{}{
+				"status":  "OK",
+				"message": "Success",
+			},
+		},
+		{
+			name: "Search by HN",
+			staff: &models.Staff{
+				Hospital: "Hospital A",
+				Username: "staff1",
+				ID:       1,
+			},
+			url: "/patient/search?patient_hn=HN123456",
+			mockSetup: func() {
+				mock.ExpectQuery("SELECT .+ FROM patient WHERE hospital = \\$1 AND deleted_at IS NULL AND \\(patient_hn = \\$2\\)").
+					WithArgs("Hospital A", "HN123456").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "first_name_th", "middle_name_th", "last_name_th", "first_name_en", "middle_name_en", "last_name_en", "date_of_birth", "patient_hn", "national_id", "passport_id", "phone_number", "email", "gender", "hospital", "created_at", "updated_at"}).
+						AddRow(1, "ทดสอบ", "กลาง", "สุดท้าย", "Test", "Middle", "Last", time.Now(), "HN123456", "1234567890123", "", "0123456789", "test@email.com", "M", "Hospital A", time.Now(), time.Now()))
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: map[string]interface{}{
+				"status":  "OK",
+				"message": "Success",
+			},
+		},
+		{
+			name: "Hospital without a registered adapter searches local records",
+			staff: &models.Staff{
+				Hospital: "Hospital Z",
+				Username: "staff1",
+				ID:       1,
+			},
+			url: "/patient/search?national_id=1234567890123",
+			mockSetup: func() {
+				mock.ExpectQuery("SELECT .+ FROM patient WHERE hospital = \\$1 AND deleted_at IS NULL AND \\(national_id = \\$2\\)").
+					WithArgs("Hospital Z", "1234567890123").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "first_name_th", "middle_name_th", "last_name_th", "first_name_en", "middle_name_en", "last_name_en", "date_of_birth", "patient_hn", "national_id", "passport_id", "phone_number", "email", "gender", "hospital", "created_at", "updated_at"}).
+						AddRow(2, "ทดสอบ", "", "สุดท้าย", "Test", "", "Last", time.Now(), "HNZ0001", "1234567890123", "", "0123456789", "test@email.com", "M", "Hospital Z", time.Now(), time.Now()))
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: map[string]interface{}{
+				"status":  "OK",
+				"message": "Success",
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -144,7 +235,11 @@ func TestSearchPatient(t *testing.T) {
 
 			rr := httptest.NewRecorder()
 
-			handler := handlers.SearchPatient(db)
+			client := tt.client
+			if client == nil {
+				client = &stubHospitalClient{err: errors.New("upstream unavailable")}
+			}
+			handler := handlers.SearchPatient(db, newStubRegistry("Hospital A", client))
 
 			handler(rr, req)
 
@@ -173,3 +268,214 @@ func TestSearchPatient(t *testing.T) {
 		})
 	}
 }
+
+func TestSearchPatientPagination(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open sqlmock: %s", err)
+	}
+	defer db.Close()
+
+	staff := &models.Staff{Hospital: "Hospital A", Username: "staff1", ID: 1}
+	registry := newStubRegistry("Hospital A", &stubHospitalClient{err: errors.New("upstream unavailable")})
+	columns := []string{"id", "first_name_th", "middle_name_th", "last_name_th", "first_name_en", "middle_name_en", "last_name_en", "date_of_birth", "patient_hn", "national_id", "passport_id", "phone_number", "email", "gender", "hospital", "created_at", "updated_at"}
+	dob := time.Date(1980, 8, 20, 0, 0, 0, 0, time.UTC)
+
+	// First page: one extra row signals that another page follows
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM patient WHERE hospital = \\$1 AND deleted_at IS NULL AND \\(last_name_en ILIKE \\$2 OR last_name_th ILIKE \\$3\\)").
+		WithArgs("Hospital A", "%Mee%", "%Mee%").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectQuery("SELECT .+ FROM patient WHERE hospital = \\$1 AND deleted_at IS NULL AND .+ ORDER BY COALESCE\\(date_of_birth, DATE '0001-01-01'\\) DESC, id DESC LIMIT 2").
+		WithArgs("Hospital A", "%Mee%", "%Mee%").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(3, "ก", nil, "ข", "Somjai", nil, "Meetham", dob, "HN-3", nil, nil, "", "", "F", "Hospital A", time.Now(), time.Now()).
+			AddRow(1, "ก", nil, "ข", "Somchai", nil, "Meesuk", dob, "HN-1", nil, nil, "", "", "M", "Hospital A", time.Now(), time.Now()))
+
+	req := createAuthenticatedRequest("GET", "/patient/search?last_name=Mee&limit=1&sort=-date_of_birth&include_total=true", staff)
+	rr := httptest.NewRecorder()
+	handlers.SearchPatient(db, registry)(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	var response struct {
+		Data []models.Patient `json:"data"`
+		Meta models.PageMeta  `json:"meta"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+
+	if len(response.Data) != 1 || response.Data[0].ID != 3 {
+		t.Errorf("expected only patient 3 on the first page, got %+v", response.Data)
+	}
+	if !response.Meta.HasMore || response.Meta.NextCursor == "" {
+		t.Errorf("expected a next cursor, got %+v", response.Meta)
+	}
+	if response.Meta.Total == nil || *response.Meta.Total != 3 {
+		t.Errorf("expected total 3, got %v", response.Meta.Total)
+	}
+
+	firstCursor := response.Meta.NextCursor
+
+	// Second page continues after the last row of the first
+	mock.ExpectQuery("SELECT .+ FROM patient WHERE hospital = \\$1 AND deleted_at IS NULL AND .+ AND \\(COALESCE\\(date_of_birth, DATE '0001-01-01'\\), id\\) < \\(\\$4::date, \\$5\\) ORDER BY").
+		WithArgs("Hospital A", "%Mee%", "%Mee%", "1980-08-20", 3).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(1, "ก", nil, "ข", "Somchai", nil, "Meesuk", dob, "HN-1", nil, nil, "", "", "M", "Hospital A", time.Now(), time.Now()))
+
+	req = createAuthenticatedRequest("GET", "/patient/search?last_name=Mee&limit=1&sort=-date_of_birth&cursor="+response.Meta.NextCursor, staff)
+	rr = httptest.NewRecorder()
+	handlers.SearchPatient(db, registry)(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	response.Meta = models.PageMeta{}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if len(response.Data) != 1 || response.Data[0].ID != 1 || response.Meta.HasMore {
+		t.Errorf("expected the last page with patient 1, got %+v %+v", response.Data, response.Meta)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+
+	// A cursor cannot be reused with another sort
+	req = createAuthenticatedRequest("GET", "/patient/search?last_name=Mee&sort=name&cursor="+firstCursor, staff)
+	rr = httptest.NewRecorder()
+	handlers.SearchPatient(db, registry)(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for a mismatched cursor, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestSearchPatientPaginatesHospitalResults(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open sqlmock: %s", err)
+	}
+	defer db.Close()
+
+	staff := &models.Staff{Hospital: "Hospital A", Username: "staff1", ID: 1}
+	upstream := []models.Patient{
+		{PatientHN: "HN-2", FirstNameEN: "Somjai", LastNameEN: "Meetham"},
+		{PatientHN: "HN-3", FirstNameEN: "Anong", LastNameEN: "Boonmee"},
+		{PatientHN: "HN-1", FirstNameEN: "Somchai", LastNameEN: "Meesuk"},
+	}
+	client := &stubHospitalClient{patients: upstream}
+	registry := newStubRegistry("Hospital A", client)
+
+	var response struct {
+		Data []models.Patient `json:"data"`
+		Meta models.PageMeta  `json:"meta"`
+	}
+	search := func(url string) int {
+		rr := httptest.NewRecorder()
+		handlers.SearchPatient(db, registry)(rr, createAuthenticatedRequest("GET", url, staff))
+		response.Data, response.Meta = nil, models.PageMeta{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr.Code
+	}
+
+	if code := search("/patient/search?last_name=Mee&limit=2"); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if len(response.Data) != 2 || response.Data[0].PatientHN != "HN-3" || response.Data[1].PatientHN != "HN-1" {
+		t.Errorf("expected HN-3 and HN-1 sorted by name, got %+v", response.Data)
+	}
+	if !response.Meta.HasMore || response.Meta.NextCursor == "" || response.Meta.Total == nil || *response.Meta.Total != 3 {
+		t.Errorf("expected a next cursor and total 3, got %+v", response.Meta)
+	}
+
+	if code := search("/patient/search?last_name=Mee&limit=2&cursor=" + response.Meta.NextCursor); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if len(response.Data) != 1 || response.Data[0].PatientHN != "HN-2" || response.Meta.HasMore {
+		t.Errorf("expected the last page with HN-2, got %+v %+v", response.Data, response.Meta)
+	}
+	if upstream[0].PatientHN != "HN-2" {
+		t.Errorf("expected the hospital's results to keep their order, got %+v", upstream)
+	}
+
+	// A hospital cursor is not continued from the local database
+	if code := search("/patient/search?last_name=Mee&limit=2"); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	client.err = errors.New("upstream unavailable")
+	if code := search("/patient/search?last_name=Mee&limit=2&cursor=" + response.Meta.NextCursor); code != http.StatusBadGateway {
+		t.Errorf("Expected status %d, got %d", http.StatusBadGateway, code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSearchPatientDisclosesByRole(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open sqlmock: %s", err)
+	}
+	defer db.Close()
+
+	client := &stubHospitalClient{patients: []models.Patient{{PatientHN: "HN-00123", NationalID: "1101500234564", PhoneNumber: "0812345678", Email: "jai@gmail.com"}}}
+	registry := newStubRegistry("Hospital A", client)
+
+	search := func(req *http.Request) models.Patient {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		handlers.SearchPatient(db, registry)(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+		var response struct {
+			Data []models.Patient `json:"data"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		if len(response.Data) != 1 {
+			t.Fatalf("expected one patient, got %+v", response.Data)
+		}
+		return response.Data[0]
+	}
+
+	doctor := search(createAuthenticatedRequest("GET", "/patient/search?national_id=1101500234564", &models.Staff{ID: 1, Hospital: "Hospital A", Role: services.RoleDoctor}))
+	if doctor.NationalID != "1101500234564" || doctor.Email != "jai@gmail.com" {
+		t.Errorf("expected doctors to see every field, got %+v", doctor)
+	}
+
+	clerk := search(createAuthenticatedRequest("GET", "/patient/search?national_id=1101500234564", &models.Staff{ID: 2, Hospital: "Hospital A", Role: services.RoleRegistrationClerk}))
+	if clerk.NationalID != "*********4564" || clerk.PhoneNumber != "0812345678" {
+		t.Errorf("expected clerks to see the last 4 digits of the national ID, got %+v", clerk)
+	}
+	if client.patients[0].NationalID != "1101500234564" {
+		t.Errorf("masking changed the hospital's result: %+v", client.patients[0])
+	}
+
+	req := createAuthenticatedRequest("GET", "/patient/search?national_id=1101500234564", &models.Staff{Username: "api-key:kiosk", Hospital: "Hospital A"})
+	req = req.WithContext(context.WithValue(req.Context(), middleware.APIKeyKey, &models.APIKey{Name: "kiosk", Hospital: "Hospital A"}))
+	kiosk := search(req)
+	if kiosk.PhoneNumber != "******5678" || kiosk.Email != "***@gmail.com" {
+		t.Errorf("expected API keys to see masked contact details, got %+v", kiosk)
+	}
+
+	// Local records get the same policy, here when the hospital system is down
+	registry = newStubRegistry("Hospital A", &stubHospitalClient{err: errors.New("unavailable")})
+	t.Setenv("HOSPITAL_A_PII_DISCLOSURE", "registration_clerk.phone_number=omit")
+	policy, err := services.NewDisclosurePolicy(config.GetDisclosureConfig())
+	if err != nil {
+		t.Fatalf("failed to load disclosure policy: %s", err)
+	}
+	services.SetDisclosurePolicy(policy)
+	defer services.SetDisclosurePolicy(nil)
+
+	mock.ExpectQuery("SELECT .+ FROM patient WHERE hospital = \\$1 AND deleted_at IS NULL").
+		WillReturnRows(patientRecordRow(5, time.Now()))
+	local := search(createAuthenticatedRequest("GET", "/patient/search?last_name=Meesuk", &models.Staff{ID: 2, Hospital: "Hospital A", Role: services.RoleRegistrationClerk}))
+	if local.NationalID != "*********4564" || local.PhoneNumber != "" {
+		t.Errorf("expected the hospital's policy for local records, got %+v", local)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
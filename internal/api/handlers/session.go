@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/roasted99/hospital-middleware/internal/api/middleware"
+	"github.com/roasted99/hospital-middleware/internal/config"
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/roasted99/hospital-middleware/internal/utils"
+)
+
+// sessionStore is satisfied by both *sql.DB and *sql.Tx
+type sessionStore interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// issueSession signs an access token for staff and stores a new refresh token
+// in familyID, starting a new family when it is empty
+func issueSession(ctx context.Context, store sessionStore, staff models.Staff, familyID string) (models.AuthResponse, int, error) {
+	if familyID == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return models.AuthResponse{}, 0, err
+		}
+		familyID = hex.EncodeToString(b)
+	}
+
+	refreshToken, refreshHash, err := services.GenerateOpaqueToken()
+	if err != nil {
+		return models.AuthResponse{}, 0, err
+	}
+
+	var refreshID int
+	err = store.QueryRowContext(ctx, "INSERT INTO refresh_tokens (token_hash, family_id, staff_id, expires_at) VALUES ($1, $2, $3, $4) RETURNING id",
+		refreshHash, familyID, staff.ID, time.Now().Add(config.GetRefreshTokenTTL())).Scan(&refreshID)
+	if err != nil {
+		return models.AuthResponse{}, 0, err
+	}
+
+	token, err := services.GenerateJWT(staff)
+	if err != nil {
+		return models.AuthResponse{}, 0, err
+	}
+
+	return models.AuthResponse{
+		Token:                  token,
+		ExpiresIn:              int(config.GetAccessTokenTTL().Seconds()),
+		RefreshToken:           refreshToken,
+		StaffID:                staff.ID,
+		Username:               staff.Username,
+		Hospital:               staff.Hospital,
+		Role:                   staff.Role,
+		PasswordChangeRequired: staff.PasswordChangeRequired,
+	}, refreshID, nil
+}
+
+// RefreshToken exchanges a refresh token for a new access token and a new
+// refresh token. Each refresh token can be used once; presenting one that was
+// already rotated revokes every token descended from the same login.
+func RefreshToken(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request models.TokenRefreshRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			utils.ResponseWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		if request.RefreshToken == "" {
+			utils.ResponseWithError(w, http.StatusBadRequest, "Refresh token is required")
+			return
+		}
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		defer tx.Rollback()
+
+		var refreshID int
+		var familyID string
+		var expiresAt time.Time
+		var revokedAt sql.NullTime
+		var staff models.Staff
+		err = tx.QueryRowContext(r.Context(), "SELECT id, family_id, staff_id, expires_at, revoked_at FROM refresh_tokens WHERE token_hash = $1 FOR UPDATE",
+			services.HashOpaqueToken(request.RefreshToken)).Scan(&refreshID, &familyID, &staff.ID, &expiresAt, &revokedAt)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				utils.ResponseWithError(w, http.StatusUnauthorized, "Invalid refresh token")
+			} else {
+				utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			}
+			return
+		}
+
+		if revokedAt.Valid {
+			// The token was stolen or replayed; neither holder may keep the session
+			if _, err := tx.ExecContext(r.Context(), "UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL", familyID); err != nil {
+				utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+				return
+			}
+			if err := tx.Commit(); err != nil {
+				utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+				return
+			}
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Invalid refresh token")
+			return
+		}
+
+		if !time.Now().Before(expiresAt) {
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Refresh token expired")
+			return
+		}
+
+		// Reload the staff member so role changes apply from the next refresh
+		err = tx.QueryRowContext(r.Context(), "SELECT id, username, hospital, cross_hospital, role, password_change_required, mfa_enabled FROM staff WHERE id = $1", staff.ID).
+			Scan(&staff.ID, &staff.Username, &staff.Hospital, &staff.CrossHospital, &staff.Role, &staff.PasswordChangeRequired, &staff.MFAEnabled)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				utils.ResponseWithError(w, http.StatusUnauthorized, "Invalid refresh token")
+			} else {
+				utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			}
+			return
+		}
+
+		// Sessions started before the hospital required MFA end here; signing in
+		// again leads through enrollment
+		if config.HospitalRequiresMFA(staff.Hospital) && !staff.MFAEnabled {
+			utils.ResponseWithError(w, http.StatusUnauthorized, "MFA enrollment required, sign in again")
+			return
+		}
+
+		response, newRefreshID, err := issueSession(r.Context(), tx, staff, familyID)
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Failed to generate token")
+			return
+		}
+
+		if _, err := tx.ExecContext(r.Context(), "UPDATE refresh_tokens SET revoked_at = NOW(), replaced_by = $1 WHERE id = $2", newRefreshID, refreshID); err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+
+		utils.ResponseWithSuccess(w, http.StatusOK, response)
+	}
+}
+
+// Logout revokes the access token of the request and, when given, the family
+// of the refresh token issued with it
+func Logout(db *sql.DB, revocations services.TokenRevocationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := r.Context().Value(middleware.ClaimsKey).(*services.JWTClaims)
+		if !ok || claims == nil {
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		// The body is optional; a client that lost its refresh token can still log out
+		var request models.TokenRefreshRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				utils.ResponseWithError(w, http.StatusBadRequest, "Invalid request payload")
+				return
+			}
+		}
+
+		if err := revocations.RevokeToken(r.Context(), claims.ID, claims.ExpiresAt.Time); err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+
+		if request.RefreshToken != "" {
+			_, err := db.ExecContext(r.Context(), "UPDATE refresh_tokens SET revoked_at = NOW() WHERE revoked_at IS NULL AND family_id = (SELECT family_id FROM refresh_tokens WHERE token_hash = $1 AND staff_id = $2)",
+				services.HashOpaqueToken(request.RefreshToken), claims.StaffID)
+			if err != nil {
+				utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+				return
+			}
+		}
+
+		utils.ResponseWithJSON(w, http.StatusOK, "Logged out", nil)
+	}
+}
+
+// RevokeStaffSessions signs a staff member of the caller's hospital out
+// everywhere: current access tokens stop working and refresh tokens are revoked
+func RevokeStaffSessions(db *sql.DB, revocations services.TokenRevocationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		staff, ok := staffFromContext(r)
+		if !ok {
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		staffID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil || staffID <= 0 {
+			utils.ResponseWithError(w, http.StatusBadRequest, "Invalid staff ID")
+			return
+		}
+
+		var exists bool
+		if err := db.QueryRowContext(r.Context(), "SELECT EXISTS (SELECT 1 FROM staff WHERE id = $1 AND hospital = $2)", staffID, staff.Hospital).Scan(&exists); err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if !exists {
+			utils.ResponseWithError(w, http.StatusNotFound, "Staff not found")
+			return
+		}
+
+		if err := revokeSessions(r.Context(), db, revocations, staffID); err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+
+		utils.ResponseWithJSON(w, http.StatusOK, "Sessions revoked", nil)
+	}
+}
+
+// revokeSessions revokes every refresh token and issued access token of a
+// staff member, so changes to what their tokens carry apply at the next sign-in
+func revokeSessions(ctx context.Context, db *sql.DB, revocations services.TokenRevocationStore, staffID int) error {
+	if _, err := db.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = NOW() WHERE staff_id = $1 AND revoked_at IS NULL", staffID); err != nil {
+		return err
+	}
+	return revocations.RevokeStaff(ctx, staffID, time.Now())
+}
@@ -0,0 +1,220 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"github.com/roasted99/hospital-middleware/internal/api/handlers"
+	"github.com/roasted99/hospital-middleware/internal/api/middleware"
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var refreshTokenColumns = []string{"id", "family_id", "staff_id", "expires_at", "revoked_at"}
+
+func TestRefreshToken(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	t.Setenv("HOSPITAL_B_MFA_REQUIRED", "true")
+	tokenHash := services.HashOpaqueToken("refresh-token")
+
+	tests := []struct {
+		name           string
+		mockSetup      func()
+		expectedStatus int
+	}{
+		{
+			name: "Rotates a valid refresh token",
+			mockSetup: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT id, family_id, staff_id, expires_at, revoked_at FROM refresh_tokens WHERE token_hash = \\$1 FOR UPDATE").
+					WithArgs(tokenHash).
+					WillReturnRows(sqlmock.NewRows(refreshTokenColumns).AddRow(5, "family-1", 1, time.Now().Add(time.Hour), nil))
+				mock.ExpectQuery("SELECT id, username, hospital, cross_hospital, role, password_change_required, mfa_enabled FROM staff WHERE id = \\$1").
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "username", "hospital", "cross_hospital", "role", "password_change_required", "mfa_enabled"}).AddRow(1, "staff1", "Hospital A", false, "nurse", false, false))
+				mock.ExpectQuery("INSERT INTO refresh_tokens").
+					WithArgs(sqlmock.AnyArg(), "family-1", 1, sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(6))
+				mock.ExpectExec("UPDATE refresh_tokens SET revoked_at = NOW\\(\\), replaced_by = \\$1 WHERE id = \\$2").
+					WithArgs(6, 5).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "Reuse of a rotated token revokes the family",
+			mockSetup: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT id, family_id, staff_id, expires_at, revoked_at FROM refresh_tokens").
+					WithArgs(tokenHash).
+					WillReturnRows(sqlmock.NewRows(refreshTokenColumns).AddRow(5, "family-1", 1, time.Now().Add(time.Hour), time.Now().Add(-time.Minute)))
+				mock.ExpectExec("UPDATE refresh_tokens SET revoked_at = NOW\\(\\) WHERE family_id = \\$1 AND revoked_at IS NULL").
+					WithArgs("family-1").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "Rejects an expired token",
+			mockSetup: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT id, family_id, staff_id, expires_at, revoked_at FROM refresh_tokens").
+					WithArgs(tokenHash).
+					WillReturnRows(sqlmock.NewRows(refreshTokenColumns).AddRow(5, "family-1", 1, time.Now().Add(-time.Hour), nil))
+				mock.ExpectRollback()
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "Ends the session of unenrolled staff once their hospital requires MFA",
+			mockSetup: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT id, family_id, staff_id, expires_at, revoked_at FROM refresh_tokens").
+					WithArgs(tokenHash).
+					WillReturnRows(sqlmock.NewRows(refreshTokenColumns).AddRow(5, "family-1", 2, time.Now().Add(time.Hour), nil))
+				mock.ExpectQuery("SELECT id, username, hospital, cross_hospital, role, password_change_required, mfa_enabled FROM staff").
+					WithArgs(2).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "username", "hospital", "cross_hospital", "role", "password_change_required", "mfa_enabled"}).AddRow(2, "staff2", "Hospital B", false, "nurse", false, false))
+				mock.ExpectRollback()
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "Rejects an unknown token",
+			mockSetup: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT id, family_id, staff_id, expires_at, revoked_at FROM refresh_tokens").
+					WithArgs(tokenHash).
+					WillReturnRows(sqlmock.NewRows(refreshTokenColumns))
+				mock.ExpectRollback()
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mockSetup()
+
+			req := httptest.NewRequest(http.MethodPost, "/staff/token/refresh", bytes.NewBufferString(`{"refresh_token":"refresh-token"}`))
+			rr := httptest.NewRecorder()
+			handlers.RefreshToken(db)(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+
+			if tt.expectedStatus == http.StatusOK {
+				var response struct {
+					Data models.AuthResponse `json:"data"`
+				}
+				require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+				assert.NotEmpty(t, response.Data.Token)
+				assert.NotEmpty(t, response.Data.RefreshToken)
+				assert.NotEqual(t, "refresh-token", response.Data.RefreshToken)
+				assert.Equal(t, "nurse", response.Data.Role)
+			}
+		})
+	}
+}
+
+type recordingRevocationStore struct {
+	tokens []string
+	staff  []int
+}
+
+func (s *recordingRevocationStore) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	s.tokens = append(s.tokens, jti)
+	return nil
+}
+
+func (s *recordingRevocationStore) RevokeStaff(ctx context.Context, staffID int, at time.Time) error {
+	s.staff = append(s.staff, staffID)
+	return nil
+}
+
+func (s *recordingRevocationStore) IsRevoked(ctx context.Context, claims *services.JWTClaims) (bool, error) {
+	for _, staffID := range s.staff {
+		if staffID == claims.StaffID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *recordingRevocationStore) Prune(ctx context.Context, now time.Time) (int64, error) {
+	return 0, nil
+}
+
+func TestLogout(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := &recordingRevocationStore{}
+	claims := &services.JWTClaims{StaffID: 1, RegisteredClaims: jwt.RegisteredClaims{ID: "jti-1", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))}}
+
+	mock.ExpectExec("UPDATE refresh_tokens SET revoked_at = NOW\\(\\) WHERE revoked_at IS NULL AND family_id = \\(SELECT family_id FROM refresh_tokens WHERE token_hash = \\$1 AND staff_id = \\$2\\)").
+		WithArgs(services.HashOpaqueToken("refresh-token"), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	req := httptest.NewRequest(http.MethodPost, "/staff/logout", bytes.NewBufferString(`{"refresh_token":"refresh-token"}`))
+	req = req.WithContext(context.WithValue(req.Context(), middleware.ClaimsKey, claims))
+	rr := httptest.NewRecorder()
+	handlers.Logout(db, store)(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, []string{"jti-1"}, store.tokens)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRevokeStaffSessions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := &recordingRevocationStore{}
+	admin := &models.Staff{ID: 1, Hospital: "Hospital A", Role: "admin"}
+
+	mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM staff WHERE id = \\$1 AND hospital = \\$2\\)").
+		WithArgs(7, "Hospital A").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectExec("UPDATE refresh_tokens SET revoked_at = NOW\\(\\) WHERE staff_id = \\$1 AND revoked_at IS NULL").
+		WithArgs(7).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM staff WHERE id = \\$1 AND hospital = \\$2\\)").
+		WithArgs(8, "Hospital A").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	for _, tt := range []struct {
+		id             string
+		expectedStatus int
+	}{
+		{id: "7", expectedStatus: http.StatusOK},
+		{id: "8", expectedStatus: http.StatusNotFound},
+	} {
+		req := httptest.NewRequest(http.MethodDelete, "/admin/staff/"+tt.id+"/sessions", nil)
+		req = req.WithContext(context.WithValue(req.Context(), middleware.StaffKey, admin))
+		req = mux.SetURLVars(req, map[string]string{"id": tt.id})
+		rr := httptest.NewRecorder()
+		handlers.RevokeStaffSessions(db, store)(rr, req)
+		assert.Equal(t, tt.expectedStatus, rr.Code)
+	}
+
+	assert.Equal(t, []int{7}, store.staff)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -3,18 +3,29 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
-	"fmt"
+	"log"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/gorilla/mux"
+	"github.com/roasted99/hospital-middleware/internal/config"
+
 	"github.com/roasted99/hospital-middleware/internal/models"
 	"github.com/roasted99/hospital-middleware/internal/services"
 	"github.com/roasted99/hospital-middleware/internal/utils"
 )
 
-func CreateStaff(db *sql.DB) http.HandlerFunc {
+// LoginStaff signs a staff member in. Failed attempts are throttled per account
+// and per client address, and every attempt is recorded in login_attempts.
+// Unknown accounts and wrong passwords get the same response.
+func LoginStaff(db *sql.DB) http.HandlerFunc {
+	accountPolicy := services.NewLoginThrottlePolicy(config.GetAccountLoginThrottleConfig())
+	ipPolicy := services.NewLoginThrottlePolicy(config.GetIPLoginThrottleConfig())
+	trustProxy := config.GetTrustProxyHeaders()
+
 	return func(w http.ResponseWriter, r *http.Request) {
-		var request models.StaffCreateRequest
+		var request models.StaffLoginRequest
 		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 			utils.ResponseWithError(w, http.StatusBadRequest, "Invalid request payload")
 			return
@@ -25,82 +36,199 @@ func CreateStaff(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		hashedPassword, err := services.HashPassword(request.Password)
+		now := time.Now()
+		ip := utils.ClientIP(r, trustProxy)
+		accountKey := accountThrottleKey(request.Hospital, request.Username)
+
+		accountThrottle, err := loadLoginThrottle(r.Context(), db, accountKey)
 		if err != nil {
-			utils.ResponseWithError(w, http.StatusInternalServerError, "Error hashing password")
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
 			return
 		}
-
-		var staffID int
-		err = db.QueryRow("INSERT INTO staff (username, password, hospital, created_at, updated_at) VALUES ($1, $2, $3, $4, $5) RETURNING id",
-			request.Username, hashedPassword, request.Hospital, time.Now(), time.Now()).Scan(&staffID)
+		ipThrottle, err := loadLoginThrottle(r.Context(), db, ipThrottleKey(ip))
 		if err != nil {
-			if err == sql.ErrNoRows {
-				utils.ResponseWithError(w, http.StatusInternalServerError, "Failed to create staff")
-			} else {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+
+		wait := max(accountPolicy.RetryAfter(accountThrottle, now), ipPolicy.RetryAfter(ipThrottle, now))
+		if wait > 0 {
+			if err := recordLoginAttempt(r.Context(), db, request.Username, request.Hospital, 0, ip, loginThrottled); err != nil {
 				utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+				return
 			}
+			w.Header().Set("Retry-After", retryAfterSeconds(wait))
+			utils.ResponseWithError(w, http.StatusTooManyRequests, "Too many login attempts, try again later")
+			return
+		}
+
+		var staff models.Staff
+		err = db.QueryRow("SELECT id, username, password, hospital, cross_hospital, role, mfa_enabled, password_change_required FROM staff WHERE username = $1 AND hospital = $2", request.Username, request.Hospital).Scan(&staff.ID, &staff.Username, &staff.Password, &staff.Hospital, &staff.CrossHospital, &staff.Role, &staff.MFAEnabled, &staff.PasswordChangeRequired)
+		if err != nil && err != sql.ErrNoRows {
+			log.Printf("Error loading staff %s for login: %v", request.Username, err)
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+
+		if err == sql.ErrNoRows {
+			services.CheckPasswordHash(request.Password, dummyPasswordHash())
+		}
+		if err == sql.ErrNoRows || !services.CheckPasswordHash(request.Password, staff.Password) {
+			if err := recordLoginFailure(r.Context(), db, accountKey, accountPolicy, now); err != nil {
+				utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+				return
+			}
+			if err := recordLoginFailure(r.Context(), db, ipThrottleKey(ip), ipPolicy, now); err != nil {
+				utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+				return
+			}
+			if err := recordLoginAttempt(r.Context(), db, request.Username, request.Hospital, staff.ID, ip, loginInvalidCredentials); err != nil {
+				utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+				return
+			}
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Invalid credentials")
+			return
+		}
+
+		// With MFA the password only earns a challenge for the second step, and
+		// the account's failed attempts are kept until the code is accepted
+		if staff.MFAEnabled || config.HospitalRequiresMFA(staff.Hospital) {
+			mfaToken, err := createMFAChallenge(r.Context(), db, staff.ID)
+			if err != nil {
+				utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+				return
+			}
+			if err := recordLoginAttempt(r.Context(), db, request.Username, request.Hospital, staff.ID, ip, loginMFARequired); err != nil {
+				utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+				return
+			}
+			utils.ResponseWithSuccess(w, http.StatusOK, models.MFAChallengeResponse{
+				MFARequired:        true,
+				EnrollmentRequired: !staff.MFAEnabled,
+				MFAToken:           mfaToken,
+				ExpiresIn:          int(config.GetMFAChallengeTTL().Seconds()),
+			})
+			return
+		}
+
+		if _, err := db.ExecContext(r.Context(), "DELETE FROM login_throttles WHERE key = $1", accountKey); err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if err := recordLoginAttempt(r.Context(), db, request.Username, request.Hospital, staff.ID, ip, loginSucceeded); err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
 			return
 		}
 
-		token, err := services.GenerateJWT(staffID, request.Username, request.Hospital)
+		response, _, err := issueSession(r.Context(), db, staff, "")
 		if err != nil {
 			utils.ResponseWithError(w, http.StatusInternalServerError, "Failed to generate token")
 			return
 		}
-		
-		utils.ResponseWithSuccess(w, http.StatusCreated, models.AuthResponse{
-			Token:    token,
-			StaffID:  staffID,
-			Username: request.Username,
-			Hospital: request.Hospital,
-		})
 
+		utils.ResponseWithSuccess(w, http.StatusOK, response)
 	}
 }
 
-func LoginStaff(db *sql.DB) http.HandlerFunc {
+// AssignStaffRole changes the role of a staff member at the caller's hospital.
+// The role is carried in access tokens, so the staff member's sessions are
+// revoked and the new role applies from their next sign-in.
+func AssignStaffRole(db *sql.DB, revocations services.TokenRevocationStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var request models.StaffLoginRequest
+		staff, ok := staffFromContext(r)
+		if !ok {
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		staffID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil || staffID <= 0 {
+			utils.ResponseWithError(w, http.StatusBadRequest, "Invalid staff ID")
+			return
+		}
+
+		var request models.StaffRoleRequest
 		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 			utils.ResponseWithError(w, http.StatusBadRequest, "Invalid request payload")
 			return
 		}
 
-		if request.Username == "" || request.Password == "" || request.Hospital == "" {
-			utils.ResponseWithError(w, http.StatusBadRequest, "Username, password, and hospital are required")
+		if !services.IsValidRole(request.Role) {
+			utils.ResponseWithError(w, http.StatusBadRequest, "Unknown role "+request.Role)
 			return
 		}
 
-		var staff models.Staff
-		err := db.QueryRow("SELECT id, username, password, hospital FROM staff WHERE username = $1 AND hospital = $2", request.Username, request.Hospital).Scan(&staff.ID, &staff.Username, &staff.Password, &staff.Hospital)
+		// Admins cannot demote themselves and leave the hospital without one
+		if staffID == staff.ID && request.Role != staff.Role {
+			utils.ResponseWithError(w, http.StatusForbidden, "Cannot change your own role")
+			return
+		}
+
+		var updated models.Staff
+		err = db.QueryRowContext(r.Context(), "UPDATE staff SET role = $1, updated_at = NOW() WHERE id = $2 AND hospital = $3 RETURNING id, username, hospital, cross_hospital, role, created_at, updated_at",
+			request.Role, staffID, staff.Hospital).Scan(&updated.ID, &updated.Username, &updated.Hospital, &updated.CrossHospital, &updated.Role, &updated.CreatedAt, &updated.UpdatedAt)
 		if err != nil {
 			if err == sql.ErrNoRows {
-				utils.ResponseWithError(w, http.StatusUnauthorized, "Invalid credentials")
+				utils.ResponseWithError(w, http.StatusNotFound, "Staff not found")
 			} else {
-				fmt.Println(err)
 				utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
 			}
 			return
 		}
 
-		if !services.CheckPasswordHash(request.Password, staff.Password) {
-			utils.ResponseWithError(w, http.StatusUnauthorized, "Wrong password")
+		if err := revokeSessions(r.Context(), db, revocations, staffID); err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
 			return
 		}
 
-		token, err := services.GenerateJWT(staff.ID, staff.Username, request.Hospital)
+		utils.ResponseWithSuccess(w, http.StatusOK, updated)
+	}
+}
+
+// SetStaffCrossHospital grants or withdraws the privilege of a staff member at
+// the caller's hospital to search other hospitals. The flag is carried in
+// access tokens, so the staff member's sessions are revoked.
+func SetStaffCrossHospital(db *sql.DB, revocations services.TokenRevocationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		staff, ok := staffFromContext(r)
+		if !ok {
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		staffID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil || staffID <= 0 {
+			utils.ResponseWithError(w, http.StatusBadRequest, "Invalid staff ID")
+			return
+		}
+
+		var request models.StaffCrossHospitalRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.CrossHospital == nil {
+			utils.ResponseWithError(w, http.StatusBadRequest, "cross_hospital must be true or false")
+			return
+		}
+
+		audit := auditEntry(r)
+		audit.Criteria["staff_id"] = strconv.Itoa(staffID)
+		audit.Criteria["cross_hospital"] = strconv.FormatBool(*request.CrossHospital)
+
+		var updated models.Staff
+		err = db.QueryRowContext(r.Context(), "UPDATE staff SET cross_hospital = $1, updated_at = NOW() WHERE id = $2 AND hospital = $3 RETURNING id, username, hospital, cross_hospital, role, created_at, updated_at",
+			*request.CrossHospital, staffID, staff.Hospital).Scan(&updated.ID, &updated.Username, &updated.Hospital, &updated.CrossHospital, &updated.Role, &updated.CreatedAt, &updated.UpdatedAt)
 		if err != nil {
-			utils.ResponseWithError(w, http.StatusInternalServerError, "Failed to generate token")
+			if err == sql.ErrNoRows {
+				utils.ResponseWithError(w, http.StatusNotFound, "Staff not found")
+			} else {
+				utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			}
 			return
 		}
 
-		utils.ResponseWithSuccess(w, http.StatusOK, models.AuthResponse{
-			Token:    token,
-			StaffID:  staff.ID,
-			Username: staff.Username,
-			Hospital: staff.Hospital,
-		})
+		if err := revokeSessions(r.Context(), db, revocations, staffID); err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+
+		utils.ResponseWithSuccess(w, http.StatusOK, updated)
 	}
 }
-
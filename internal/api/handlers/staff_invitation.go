@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/roasted99/hospital-middleware/internal/config"
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/roasted99/hospital-middleware/internal/utils"
+)
+
+// CreateStaffInvitation issues a single-use invitation to join the caller's
+// hospital with the given role. The token is only returned once.
+func CreateStaffInvitation(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		staff, ok := staffFromContext(r)
+		if !ok {
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		var request models.StaffInvitationRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			utils.ResponseWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		if !services.IsValidRole(request.Role) {
+			utils.ResponseWithError(w, http.StatusBadRequest, "Unknown role "+request.Role)
+			return
+		}
+
+		token, tokenHash, err := services.GenerateOpaqueToken()
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Failed to generate invitation")
+			return
+		}
+
+		expiresAt := time.Now().Add(config.GetInvitationTTL())
+		_, err = db.ExecContext(r.Context(), "INSERT INTO staff_invitations (token_hash, hospital, role, created_by, expires_at) VALUES ($1, $2, $3, $4, $5)",
+			tokenHash, staff.Hospital, request.Role, staff.ID, expiresAt)
+		if err != nil {
+			log.Printf("Error storing staff invitation for %s: %v", staff.Hospital, err)
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+
+		utils.ResponseWithSuccess(w, http.StatusCreated, models.StaffInvitationResponse{
+			Token:     token,
+			Hospital:  staff.Hospital,
+			Role:      request.Role,
+			ExpiresAt: expiresAt,
+		})
+	}
+}
+
+// RedeemStaffInvitation creates the invited staff account with the invitation's
+// hospital and role and signs it in
+func RedeemStaffInvitation(db *sql.DB) http.HandlerFunc {
+	policy := services.NewPasswordPolicy(config.GetPasswordPolicyConfig())
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request models.StaffInvitationRedeemRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			utils.ResponseWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		if request.Token == "" || request.Username == "" || request.Password == "" {
+			utils.ResponseWithError(w, http.StatusBadRequest, "Token, username, and password are required")
+			return
+		}
+
+		if err := policy.Validate(request.Password, request.Username); err != nil {
+			utils.ResponseWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		hashedPassword, err := services.HashPassword(request.Password)
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Error hashing password")
+			return
+		}
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		defer tx.Rollback()
+
+		// Claiming the invitation in the same statement that checks it keeps
+		// concurrent redemptions from both succeeding
+		var invitationID int
+		staff := models.Staff{Username: request.Username}
+		err = tx.QueryRowContext(r.Context(), "UPDATE staff_invitations SET redeemed_at = NOW() WHERE token_hash = $1 AND redeemed_at IS NULL AND expires_at > NOW() RETURNING id, hospital, role",
+			services.HashOpaqueToken(request.Token)).Scan(&invitationID, &staff.Hospital, &staff.Role)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				utils.ResponseWithError(w, http.StatusBadRequest, "Invalid or expired invitation")
+			} else {
+				utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			}
+			return
+		}
+
+		err = tx.QueryRowContext(r.Context(), "INSERT INTO staff (username, password, hospital, role, created_at, updated_at) VALUES ($1, $2, $3, $4, NOW(), NOW()) RETURNING id",
+			staff.Username, hashedPassword, staff.Hospital, staff.Role).Scan(&staff.ID)
+		if err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+				utils.ResponseWithError(w, http.StatusConflict, "Username is already taken")
+			} else {
+				utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			}
+			return
+		}
+
+		if _, err := tx.ExecContext(r.Context(), "UPDATE staff_invitations SET redeemed_by = $1 WHERE id = $2", staff.ID, invitationID); err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+
+		// Hospitals that require MFA get the new account enrolled before any session
+		if config.HospitalRequiresMFA(staff.Hospital) {
+			mfaToken, err := createMFAChallenge(r.Context(), db, staff.ID)
+			if err != nil {
+				utils.ResponseWithError(w, http.StatusInternalServerError, "Database error")
+				return
+			}
+			utils.ResponseWithSuccess(w, http.StatusCreated, models.MFAChallengeResponse{
+				MFARequired:        true,
+				EnrollmentRequired: true,
+				MFAToken:           mfaToken,
+				ExpiresIn:          int(config.GetMFAChallengeTTL().Seconds()),
+			})
+			return
+		}
+
+		response, _, err := issueSession(r.Context(), db, staff, "")
+		if err != nil {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Failed to generate token")
+			return
+		}
+
+		utils.ResponseWithSuccess(w, http.StatusCreated, response)
+	}
+}
@@ -0,0 +1,163 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/roasted99/hospital-middleware/internal/api/handlers"
+	"github.com/roasted99/hospital-middleware/internal/api/middleware"
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateStaffInvitation(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	admin := &models.Staff{ID: 1, Username: "admin", Hospital: "Hospital A", Role: "admin"}
+
+	mock.ExpectExec("INSERT INTO staff_invitations").
+		WithArgs(sqlmock.AnyArg(), "Hospital A", "nurse", 1, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	req := httptest.NewRequest(http.MethodPost, "/staff/invitations", bytes.NewBufferString(`{"role":"nurse"}`))
+	req = req.WithContext(context.WithValue(req.Context(), middleware.StaffKey, admin))
+	rr := httptest.NewRecorder()
+	handlers.CreateStaffInvitation(db)(rr, req)
+
+	require.Equal(t, http.StatusCreated, rr.Code)
+	var response struct {
+		Data models.StaffInvitationResponse `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.NotEmpty(t, response.Data.Token)
+	assert.Equal(t, "Hospital A", response.Data.Hospital)
+	assert.Equal(t, "nurse", response.Data.Role)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/staff/invitations", bytes.NewBufferString(`{"role":"superuser"}`))
+	req = req.WithContext(context.WithValue(req.Context(), middleware.StaffKey, admin))
+	handlers.CreateStaffInvitation(db)(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestRedeemStaffInvitation(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	t.Setenv("HOSPITAL_B_MFA_REQUIRED", "true")
+	tokenHash := services.HashOpaqueToken("invite-token")
+
+	tests := []struct {
+		name           string
+		password       string
+		mockSetup      func()
+		expectedStatus int
+		expectedMFA    bool
+	}{
+		{
+			name:     "Redeems a valid invitation",
+			password: "Ward-7-Rounds!",
+			mockSetup: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery("UPDATE staff_invitations SET redeemed_at = NOW\\(\\) WHERE token_hash = \\$1 AND redeemed_at IS NULL AND expires_at > NOW\\(\\)").
+					WithArgs(tokenHash).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "hospital", "role"}).AddRow(3, "Hospital A", "nurse"))
+				mock.ExpectQuery("INSERT INTO staff").
+					WithArgs("newnurse", sqlmock.AnyArg(), "Hospital A", "nurse").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+				mock.ExpectExec("UPDATE staff_invitations SET redeemed_by = \\$1 WHERE id = \\$2").
+					WithArgs(9, 3).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+				mock.ExpectQuery("INSERT INTO refresh_tokens").
+					WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 9, sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+			},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:     "Enrolls MFA first when the hospital requires it",
+			password: "Ward-7-Rounds!",
+			mockSetup: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery("UPDATE staff_invitations SET redeemed_at").
+					WithArgs(tokenHash).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "hospital", "role"}).AddRow(4, "Hospital B", "nurse"))
+				mock.ExpectQuery("INSERT INTO staff").
+					WithArgs("newnurse", sqlmock.AnyArg(), "Hospital B", "nurse").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10))
+				mock.ExpectExec("UPDATE staff_invitations SET redeemed_by = \\$1 WHERE id = \\$2").
+					WithArgs(10, 4).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+				mock.ExpectExec("INSERT INTO mfa_challenges").
+					WithArgs(sqlmock.AnyArg(), 10, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+			expectedStatus: http.StatusCreated,
+			expectedMFA:    true,
+		},
+		{
+			name:     "Rejects a used or expired invitation",
+			password: "Ward-7-Rounds!",
+			mockSetup: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery("UPDATE staff_invitations SET redeemed_at").
+					WithArgs(tokenHash).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "hospital", "role"}))
+				mock.ExpectRollback()
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:     "Keeps the invitation when the username is taken",
+			password: "Ward-7-Rounds!",
+			mockSetup: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery("UPDATE staff_invitations SET redeemed_at").
+					WithArgs(tokenHash).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "hospital", "role"}).AddRow(3, "Hospital A", "nurse"))
+				mock.ExpectQuery("INSERT INTO staff").
+					WillReturnError(&pq.Error{Code: "23505"})
+				mock.ExpectRollback()
+			},
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "Rejects a password that breaks the policy",
+			password:       "password123",
+			mockSetup:      func() {},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mockSetup()
+
+			body := `{"token":"invite-token","username":"newnurse","password":"` + tt.password + `"}`
+			req := httptest.NewRequest(http.MethodPost, "/staff/invitations/redeem", bytes.NewBufferString(body))
+			rr := httptest.NewRecorder()
+			handlers.RedeemStaffInvitation(db)(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+			if tt.expectedMFA {
+				assert.Contains(t, rr.Body.String(), `"enrollment_required":true`)
+				assert.NotContains(t, rr.Body.String(), "refresh_token")
+			}
+		})
+	}
+}
@@ -2,87 +2,163 @@ package handlers_test
 
 import (
 	"bytes"
+	"context"
 	// "database/sql"
 	"encoding/json"
-	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
 	"github.com/roasted99/hospital-middleware/internal/api/handlers"
+	"github.com/roasted99/hospital-middleware/internal/api/middleware"
 	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func TestCreateStaff(t *testing.T) {
+var loginThrottleColumns = []string{"failed_count", "last_failed_at", "locked_until"}
+
+func expectLoginThrottles(mock sqlmock.Sqlmock, account *sqlmock.Rows) {
+	mock.ExpectQuery("SELECT failed_count, last_failed_at, locked_until FROM login_throttles WHERE key = \\$1").
+		WithArgs("account:Test Hospital:testuser").
+		WillReturnRows(account)
+	mock.ExpectQuery("SELECT failed_count, last_failed_at, locked_until FROM login_throttles WHERE key = \\$1").
+		WithArgs("ip:192.0.2.1").
+		WillReturnRows(sqlmock.NewRows(loginThrottleColumns))
+}
+
+// expectLoginFailure expects a failure counted against key, which had no earlier failures
+func expectLoginFailure(mock sqlmock.Sqlmock, key string) {
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO login_throttles \\(key\\) VALUES \\(\\$1\\) ON CONFLICT \\(key\\) DO NOTHING").
+		WithArgs(key).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT failed_count, last_failed_at, locked_until FROM login_throttles WHERE key = \\$1 FOR UPDATE").
+		WithArgs(key).
+		WillReturnRows(sqlmock.NewRows(loginThrottleColumns).AddRow(0, nil, nil))
+	mock.ExpectExec("UPDATE login_throttles SET failed_count = \\$2, last_failed_at = \\$3, locked_until = \\$4 WHERE key = \\$1").
+		WithArgs(key, 1, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+func TestLoginStaff(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	require.NoError(t, err)
 	defer db.Close()
 
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password123"), 10)
+
 	tests := []struct {
 		name           string
-		requestBody    models.StaffCreateRequest
+		requestBody    models.StaffLoginRequest
 		mockSetup      func()
 		expectedStatus int
 		expectedBody   map[string]interface{}
 	}{
 		{
-			name: "Successful staff creation",
-			requestBody: models.StaffCreateRequest{
+			name: "Successful staff login",
+			requestBody: models.StaffLoginRequest{
 				Username: "testuser",
 				Password: "password123",
 				Hospital: "Test Hospital",
 			},
 			mockSetup: func() {
-				mock.ExpectQuery("INSERT INTO staff").
-					WithArgs("testuser", sqlmock.AnyArg(), "Test Hospital", sqlmock.AnyArg(), sqlmock.AnyArg()).
+				expectLoginThrottles(mock, sqlmock.NewRows(loginThrottleColumns).AddRow(2, time.Now().Add(-time.Minute), nil))
+				mock.ExpectQuery("SELECT id, username, password, hospital, cross_hospital, role, mfa_enabled, password_change_required FROM staff WHERE username = \\$1 AND hospital = \\$2").
+					WithArgs("testuser", "Test Hospital").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "username", "password", "hospital", "cross_hospital", "role", "mfa_enabled", "password_change_required"}).AddRow(1, "testuser", string(hashedPassword), "Test Hospital", false, "doctor", false, false))
+				mock.ExpectExec("DELETE FROM login_throttles WHERE key = \\$1").
+					WithArgs("account:Test Hospital:testuser").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("INSERT INTO login_attempts").
+					WithArgs("testuser", "Test Hospital", sqlmock.AnyArg(), "192.0.2.1", true, "success").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectQuery("INSERT INTO refresh_tokens").
+					WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 1, sqlmock.AnyArg()).
 					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 			},
-			expectedStatus: http.StatusCreated,
+			expectedStatus: http.StatusOK,
 			expectedBody: map[string]interface{}{
 				"data": map[string]interface{}{
 					"token":    "valid_jwt_token",
-					"staff_id": 1,
+					"staff_id": float64(1),
 					"username": "testuser",
-					"hospital": "Test Hospital",
 				},
-				"status":  "Created",
+				"status":  "OK",
 				"message": "Success",
 			},
 		},
 		{
-			name: "Failed staff creation due to missing fields",
-			requestBody: models.StaffCreateRequest{
-				Username: "",
+			name: "Failed staff login due to invalid credentials",
+			requestBody: models.StaffLoginRequest{
+				Username: "testuser",
+				Password: "wrongpassword",
+				Hospital: "Test Hospital",
+			},
+			mockSetup: func() {
+				expectLoginThrottles(mock, sqlmock.NewRows(loginThrottleColumns))
+				mock.ExpectQuery("SELECT id, username, password, hospital, cross_hospital, role, mfa_enabled, password_change_required FROM staff WHERE username = \\$1 AND hospital = \\$2").
+					WithArgs("testuser", "Test Hospital").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "username", "password", "hospital", "cross_hospital", "role", "mfa_enabled", "password_change_required"}).AddRow(1, "testuser", "hashed_password", "Test Hospital", false, "doctor", false, false))
+				expectLoginFailure(mock, "account:Test Hospital:testuser")
+				expectLoginFailure(mock, "ip:192.0.2.1")
+				mock.ExpectExec("INSERT INTO login_attempts").
+					WithArgs("testuser", "Test Hospital", sqlmock.AnyArg(), "192.0.2.1", false, "invalid_credentials").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody: map[string]interface{}{
+				"status":  "Unauthorized",
+				"message": "Invalid credentials",
+			},
+		},
+		{
+			name: "Unknown account gets the same response",
+			requestBody: models.StaffLoginRequest{
+				Username: "testuser",
 				Password: "password123",
 				Hospital: "Test Hospital",
 			},
-			mockSetup:      func() {},
-			expectedStatus: http.StatusBadRequest,
+			mockSetup: func() {
+				expectLoginThrottles(mock, sqlmock.NewRows(loginThrottleColumns))
+				mock.ExpectQuery("SELECT id, username, password, hospital, cross_hospital, role, mfa_enabled, password_change_required FROM staff").
+					WithArgs("testuser", "Test Hospital").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "username", "password", "hospital", "cross_hospital", "role", "mfa_enabled", "password_change_required"}))
+				expectLoginFailure(mock, "account:Test Hospital:testuser")
+				expectLoginFailure(mock, "ip:192.0.2.1")
+				mock.ExpectExec("INSERT INTO login_attempts").
+					WithArgs("testuser", "Test Hospital", nil, "192.0.2.1", false, "invalid_credentials").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+			expectedStatus: http.StatusUnauthorized,
 			expectedBody: map[string]interface{}{
-				"status":  "Bad Request",
-				"message": "Username, password, and hospital are required",
+				"status":  "Unauthorized",
+				"message": "Invalid credentials",
 			},
 		},
 		{
-			name: "Failed staff creation due to database error",
-			requestBody: models.StaffCreateRequest{
+			name: "Locked account is rejected before the password is checked",
+			requestBody: models.StaffLoginRequest{
 				Username: "testuser",
 				Password: "password123",
 				Hospital: "Test Hospital",
 			},
 			mockSetup: func() {
-				mock.ExpectQuery("INSERT INTO staff").
-					WithArgs("testuser", sqlmock.AnyArg(), "Test Hospital", sqlmock.AnyArg(), sqlmock.AnyArg()).
-					WillReturnError(errors.New("database error"))
+				expectLoginThrottles(mock, sqlmock.NewRows(loginThrottleColumns).AddRow(5, time.Now(), time.Now().Add(10*time.Minute)))
+				mock.ExpectExec("INSERT INTO login_attempts").
+					WithArgs("testuser", "Test Hospital", nil, "192.0.2.1", false, "throttled").
+					WillReturnResult(sqlmock.NewResult(1, 1))
 			},
-			expectedStatus: http.StatusInternalServerError,
+			expectedStatus: http.StatusTooManyRequests,
 			expectedBody: map[string]interface{}{
-				"status":  "Internal Server Error",
-				"message": "Database error",
+				"status":  "Too Many Requests",
+				"message": "Too many login attempts, try again later",
 			},
 		},
 	}
@@ -97,7 +173,7 @@ func TestCreateStaff(t *testing.T) {
 			req := httptest.NewRequest(http.MethodPost, "/staff/login", bytes.NewBuffer(body))
 			w := httptest.NewRecorder()
 
-			handler := handlers.CreateStaff(db)
+			handler := handlers.LoginStaff(db)
 			handler(w, req)
 
 			res := w.Result()
@@ -107,9 +183,14 @@ func TestCreateStaff(t *testing.T) {
 			json.Unmarshal(w.Body.Bytes(), &response)
 
 			for key := range tt.expectedBody {
-				// assert.Equal(t, expectedValue, response[key])
 				assert.Contains(t, response, key)
 			}
+			if message, ok := tt.expectedBody["message"]; ok {
+				assert.Equal(t, message, response["message"])
+			}
+			if tt.expectedStatus == http.StatusTooManyRequests {
+				assert.NotEmpty(t, res.Header.Get("Retry-After"))
+			}
 
 			// Ensure all expectations were met
 			if err := mock.ExpectationsWereMet(); err != nil {
@@ -119,90 +200,123 @@ func TestCreateStaff(t *testing.T) {
 	}
 }
 
-func TestLoginStaff(t *testing.T) {
+func TestUnlockStaff(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	require.NoError(t, err)
 	defer db.Close()
 
-	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password123"), 10)
+	admin := &models.Staff{ID: 1, Hospital: "Test Hospital", Role: "admin"}
+	mock.ExpectQuery("SELECT username FROM staff WHERE id = \\$1 AND hospital = \\$2").
+		WithArgs(7, "Test Hospital").
+		WillReturnRows(sqlmock.NewRows([]string{"username"}).AddRow("TestUser"))
+	mock.ExpectExec("DELETE FROM login_throttles WHERE key = \\$1").
+		WithArgs("account:Test Hospital:testuser").
+		WillReturnResult(sqlmock.NewResult(0, 1))
 
-	tests := []struct {
-		name           string
-		requestBody    models.StaffLoginRequest
-		mockSetup      func()
-		expectedStatus int
-		expectedBody   map[string]interface{}
-	}{
-		{
-			name: "Successful staff login",
-			requestBody: models.StaffLoginRequest{
-				Username: "testuser",
-				Password: "password123",
-				Hospital: "Test Hospital",
-			},
-			mockSetup: func() {
-					mock.ExpectQuery("SELECT id, username, password, hospital FROM staff WHERE username = \\$1 AND hospital = \\$2").
-					WithArgs("testuser", "Test Hospital").
-					WillReturnRows(sqlmock.NewRows([]string{"id", "username", "password", "hospital"}).AddRow(1, "testuser", string(hashedPassword), "Test Hospital"))
-			},
-			expectedStatus: http.StatusOK,
-			expectedBody: map[string]interface{}{
-				"data": map[string]interface{}{
-					"token":    "valid_jwt_token",
-					"staff_id": float64(1),
-					"username": "testuser",
-				},
-				"status":  "OK",
-				"message": "Success",
-			},
-		},
-		{
-			name: "Failed staff login due to invalid credentials",
-			requestBody: models.StaffLoginRequest{
-				Username: "testuser",
-				Password: "wrongpassword",
-				Hospital: "Test Hospital",
-			},
-			mockSetup: func() {
-				mock.ExpectQuery("SELECT id, username, password, hospital FROM staff WHERE username = \\$1 AND hospital = \\$2").
-					WithArgs("testuser", "Test Hospital").
-					WillReturnRows(sqlmock.NewRows([]string{"id", "username", "password", "hospital"}).AddRow(1, "testuser", "hashed_password","Test Hospital"))
-			},
-			expectedStatus: http.StatusUnauthorized,
-			expectedBody: map[string]interface{}{
-				"status":  "Unauthorized",
-				"message": "Invalid credentials",
-			},
-		},
-	}
+	req := httptest.NewRequest(http.MethodDelete, "/admin/staff/7/lockout", nil)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.StaffKey, admin))
+	req = mux.SetURLVars(req, map[string]string{"id": "7"})
+	rr := httptest.NewRecorder()
+	handlers.UnlockStaff(db)(rr, req)
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			tt.mockSetup()
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
 
-			body, err := json.Marshal(tt.requestBody)
-			require.NoError(t, err)
+func staffRoleRequest(staff *models.Staff, id, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPut, "/staff/"+id+"/role", bytes.NewBufferString(body))
+	req = req.WithContext(context.WithValue(req.Context(), middleware.StaffKey, staff))
+	return mux.SetURLVars(req, map[string]string{"id": id})
+}
 
-			req := httptest.NewRequest(http.MethodPost, "/staff/login", bytes.NewBuffer(body))
-			w := httptest.NewRecorder()
+func TestAssignStaffRole(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
 
-			handler := handlers.LoginStaff(db)
-			handler(w, req)
+	admin := &models.Staff{ID: 1, Username: "admin", Hospital: "Hospital A", Role: "admin"}
 
-			res := w.Result()
-			assert.Equal(t, tt.expectedStatus, res.StatusCode)
+	store := &recordingRevocationStore{}
+	oldToken, err := services.GenerateJWT(models.Staff{ID: 7, Username: "staff7", Hospital: "Hospital A", Role: "doctor"})
+	require.NoError(t, err)
 
-			var response map[string]interface{}
-			json.Unmarshal(w.Body.Bytes(), &response)
+	mock.ExpectQuery("UPDATE staff SET role = \\$1, updated_at = NOW\\(\\) WHERE id = \\$2 AND hospital = \\$3").
+		WithArgs("nurse", 7, "Hospital A").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "hospital", "cross_hospital", "role", "created_at", "updated_at"}).
+			AddRow(7, "staff7", "Hospital A", false, "nurse", time.Now(), time.Now()))
+	mock.ExpectExec("UPDATE refresh_tokens SET revoked_at = NOW\\(\\) WHERE staff_id = \\$1 AND revoked_at IS NULL").
+		WithArgs(7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
 
-			for key := range tt.expectedBody {
-				assert.Contains(t, response, key)
-			}
+	rr := httptest.NewRecorder()
+	handlers.AssignStaffRole(db, store)(rr, staffRoleRequest(admin, "7", `{"role":"nurse"}`))
+	assert.Equal(t, http.StatusOK, rr.Code)
 
-			// Ensure all expectations were met
-			if err := mock.ExpectationsWereMet(); err != nil {
-				t.Errorf("Unfulfilled expectations: %s", err)
-			}
-		})
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, "nurse", response["data"].(map[string]interface{})["role"])
+
+	// The token issued with the old role is no longer accepted
+	req := httptest.NewRequest(http.MethodGet, "/patient/search", nil)
+	req.Header.Set("Authorization", "Bearer "+oldToken)
+	rr = httptest.NewRecorder()
+	middleware.Authenticate(store, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	rr = httptest.NewRecorder()
+	handlers.AssignStaffRole(db, store)(rr, staffRoleRequest(admin, "7", `{"role":"janitor"}`))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = httptest.NewRecorder()
+	handlers.AssignStaffRole(db, store)(rr, staffRoleRequest(admin, "1", `{"role":"doctor"}`))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSetStaffCrossHospital(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	admin := &models.Staff{ID: 1, Username: "admin", Hospital: "Hospital A", Role: "admin"}
+	store := &recordingRevocationStore{}
+	request := func(id, body string) *http.Request {
+		req := httptest.NewRequest(http.MethodPut, "/admin/staff/"+id+"/cross-hospital", bytes.NewBufferString(body))
+		req = req.WithContext(context.WithValue(req.Context(), middleware.StaffKey, admin))
+		return mux.SetURLVars(req, map[string]string{"id": id})
 	}
+
+	mock.ExpectQuery("UPDATE staff SET cross_hospital = \\$1, updated_at = NOW\\(\\) WHERE id = \\$2 AND hospital = \\$3").
+		WithArgs(true, 7, "Hospital A").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "hospital", "cross_hospital", "role", "created_at", "updated_at"}).
+			AddRow(7, "doctor7", "Hospital A", true, "doctor", time.Now(), time.Now()))
+	mock.ExpectExec("UPDATE refresh_tokens SET revoked_at = NOW\\(\\) WHERE staff_id = \\$1 AND revoked_at IS NULL").
+		WithArgs(7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	var recorded *models.AuditEntry
+	logger := auditLoggerFunc(func(entry *models.AuditEntry) { recorded = entry })
+	rr := httptest.NewRecorder()
+	middleware.Audit(handlers.SetStaffCrossHospital(db, store), logger, models.AuditActionStaffCrossHospital)(rr, request("7", `{"cross_hospital":true}`))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, []int{7}, store.staff)
+	require.NotNil(t, recorded)
+	assert.Equal(t, map[string]string{"staff_id": "7", "cross_hospital": "true"}, recorded.Criteria)
+
+	rr = httptest.NewRecorder()
+	handlers.SetStaffCrossHospital(db, store)(rr, request("7", `{}`))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	mock.ExpectQuery("UPDATE staff SET cross_hospital").
+		WithArgs(false, 8, "Hospital A").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "hospital", "cross_hospital", "role", "created_at", "updated_at"}))
+	rr = httptest.NewRecorder()
+	handlers.SetStaffCrossHospital(db, store)(rr, request("8", `{"cross_hospital":false}`))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
 }
+
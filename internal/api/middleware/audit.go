@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/roasted99/hospital-middleware/internal/config"
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/roasted99/hospital-middleware/internal/utils"
+)
+
+// AuditKey holds the *models.AuditEntry of the request; handlers fill in the
+// criteria, data source and patient IDs
+const AuditKey StaffContext = "audit"
+
+// Audit records every request to next in logger under action, including
+// requests RequirePermission turns away. It must run after Authenticate and
+// RequestID. The response is held back until the entry is written, so a read
+// that cannot be audited fails without disclosing anything. Changes are
+// already committed by then: when their entry cannot be written, the entry is
+// logged in full so it can be restored, and the client is told the change was
+// applied.
+func Audit(next http.HandlerFunc, logger services.AuditLogger, action string) http.HandlerFunc {
+	trustProxy := config.GetTrustProxyHeaders()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		entry := &models.AuditEntry{
+			OccurredAt: time.Now(),
+			RequestID:  RequestIDFromContext(r.Context()),
+			Action:     action,
+			Criteria:   map[string]string{},
+			ClientIP:   utils.ClientIP(r, trustProxy),
+		}
+		if staff, ok := r.Context().Value(StaffKey).(*models.Staff); ok && staff != nil {
+			entry.Username = staff.Username
+			entry.Hospital = staff.Hospital
+			entry.Role = staff.Role
+			if staff.ID != 0 {
+				staffID := staff.ID
+				entry.StaffID = &staffID
+			}
+			if staff.BreakGlassID != 0 {
+				breakGlassID := staff.BreakGlassID
+				entry.BreakGlassID = &breakGlassID
+			}
+		}
+		if apiKey, ok := r.Context().Value(APIKeyKey).(*models.APIKey); ok {
+			apiKeyID := apiKey.ID
+			entry.APIKeyID = &apiKeyID
+		}
+
+		response := &bufferedResponse{header: http.Header{}, status: http.StatusOK}
+		next(response, r.WithContext(context.WithValue(r.Context(), AuditKey, entry)))
+
+		entry.StatusCode = response.status
+		entry.Outcome = services.AuditOutcome(response.status)
+		if err := logger.Record(r.Context(), entry); err != nil {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead && entry.Outcome == models.AuditOutcomeSuccess {
+				unrecorded, _ := json.Marshal(entry)
+				log.Printf("Error recording audit entry of applied change for request %s: %v; entry: %s", entry.RequestID, err, unrecorded)
+				utils.ResponseWithError(w, http.StatusInternalServerError, "The change was applied but its audit entry could not be recorded")
+				return
+			}
+			log.Printf("Error recording audit entry for request %s: %v", entry.RequestID, err)
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Failed to record audit entry")
+			return
+		}
+
+		for name, values := range response.header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(response.status)
+		w.Write(response.body.Bytes())
+	}
+}
+
+// AuditEntryFromContext returns the entry Audit is recording for the request, if any
+func AuditEntryFromContext(ctx context.Context) *models.AuditEntry {
+	entry, _ := ctx.Value(AuditKey).(*models.AuditEntry)
+	return entry
+}
+
+// bufferedResponse holds a response until the audit entry is written
+type bufferedResponse struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if !b.wroteHeader {
+		b.status = status
+		b.wroteHeader = true
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	b.wroteHeader = true
+	return b.body.Write(p)
+}
@@ -0,0 +1,123 @@
+package middleware_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/roasted99/hospital-middleware/internal/api/middleware"
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryAuditLogger struct {
+	entries []*models.AuditEntry
+	err     error
+}
+
+func (l *memoryAuditLogger) Record(ctx context.Context, entry *models.AuditEntry) error {
+	if l.err != nil {
+		return l.err
+	}
+	l.entries = append(l.entries, entry)
+	return nil
+}
+
+func auditedRequest(staff *models.Staff) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/patient/7", nil)
+	req.Header.Set(middleware.RequestIDHeader, "req-1")
+	return req.WithContext(context.WithValue(req.Context(), middleware.StaffKey, staff))
+}
+
+func TestAuditRecordsHandlerDetails(t *testing.T) {
+	logger := &memoryAuditLogger{}
+	handler := middleware.RequestID(middleware.Audit(func(w http.ResponseWriter, r *http.Request) {
+		entry := middleware.AuditEntryFromContext(r.Context())
+		require.NotNil(t, entry)
+		entry.DataSource = "local"
+		entry.PatientIDs = []string{"7"}
+		w.WriteHeader(http.StatusOK)
+	}, logger, models.AuditActionPatientRead))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, auditedRequest(&models.Staff{ID: 3, Username: "nurse1", Hospital: "Hospital A", Role: services.RoleNurse}))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	require.Len(t, logger.entries, 1)
+	entry := logger.entries[0]
+	assert.Equal(t, "req-1", entry.RequestID)
+	assert.Equal(t, models.AuditActionPatientRead, entry.Action)
+	assert.Equal(t, 3, *entry.StaffID)
+	assert.Equal(t, services.RoleNurse, entry.Role)
+	assert.Equal(t, []string{"7"}, entry.PatientIDs)
+	assert.Equal(t, "192.0.2.1", entry.ClientIP)
+	assert.Equal(t, models.AuditOutcomeSuccess, entry.Outcome)
+}
+
+func TestAuditRecordsDeniedRequests(t *testing.T) {
+	logger := &memoryAuditLogger{}
+	handler := middleware.Audit(middleware.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("handler should not run")
+	}, services.PermPatientDelete), logger, models.AuditActionPatientDelete)
+
+	rr := httptest.NewRecorder()
+	handler(rr, auditedRequest(&models.Staff{ID: 3, Username: "nurse1", Hospital: "Hospital A", Role: services.RoleNurse}))
+
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	require.Len(t, logger.entries, 1)
+	assert.Equal(t, models.AuditOutcomeDenied, logger.entries[0].Outcome)
+	assert.Equal(t, http.StatusForbidden, logger.entries[0].StatusCode)
+}
+
+func TestAuditFailsClosed(t *testing.T) {
+	logger := &memoryAuditLogger{err: errors.New("database is down")}
+	handler := middleware.Audit(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":"patient details"}`))
+	}, logger, models.AuditActionPatientRead)
+
+	rr := httptest.NewRecorder()
+	handler(rr, auditedRequest(&models.Staff{ID: 3, Username: "nurse1", Hospital: "Hospital A", Role: services.RoleNurse}))
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "patient details")
+}
+
+func TestAuditReportsUnrecordedChange(t *testing.T) {
+	logger := &memoryAuditLogger{err: errors.New("database is down")}
+	handler := middleware.Audit(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}, logger, models.AuditActionPatientCreate)
+
+	req := auditedRequest(&models.Staff{ID: 3, Username: "nurse1", Hospital: "Hospital A", Role: services.RoleNurse})
+	req.Method = http.MethodPost
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.Contains(t, rr.Body.String(), "change was applied")
+}
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	handler := middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = middleware.RequestIDFromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(middleware.RequestIDHeader, "upstream-42")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, "upstream-42", seen)
+	assert.Equal(t, "upstream-42", rr.Header().Get(middleware.RequestIDHeader))
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(middleware.RequestIDHeader, "bad id\n")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Len(t, seen, 32, "malformed IDs are replaced")
+	assert.Equal(t, seen, rr.Header().Get(middleware.RequestIDHeader))
+}
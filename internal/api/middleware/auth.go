@@ -5,6 +5,7 @@ import (
 	"strings"
 	"context"
 
+	"github.com/roasted99/hospital-middleware/internal/models"
 	"github.com/roasted99/hospital-middleware/internal/services"
 	"github.com/roasted99/hospital-middleware/internal/utils"
 )
@@ -13,27 +14,86 @@ type StaffContext string
 
 const StaffKey StaffContext = "staff"
 
-func Authenticate(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			utils.ResponseWithError(w, http.StatusUnauthorized, "Missing authorization header")
-			return
-		}
+// ClaimsKey holds the *services.JWTClaims of the token that authenticated the request
+const ClaimsKey StaffContext = "claims"
 
-		token := strings.TrimPrefix(authHeader, "Bearer ")
-		if token == "" {
-			utils.ResponseWithError(w, http.StatusUnauthorized, "Invalid token format")
-			return
-		}
+// APIKeyKey holds the *models.APIKey that authenticated the request, if any
+const APIKeyKey StaffContext = "api_key"
+
+// APIKeyHeader carries the key of an integration client
+const APIKeyHeader = "X-API-Key"
+
+// Authenticate validates the bearer token and rejects tokens found in
+// revocations. When apiKeys is not nil, integration clients may authenticate
+// with an API key in APIKeyHeader instead.
+func Authenticate(revocations services.TokenRevocationStore, apiKeys services.APIKeyStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if key := r.Header.Get(APIKeyHeader); key != "" {
+				authenticateAPIKey(w, r, next, apiKeys, key)
+				return
+			}
+
+			authHeader := r.Header.Get("Authorization")
+			if authHeader == "" {
+				utils.ResponseWithError(w, http.StatusUnauthorized, "Missing authorization header")
+				return
+			}
+
+			token := strings.TrimPrefix(authHeader, "Bearer ")
+			if token == "" {
+				utils.ResponseWithError(w, http.StatusUnauthorized, "Invalid token format")
+				return
+			}
+
+			claims, err := services.ValidateToken(token)
+			if err != nil {
+				utils.ResponseWithError(w, http.StatusUnauthorized, "Invalid token")
+				return
+			}
+
+			revoked, err := revocations.IsRevoked(r.Context(), claims)
+			if err != nil {
+				utils.ResponseWithError(w, http.StatusInternalServerError, "Failed to check token")
+				return
+			}
+			if revoked {
+				utils.ResponseWithError(w, http.StatusUnauthorized, "Token has been revoked")
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), StaffKey, claims.Staff())
+			ctx = context.WithValue(ctx, ClaimsKey, claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// authenticateAPIKey serves the request as the hospital the key belongs to.
+// The key has no staff ID or role; RequirePermission checks its scopes instead.
+func authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, apiKeys services.APIKeyStore, key string) {
+	if apiKeys == nil {
+		utils.ResponseWithError(w, http.StatusUnauthorized, "API keys are not accepted for this endpoint")
+		return
+	}
 
-		staff, err := services.ValidateToken(token)
-		if err != nil {
-			utils.ResponseWithError(w, http.StatusUnauthorized, "Invalid token")
-			return
+	apiKey, err := apiKeys.AuthenticateAPIKey(r.Context(), key)
+	if err != nil {
+		if err == services.ErrInvalidAPIKey {
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Invalid API key")
+		} else {
+			utils.ResponseWithError(w, http.StatusInternalServerError, "Failed to check API key")
 		}
+		return
+	}
 
-		ctx := context.WithValue(r.Context(), StaffKey, staff)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
-}
\ No newline at end of file
+	// The federated search scope is the key's grant to search other hospitals
+	staff := &models.Staff{
+		Username:      "api-key:" + apiKey.Name,
+		Hospital:      apiKey.Hospital,
+		CrossHospital: services.APIKeyHasScope(apiKey, services.PermPatientSearchFederated),
+	}
+	ctx := context.WithValue(r.Context(), StaffKey, staff)
+	ctx = context.WithValue(ctx, APIKeyKey, apiKey)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
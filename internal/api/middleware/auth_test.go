@@ -0,0 +1,121 @@
+package middleware_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/roasted99/hospital-middleware/internal/api/middleware"
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryRevocationStore struct {
+	tokens map[string]bool
+	staff  map[int]time.Time
+}
+
+func (s *memoryRevocationStore) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	s.tokens[jti] = true
+	return nil
+}
+
+func (s *memoryRevocationStore) RevokeStaff(ctx context.Context, staffID int, at time.Time) error {
+	s.staff[staffID] = at
+	return nil
+}
+
+func (s *memoryRevocationStore) IsRevoked(ctx context.Context, claims *services.JWTClaims) (bool, error) {
+	at, ok := s.staff[claims.StaffID]
+	return s.tokens[claims.ID] || (ok && !claims.IssuedAt.Time.After(at)), nil
+}
+
+func (s *memoryRevocationStore) Prune(ctx context.Context, now time.Time) (int64, error) {
+	return 0, nil
+}
+
+func TestAuthenticateRejectsRevokedTokens(t *testing.T) {
+	store := &memoryRevocationStore{tokens: map[string]bool{}, staff: map[int]time.Time{}}
+	handler := middleware.Authenticate(store, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		staff := r.Context().Value(middleware.StaffKey).(*models.Staff)
+		assert.Equal(t, "nurse", staff.Role)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	request := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/patient/search", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	first, err := services.GenerateJWT(models.Staff{ID: 1, Username: "staff1", Hospital: "Hospital A", Role: "nurse"})
+	require.NoError(t, err)
+	second, err := services.GenerateJWT(models.Staff{ID: 1, Username: "staff1", Hospital: "Hospital A", Role: "nurse"})
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusNoContent, request(first))
+
+	claims, err := services.ValidateToken(first)
+	require.NoError(t, err)
+	require.NoError(t, store.RevokeToken(context.Background(), claims.ID, claims.ExpiresAt.Time))
+	assert.Equal(t, http.StatusUnauthorized, request(first))
+	assert.Equal(t, http.StatusNoContent, request(second))
+
+	require.NoError(t, store.RevokeStaff(context.Background(), 1, time.Now()))
+	assert.Equal(t, http.StatusUnauthorized, request(second))
+
+	assert.Equal(t, http.StatusUnauthorized, request("not-a-token"))
+}
+
+type memoryAPIKeyStore map[string]*models.APIKey
+
+func (s memoryAPIKeyStore) AuthenticateAPIKey(ctx context.Context, key string) (*models.APIKey, error) {
+	if apiKey, ok := s[key]; ok {
+		return apiKey, nil
+	}
+	return nil, services.ErrInvalidAPIKey
+}
+
+func TestAuthenticateAcceptsAPIKeys(t *testing.T) {
+	revocations := &memoryRevocationStore{tokens: map[string]bool{}, staff: map[int]time.Time{}}
+	apiKeys := memoryAPIKeyStore{"hmk_kiosk": {ID: 4, Name: "lobby kiosk", Hospital: "Hospital A", Scopes: []string{"patient:search"}}}
+
+	search := middleware.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		staff := r.Context().Value(middleware.StaffKey).(*models.Staff)
+		assert.Equal(t, "Hospital A", staff.Hospital)
+		w.WriteHeader(http.StatusNoContent)
+	}, services.PermPatientSearch)
+	write := middleware.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}, services.PermPatientWrite)
+
+	request := func(apiKeys services.APIKeyStore, handler http.HandlerFunc, key string) int {
+		req := httptest.NewRequest(http.MethodGet, "/patient/search", nil)
+		req.Header.Set(middleware.APIKeyHeader, key)
+		rr := httptest.NewRecorder()
+		middleware.Authenticate(revocations, apiKeys)(handler).ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	assert.Equal(t, http.StatusNoContent, request(apiKeys, search, "hmk_kiosk"))
+	assert.Equal(t, http.StatusForbidden, request(apiKeys, write, "hmk_kiosk"), "scopes limit the key")
+	assert.Equal(t, http.StatusUnauthorized, request(apiKeys, search, "hmk_unknown"))
+	assert.Equal(t, http.StatusUnauthorized, request(nil, search, "hmk_kiosk"), "routes without a key store refuse keys")
+
+	// Only the federated search scope lets a key search other hospitals
+	apiKeys["hmk_partner"] = &models.APIKey{ID: 5, Name: "partner", Hospital: "Hospital A", Scopes: []string{"patient:search", "patient:search:federated"}}
+	crossHospital := func(want bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, want, r.Context().Value(middleware.StaffKey).(*models.Staff).CrossHospital)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+	assert.Equal(t, http.StatusNoContent, request(apiKeys, middleware.RequirePermission(crossHospital(true), services.PermPatientSearchFederated), "hmk_partner"))
+	assert.Equal(t, http.StatusNoContent, request(apiKeys, crossHospital(false), "hmk_kiosk"))
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/roasted99/hospital-middleware/internal/utils"
+)
+
+// RequirePermission only lets staff whose role, or emergency access token,
+// grants every listed permission reach next; API keys need every permission
+// among their scopes. It must run after Authenticate.
+func RequirePermission(next http.HandlerFunc, permissions ...services.Permission) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		staff, ok := r.Context().Value(StaffKey).(*models.Staff)
+		if !ok || staff == nil {
+			utils.ResponseWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		if apiKey, ok := r.Context().Value(APIKeyKey).(*models.APIKey); ok {
+			for _, permission := range permissions {
+				if !services.APIKeyHasScope(apiKey, permission) {
+					utils.ResponseWithError(w, http.StatusForbidden, "Insufficient permissions")
+					return
+				}
+			}
+			next(w, r)
+			return
+		}
+
+		// Until a reset password is replaced, the token only opens routes
+		// without permission checks, such as the password change itself
+		if staff.PasswordChangeRequired {
+			utils.ResponseWithError(w, http.StatusForbidden, "Password change required")
+			return
+		}
+
+		for _, permission := range permissions {
+			if !services.StaffHasPermission(staff, permission) {
+				utils.ResponseWithError(w, http.StatusForbidden, "Insufficient permissions")
+				return
+			}
+		}
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,68 @@
+package middleware_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/roasted99/hospital-middleware/internal/api/middleware"
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequirePermission(t *testing.T) {
+	handler := middleware.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}, services.PermPatientDelete)
+
+	tests := []struct {
+		name           string
+		staff          *models.Staff
+		expectedStatus int
+	}{
+		{name: "admin may delete", staff: &models.Staff{Role: services.RoleAdmin}, expectedStatus: http.StatusNoContent},
+		{name: "nurse may not delete", staff: &models.Staff{Role: services.RoleNurse}, expectedStatus: http.StatusForbidden},
+		{name: "unknown role has no permissions", staff: &models.Staff{Role: "janitor"}, expectedStatus: http.StatusForbidden},
+		{name: "pending password change blocks every permission", staff: &models.Staff{Role: services.RoleAdmin, PasswordChangeRequired: true}, expectedStatus: http.StatusForbidden},
+		{name: "unauthenticated", expectedStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodDelete, "/patient/1", nil)
+			if tt.staff != nil {
+				req = req.WithContext(context.WithValue(req.Context(), middleware.StaffKey, tt.staff))
+			}
+			rr := httptest.NewRecorder()
+			handler(rr, req)
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+		})
+	}
+}
+
+func TestRequirePermissionWithBreakGlass(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+	staff := &models.Staff{Role: services.RoleRegistrationClerk, BreakGlassID: 42}
+
+	tests := []struct {
+		name           string
+		permission     services.Permission
+		expectedStatus int
+	}{
+		{name: "emergency access may read", permission: services.PermPatientRead, expectedStatus: http.StatusNoContent},
+		{name: "emergency access may search other hospitals", permission: services.PermPatientSearchFederated, expectedStatus: http.StatusNoContent},
+		{name: "emergency access may not delete", permission: services.PermPatientDelete, expectedStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/patient/1", nil)
+			req = req.WithContext(context.WithValue(req.Context(), middleware.StaffKey, staff))
+			rr := httptest.NewRecorder()
+			middleware.RequirePermission(handler, tt.permission)(rr, req)
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+		})
+	}
+}
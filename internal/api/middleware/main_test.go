@@ -0,0 +1,27 @@
+package middleware_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/roasted99/hospital-middleware/internal/services"
+)
+
+// TestMain installs a throwaway signing key so handlers can issue tokens
+func TestMain(m *testing.M) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	keyset, err := services.NewKeyset([]services.SigningKey{{KID: "test", Algorithm: services.AlgES256, PrivateKey: key}}, time.Now())
+	if err != nil {
+		panic(err)
+	}
+	services.SetKeyset(keyset)
+
+	os.Exit(m.Run())
+}
@@ -2,6 +2,9 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -23,14 +26,102 @@ func GetDBConfig() DBConfig {
 	}
 }
 
-// GetJWTSecret returns JWT secret from environment variables
-func GetJWTSecret() string {
-	return getEnv("JWT_SECRET", "6VIY496XKzMoZkj0dJWaMkrh0+oD1pbpIky7nu27QzFsLm0JQOcNllzKRXv8")
+// GetAccessTokenTTL returns the lifetime of access tokens (JWTs)
+func GetAccessTokenTTL() time.Duration {
+	return getEnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute)
 }
 
-// GetHospitalAURL returns Hospital A API URL
-func GetHospitalAURL() string {
-	return getEnv("HOSPITAL_A_URL", "https://hospital-a.api.co.th")
+// GetRefreshTokenTTL returns the lifetime of a refresh token; every refresh
+// issues a new one with a fresh lifetime
+func GetRefreshTokenTTL() time.Duration {
+	return getEnvDuration("REFRESH_TOKEN_TTL", 7*24*time.Hour)
+}
+
+// GetRevocationPruneInterval returns how often expired token revocations are deleted
+func GetRevocationPruneInterval() time.Duration {
+	return getEnvDuration("REVOCATION_PRUNE_INTERVAL", time.Hour)
+}
+
+// GetBreakGlassTTL returns how long an emergency access token is valid
+func GetBreakGlassTTL() time.Duration {
+	return getEnvDuration("BREAK_GLASS_TTL", 15*time.Minute)
+}
+
+// GetAuditCheckpointInterval returns how often the audit log is sealed with a signed checkpoint
+func GetAuditCheckpointInterval() time.Duration {
+	return getEnvDuration("AUDIT_CHECKPOINT_INTERVAL", time.Hour)
+}
+
+// GetInvitationTTL returns how long a staff invitation can be redeemed
+func GetInvitationTTL() time.Duration {
+	return getEnvDuration("INVITATION_TTL", 72*time.Hour)
+}
+
+// LoginThrottleConfig limits failed logins of an account or a client address
+type LoginThrottleConfig struct {
+	MaxFailures     int
+	LockoutDuration time.Duration
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+}
+
+// GetAccountLoginThrottleConfig returns the failed login limits of a single account
+func GetAccountLoginThrottleConfig() LoginThrottleConfig {
+	return LoginThrottleConfig{
+		MaxFailures:     getEnvInt("LOGIN_MAX_FAILED_ATTEMPTS", 5),
+		LockoutDuration: getEnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+		BaseDelay:       getEnvDuration("LOGIN_DELAY_BASE", time.Second),
+		MaxDelay:        getEnvDuration("LOGIN_DELAY_MAX", 30*time.Second),
+	}
+}
+
+// GetIPLoginThrottleConfig returns the failed login limits of a client address,
+// across all accounts it tries
+func GetIPLoginThrottleConfig() LoginThrottleConfig {
+	return LoginThrottleConfig{
+		MaxFailures:     getEnvInt("LOGIN_IP_MAX_FAILED_ATTEMPTS", 20),
+		LockoutDuration: getEnvDuration("LOGIN_IP_LOCKOUT_DURATION", 15*time.Minute),
+		BaseDelay:       getEnvDuration("LOGIN_IP_DELAY_BASE", 0),
+		MaxDelay:        getEnvDuration("LOGIN_IP_DELAY_MAX", 0),
+	}
+}
+
+// PasswordPolicyConfig sets the rules for staff passwords
+type PasswordPolicyConfig struct {
+	MinLength           int
+	MinCharacterClasses int
+	HistorySize         int
+}
+
+// GetPasswordPolicyConfig returns the staff password policy
+func GetPasswordPolicyConfig() PasswordPolicyConfig {
+	return PasswordPolicyConfig{
+		MinLength:           getEnvInt("PASSWORD_MIN_LENGTH", 12),
+		MinCharacterClasses: getEnvInt("PASSWORD_MIN_CHARACTER_CLASSES", 3),
+		HistorySize:         getEnvInt("PASSWORD_HISTORY_SIZE", 5),
+	}
+}
+
+// GetMFAIssuer returns the issuer shown by authenticator apps
+func GetMFAIssuer() string {
+	return getEnv("MFA_ISSUER", "Hospital Middleware")
+}
+
+// GetMFAChallengeTTL returns how long the second login step may take
+func GetMFAChallengeTTL() time.Duration {
+	return getEnvDuration("MFA_CHALLENGE_TTL", 5*time.Minute)
+}
+
+// HospitalRequiresMFA reports whether every staff member of hospital must use
+// MFA, set with e.g. HOSPITAL_A_MFA_REQUIRED=true
+func HospitalRequiresMFA(hospital string) bool {
+	return getHospitalEnv(hospital, "MFA_REQUIRED", "false") == "true"
+}
+
+// GetTrustProxyHeaders reports whether X-Real-IP from the reverse proxy
+// identifies the client; only enable it when clients cannot reach the server directly
+func GetTrustProxyHeaders() bool {
+	return getEnv("TRUST_PROXY_HEADERS", "false") == "true"
 }
 
 // DBConfig represents database configuration
@@ -43,6 +134,17 @@ type DBConfig struct {
 	SSLMode  string
 }
 
+// envName turns a free-form name into an environment variable name component
+func envName(name string) string {
+	prefix := strings.ToUpper(strings.TrimSpace(name))
+	return strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, prefix)
+}
+
 func getEnv(key, fallback string) string {
 	if value, exists := os.LookupEnv(key); exists {
 		return value
@@ -50,3 +152,21 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+func getEnvInt(key string, fallback int) int {
+	if value, exists := os.LookupEnv(key); exists {
+		if number, err := strconv.Atoi(value); err == nil {
+			return number
+		}
+	}
+	return fallback
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if value, exists := os.LookupEnv(key); exists {
+		if duration, err := time.ParseDuration(value); err == nil {
+			return duration
+		}
+	}
+	return fallback
+}
+
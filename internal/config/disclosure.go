@@ -0,0 +1,48 @@
+package config
+
+import (
+	"strings"
+)
+
+// DisclosureConfig overrides how much of each sensitive patient field a role
+// sees. Rules maps a role to the disclosure level of each overridden field.
+type DisclosureConfig struct {
+	Rules map[string]map[string]string
+	// Hospitals holds the rules of hospitals that set <PREFIX>_PII_DISCLOSURE,
+	// applied on top of Rules for their staff
+	Hospitals map[string]map[string]map[string]string
+}
+
+// GetDisclosureConfig reads PII_DISCLOSURE and <PREFIX>_PII_DISCLOSURE of every
+// hospital in HOSPITALS, e.g. "registration_clerk.email=omit,nurse.national_id=last4"
+func GetDisclosureConfig() DisclosureConfig {
+	cfg := DisclosureConfig{
+		Rules:     parseDisclosureRules(getEnv("PII_DISCLOSURE", "")),
+		Hospitals: make(map[string]map[string]map[string]string),
+	}
+	for _, name := range strings.Split(getEnv("HOSPITALS", "Hospital A"), ",") {
+		name = strings.TrimSpace(name)
+		if value := getHospitalEnv(name, "PII_DISCLOSURE", ""); name != "" && value != "" {
+			cfg.Hospitals[name] = parseDisclosureRules(value)
+		}
+	}
+	return cfg
+}
+
+// parseDisclosureRules reads "role.field=level,role.field=level". Entries
+// without a role and field are kept under an empty role so they fail validation.
+func parseDisclosureRules(value string) map[string]map[string]string {
+	rules := make(map[string]map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, level, _ := strings.Cut(pair, "=")
+		role, field, _ := strings.Cut(strings.TrimSpace(key), ".")
+		if rules[role] == nil {
+			rules[role] = make(map[string]string)
+		}
+		rules[role][strings.TrimSpace(field)] = strings.TrimSpace(level)
+	}
+	return rules
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"strings"
+	"time"
+)
+
+// HospitalConfig describes an upstream hospital system the middleware connects to
+type HospitalConfig struct {
+	Name    string
+	Adapter string
+	BaseURL string
+	Timeout time.Duration
+	Auth    UpstreamAuthConfig
+
+	// Retries of transient failures and the circuit breaker around them
+	RetryMaxAttempts        int
+	RetryBaseDelay          time.Duration
+	RetryMaxDelay           time.Duration
+	RetryBudget             time.Duration
+	BreakerFailureThreshold int
+	BreakerOpenTimeout      time.Duration
+
+	// Read-through cache of patient lookups; a zero CacheTTL disables it
+	CacheTTL         time.Duration
+	CacheNegativeTTL time.Duration
+	CacheMaxEntries  int
+}
+
+// defaultHospitalURLs are the base URLs used when a hospital's URL is not set
+var defaultHospitalURLs = map[string]string{
+	"Hospital A": "https://hospital-a.api.co.th",
+}
+
+// GetHospitalConfigs returns the hospitals listed in HOSPITALS.
+// Each hospital is configured through variables prefixed with its name,
+// e.g. "Hospital A" reads HOSPITAL_A_ADAPTER and HOSPITAL_A_URL
+// (HOSPITAL_A_BASE_URL is accepted as an alias).
+func GetHospitalConfigs() []HospitalConfig {
+	var hospitals []HospitalConfig
+	for _, name := range strings.Split(getEnv("HOSPITALS", "Hospital A"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		hospitals = append(hospitals, HospitalConfig{
+			Name:    name,
+			Adapter: getHospitalEnv(name, "ADAPTER", "hospital_a"),
+			BaseURL: getHospitalEnv(name, "URL", getHospitalEnv(name, "BASE_URL", defaultHospitalURLs[name])),
+			Timeout: getEnvDuration(HospitalEnvPrefix(name)+"_TIMEOUT", 10*time.Second),
+			Auth:    getUpstreamAuthConfig(name),
+
+			RetryMaxAttempts:        getEnvInt(HospitalEnvPrefix(name)+"_RETRY_MAX_ATTEMPTS", 3),
+			RetryBaseDelay:          getEnvDuration(HospitalEnvPrefix(name)+"_RETRY_BASE_DELAY", 100*time.Millisecond),
+			RetryMaxDelay:           getEnvDuration(HospitalEnvPrefix(name)+"_RETRY_MAX_DELAY", time.Second),
+			RetryBudget:             getEnvDuration(HospitalEnvPrefix(name)+"_RETRY_BUDGET", 10*time.Second),
+			BreakerFailureThreshold: getEnvInt(HospitalEnvPrefix(name)+"_BREAKER_FAILURE_THRESHOLD", 5),
+			BreakerOpenTimeout:      getEnvDuration(HospitalEnvPrefix(name)+"_BREAKER_OPEN_TIMEOUT", 30*time.Second),
+
+			CacheTTL:         getEnvDuration(HospitalEnvPrefix(name)+"_CACHE_TTL", 5*time.Minute),
+			CacheNegativeTTL: getEnvDuration(HospitalEnvPrefix(name)+"_CACHE_NEGATIVE_TTL", 30*time.Second),
+			CacheMaxEntries:  getEnvInt(HospitalEnvPrefix(name)+"_CACHE_MAX_ENTRIES", 1000),
+		})
+	}
+	return hospitals
+}
+
+// UpstreamAuthConfig holds the credentials the middleware presents to a hospital.
+// Type is one of none, api_key, oauth2 or mtls; client certificates are used
+// with any type when configured.
+type UpstreamAuthConfig struct {
+	Type string
+
+	APIKey       string
+	APIKeyHeader string
+
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+
+	CertFile string
+	KeyFile  string
+	CAFile   string
+}
+
+func getUpstreamAuthConfig(name string) UpstreamAuthConfig {
+	scopes := strings.Fields(strings.ReplaceAll(getHospitalEnv(name, "OAUTH_SCOPES", ""), ",", " "))
+
+	return UpstreamAuthConfig{
+		Type:         getHospitalEnv(name, "AUTH_TYPE", "none"),
+		APIKey:       getHospitalEnv(name, "API_KEY", ""),
+		APIKeyHeader: getHospitalEnv(name, "API_KEY_HEADER", "X-API-Key"),
+		TokenURL:     getHospitalEnv(name, "OAUTH_TOKEN_URL", ""),
+		ClientID:     getHospitalEnv(name, "OAUTH_CLIENT_ID", ""),
+		ClientSecret: getHospitalEnv(name, "OAUTH_CLIENT_SECRET", ""),
+		Scopes:       scopes,
+		CertFile:     getHospitalEnv(name, "TLS_CERT_FILE", ""),
+		KeyFile:      getHospitalEnv(name, "TLS_KEY_FILE", ""),
+		CAFile:       getHospitalEnv(name, "TLS_CA_FILE", ""),
+	}
+}
+
+// GetFederatedSearchTimeout returns the deadline for one source of a federated search.
+// An empty hospital returns the default used for the local database.
+func GetFederatedSearchTimeout(hospital string) time.Duration {
+	fallback := getEnvDuration("FEDERATED_SEARCH_TIMEOUT", 5*time.Second)
+	if hospital == "" {
+		return fallback
+	}
+	return getEnvDuration(HospitalEnvPrefix(hospital)+"_SEARCH_TIMEOUT", fallback)
+}
+
+// HospitalEnvPrefix returns the environment variable prefix for a hospital name
+func HospitalEnvPrefix(name string) string {
+	return envName(name)
+}
+
+func getHospitalEnv(name, key, fallback string) string {
+	return getEnv(HospitalEnvPrefix(name)+"_"+key, fallback)
+}
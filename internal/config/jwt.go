@@ -0,0 +1,69 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// JWTKeyConfig describes a private key used to sign access tokens. A key signs
+// new tokens from ActivateAt and is dropped at RetireAt; until then it keeps
+// verifying the tokens it signed and is published in the JWKS.
+type JWTKeyConfig struct {
+	KID        string
+	File       string
+	ActivateAt time.Time
+	RetireAt   time.Time
+}
+
+// GetJWTKeyConfigs returns the keys listed in JWT_KEYS. Each key is configured
+// through variables prefixed with its kid, e.g. "2026-10" reads
+// JWT_KEY_2026_10_FILE, JWT_KEY_2026_10_ACTIVATE_AT and JWT_KEY_2026_10_RETIRE_AT
+// (RFC 3339 times, both optional).
+func GetJWTKeyConfigs() ([]JWTKeyConfig, error) {
+	var keys []JWTKeyConfig
+	for _, kid := range strings.Split(getEnv("JWT_KEYS", ""), ",") {
+		kid = strings.TrimSpace(kid)
+		if kid == "" {
+			continue
+		}
+
+		prefix := "JWT_KEY_" + envName(kid) + "_"
+		key := JWTKeyConfig{KID: kid, File: getEnv(prefix+"FILE", "")}
+		if key.File == "" {
+			return nil, fmt.Errorf("%sFILE is required for JWT key %q", prefix, kid)
+		}
+
+		var err error
+		if key.ActivateAt, err = getEnvTime(prefix + "ACTIVATE_AT"); err != nil {
+			return nil, err
+		}
+		if key.RetireAt, err = getEnvTime(prefix + "RETIRE_AT"); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("JWT_KEYS is not set; at least one signing key is required")
+	}
+	return keys, nil
+}
+
+// GetJWTIssuer returns the iss claim of access tokens
+func GetJWTIssuer() string {
+	return getEnv("JWT_ISSUER", "hospital-middleware")
+}
+
+func getEnvTime(key string) (time.Time, error) {
+	value := getEnv(key, "")
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: %w", key, err)
+	}
+	return t, nil
+}
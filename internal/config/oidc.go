@@ -0,0 +1,68 @@
+package config
+
+import (
+	"strings"
+	"time"
+)
+
+// OIDCConfig describes the OpenID Connect identity provider staff of a
+// hospital sign in with. SSO is enabled for hospitals with an issuer.
+type OIDCConfig struct {
+	Hospital     string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	// UsernameClaim names the ID token claim used as the staff username
+	UsernameClaim string
+	// RoleClaim names a string or string array claim whose values are mapped
+	// to middleware roles with RoleMap; without it new staff get DefaultRole
+	RoleClaim   string
+	RoleMap     map[string]string
+	DefaultRole string
+}
+
+// GetOIDCConfigs returns the SSO settings of every hospital in HOSPITALS that
+// has <PREFIX>_OIDC_ISSUER set
+func GetOIDCConfigs() []OIDCConfig {
+	var configs []OIDCConfig
+	for _, name := range strings.Split(getEnv("HOSPITALS", "Hospital A"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || getHospitalEnv(name, "OIDC_ISSUER", "") == "" {
+			continue
+		}
+
+		configs = append(configs, OIDCConfig{
+			Hospital:      name,
+			Issuer:        strings.TrimSuffix(getHospitalEnv(name, "OIDC_ISSUER", ""), "/"),
+			ClientID:      getHospitalEnv(name, "OIDC_CLIENT_ID", ""),
+			ClientSecret:  getHospitalEnv(name, "OIDC_CLIENT_SECRET", ""),
+			RedirectURL:   getHospitalEnv(name, "OIDC_REDIRECT_URL", ""),
+			Scopes:        strings.Fields(strings.ReplaceAll(getHospitalEnv(name, "OIDC_SCOPES", "openid profile email"), ",", " ")),
+			UsernameClaim: getHospitalEnv(name, "OIDC_USERNAME_CLAIM", "preferred_username"),
+			RoleClaim:     getHospitalEnv(name, "OIDC_ROLE_CLAIM", ""),
+			RoleMap:       parseRoleMap(getHospitalEnv(name, "OIDC_ROLE_MAP", "")),
+			DefaultRole:   getHospitalEnv(name, "OIDC_DEFAULT_ROLE", ""),
+		})
+	}
+	return configs
+}
+
+// parseRoleMap reads "idp-group=role,other-group=role"
+func parseRoleMap(value string) map[string]string {
+	roles := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		idpValue, role, ok := strings.Cut(pair, "=")
+		if ok && strings.TrimSpace(idpValue) != "" {
+			roles[strings.TrimSpace(idpValue)] = strings.TrimSpace(role)
+		}
+	}
+	return roles
+}
+
+// GetOIDCLoginTTL returns how long a user has to finish signing in at the IdP
+func GetOIDCLoginTTL() time.Duration {
+	return getEnvDuration("OIDC_LOGIN_TTL", 10*time.Minute)
+}
@@ -0,0 +1 @@
+ALTER TABLE staff ADD COLUMN IF NOT EXISTS cross_hospital BOOLEAN NOT NULL DEFAULT FALSE;
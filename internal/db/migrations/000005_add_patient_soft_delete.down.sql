@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS patient_hospital_passport_id_key;
+DROP INDEX IF EXISTS patient_hospital_national_id_key;
+ALTER TABLE patient DROP COLUMN IF EXISTS deleted_at;
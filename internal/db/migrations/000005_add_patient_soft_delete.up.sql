@@ -0,0 +1,9 @@
+ALTER TABLE patient ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+
+CREATE UNIQUE INDEX IF NOT EXISTS patient_hospital_national_id_key
+    ON patient (hospital, national_id)
+    WHERE deleted_at IS NULL AND national_id IS NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS patient_hospital_passport_id_key
+    ON patient (hospital, passport_id)
+    WHERE deleted_at IS NULL AND passport_id IS NOT NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE staff DROP COLUMN IF EXISTS role;
+DROP TABLE IF EXISTS roles;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS roles (
+    name VARCHAR(50) PRIMARY KEY,
+    description VARCHAR(255) NOT NULL
+);
+
+INSERT INTO roles (name, description) VALUES
+('admin', 'Hospital administrator; manages staff and middleware settings'),
+('doctor', 'Physician with access to patient records'),
+('nurse', 'Nurse with access to patient records'),
+('registration_clerk', 'Front desk staff registering and looking up patients'),
+('auditor', 'Reviews access to patient data')
+ON CONFLICT (name) DO NOTHING;
+
+ALTER TABLE staff ADD COLUMN IF NOT EXISTS role VARCHAR(50) NOT NULL DEFAULT 'registration_clerk' REFERENCES roles (name);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS staff_invitations (
+    id SERIAL PRIMARY KEY,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    hospital VARCHAR(100) NOT NULL,
+    role VARCHAR(50) NOT NULL REFERENCES roles (name),
+    created_by INTEGER REFERENCES staff (id) ON DELETE SET NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    redeemed_at TIMESTAMP WITH TIME ZONE,
+    redeemed_by INTEGER REFERENCES staff (id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS login_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    username VARCHAR(100) NOT NULL,
+    hospital VARCHAR(100) NOT NULL,
+    staff_id INTEGER REFERENCES staff (id) ON DELETE SET NULL,
+    ip_address VARCHAR(45) NOT NULL,
+    succeeded BOOLEAN NOT NULL,
+    reason VARCHAR(50) NOT NULL,
+    attempted_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_attempts_account ON login_attempts (hospital, username, attempted_at);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_ip ON login_attempts (ip_address, attempted_at);
+
+-- Failure counters keyed by account ("account:<hospital>:<username>") or by
+-- client address ("ip:<address>"); accounts that do not exist are tracked too
+CREATE TABLE IF NOT EXISTS login_throttles (
+    key VARCHAR(255) PRIMARY KEY,
+    failed_count INTEGER NOT NULL DEFAULT 0,
+    last_failed_at TIMESTAMP WITH TIME ZONE,
+    locked_until TIMESTAMP WITH TIME ZONE
+);
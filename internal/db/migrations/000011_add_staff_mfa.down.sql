@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS mfa_challenges;
+DROP TABLE IF EXISTS staff_recovery_codes;
+ALTER TABLE staff DROP COLUMN IF EXISTS mfa_last_step;
+ALTER TABLE staff DROP COLUMN IF EXISTS mfa_enabled;
+ALTER TABLE staff DROP COLUMN IF EXISTS mfa_secret;
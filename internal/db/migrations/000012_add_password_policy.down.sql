@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS password_history;
+ALTER TABLE staff DROP COLUMN IF EXISTS password_changed_at;
+ALTER TABLE staff DROP COLUMN IF EXISTS password_change_required;
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS oidc_login_states;
+DROP INDEX IF EXISTS idx_staff_oidc_identity;
+ALTER TABLE staff DROP COLUMN IF EXISTS oidc_subject;
+ALTER TABLE staff DROP COLUMN IF EXISTS oidc_issuer;
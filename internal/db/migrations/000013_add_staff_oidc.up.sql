@@ -0,0 +1,16 @@
+-- Staff provisioned through single sign-on are identified by their IdP subject
+ALTER TABLE staff ADD COLUMN IF NOT EXISTS oidc_issuer VARCHAR(255);
+ALTER TABLE staff ADD COLUMN IF NOT EXISTS oidc_subject VARCHAR(255);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_staff_oidc_identity ON staff (oidc_issuer, oidc_subject) WHERE oidc_subject IS NOT NULL;
+
+-- Pending SSO logins between the redirect to the IdP and its callback
+CREATE TABLE IF NOT EXISTS oidc_login_states (
+    id SERIAL PRIMARY KEY,
+    state_hash CHAR(64) NOT NULL UNIQUE,
+    hospital VARCHAR(100) NOT NULL,
+    nonce VARCHAR(64) NOT NULL,
+    code_verifier VARCHAR(128) NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,24 @@
+-- One row per request to a patient endpoint. Rows are never updated, and staff
+-- are referenced without a foreign key so deleting staff keeps their history.
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    occurred_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    request_id VARCHAR(64) NOT NULL,
+    action VARCHAR(50) NOT NULL,
+    staff_id INTEGER,
+    api_key_id INTEGER,
+    username VARCHAR(100) NOT NULL,
+    hospital VARCHAR(100) NOT NULL,
+    role VARCHAR(50),
+    criteria JSONB NOT NULL DEFAULT '{}',
+    patient_ids TEXT[] NOT NULL DEFAULT '{}',
+    data_source VARCHAR(100),
+    client_ip VARCHAR(64) NOT NULL,
+    outcome VARCHAR(20) NOT NULL,
+    status_code INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_hospital ON audit_log (hospital, id DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_log_staff_id ON audit_log (staff_id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_request_id ON audit_log (request_id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_patient_ids ON audit_log USING GIN (patient_ids);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS audit_checkpoints;
+ALTER TABLE audit_log DROP COLUMN IF EXISTS entry_hash;
+ALTER TABLE audit_log DROP COLUMN IF EXISTS prev_hash;
@@ -0,0 +1,19 @@
+-- A patient's consent to let a requesting hospital look up their records held
+-- by other hospital systems for one purpose
+CREATE TABLE IF NOT EXISTS patient_consents (
+    id SERIAL PRIMARY KEY,
+    national_id VARCHAR(13),
+    passport_id VARCHAR(50),
+    requesting_hospital VARCHAR(100) NOT NULL,
+    purpose VARCHAR(30) NOT NULL,
+    granted_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    hospital VARCHAR(100) NOT NULL,
+    recorded_by INTEGER REFERENCES staff(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK (national_id IS NOT NULL OR passport_id IS NOT NULL)
+);
+
+CREATE INDEX IF NOT EXISTS idx_patient_consents_national_id ON patient_consents (national_id) WHERE national_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_patient_consents_passport_id ON patient_consents (passport_id) WHERE passport_id IS NOT NULL;
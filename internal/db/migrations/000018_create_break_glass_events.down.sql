@@ -0,0 +1,4 @@
+ALTER TABLE audit_log DROP COLUMN IF EXISTS break_glass_id;
+DROP TABLE IF EXISTS break_glass_events;
+UPDATE staff SET role = 'registration_clerk' WHERE role = 'privacy_officer';
+DELETE FROM roles WHERE name = 'privacy_officer';
@@ -0,0 +1,28 @@
+INSERT INTO roles (name, description) VALUES
+('privacy_officer', 'Reviews emergency access to patient records')
+ON CONFLICT (name) DO NOTHING;
+
+-- One row per emergency access; reviewed_at stays NULL until a privacy officer
+-- has looked at it
+CREATE TABLE IF NOT EXISTS break_glass_events (
+    id BIGSERIAL PRIMARY KEY,
+    staff_id INTEGER REFERENCES staff(id) ON DELETE SET NULL,
+    username VARCHAR(100) NOT NULL,
+    hospital VARCHAR(100) NOT NULL,
+    role VARCHAR(50) NOT NULL,
+    reason_code VARCHAR(30) NOT NULL,
+    justification TEXT NOT NULL,
+    client_ip VARCHAR(64) NOT NULL,
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    reviewed_at TIMESTAMP WITH TIME ZONE,
+    reviewed_by INTEGER REFERENCES staff(id) ON DELETE SET NULL,
+    review_decision VARCHAR(20),
+    review_notes TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_break_glass_events_unreviewed ON break_glass_events (hospital, started_at) WHERE reviewed_at IS NULL;
+
+-- Accesses made with an emergency token point to its event
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS break_glass_id BIGINT;
+CREATE INDEX IF NOT EXISTS idx_audit_log_break_glass_id ON audit_log (break_glass_id) WHERE break_glass_id IS NOT NULL;
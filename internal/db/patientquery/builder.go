@@ -0,0 +1,223 @@
+// Package patientquery builds SQL for searching the patient table.
+//
+// Tenant predicates are kept apart from search criteria: criteria are always
+// rendered as one parenthesized group that is ANDed with the tenant predicate,
+// so an OR inside the criteria can never widen a search to other hospitals.
+package patientquery
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/roasted99/hospital-middleware/internal/models"
+)
+
+// Expr is a boolean SQL expression using ? placeholders
+type Expr interface {
+	render(b *renderer) string
+	needsParens() bool
+}
+
+type cond struct {
+	sql  string
+	args []interface{}
+}
+
+type group struct {
+	op    string
+	exprs []Expr
+}
+
+// Cond is a single predicate such as "national_id = ?"
+func Cond(sql string, args ...interface{}) Expr {
+	return cond{sql: sql, args: args}
+}
+
+// And matches when every expression matches
+func And(exprs ...Expr) Expr {
+	return group{op: "AND", exprs: exprs}
+}
+
+// Or matches when any expression matches
+func Or(exprs ...Expr) Expr {
+	return group{op: "OR", exprs: exprs}
+}
+
+var booleanOperator = regexp.MustCompile(`(?i)\s(AND|OR)\s`)
+
+func (c cond) needsParens() bool {
+	return booleanOperator.MatchString(c.sql)
+}
+
+func (c cond) render(b *renderer) string {
+	var sql strings.Builder
+	next := 0
+	for _, r := range c.sql {
+		if r == '?' && next < len(c.args) {
+			sql.WriteString(b.bind(c.args[next]))
+			next++
+			continue
+		}
+		sql.WriteRune(r)
+	}
+	return sql.String()
+}
+
+func (g group) needsParens() bool {
+	if len(g.exprs) == 1 {
+		return g.exprs[0].needsParens()
+	}
+	return len(g.exprs) > 1
+}
+
+func (g group) render(b *renderer) string {
+	if len(g.exprs) == 1 {
+		return g.exprs[0].render(b)
+	}
+
+	parts := make([]string, 0, len(g.exprs))
+	for _, expr := range g.exprs {
+		parts = append(parts, renderOperand(expr, b))
+	}
+	return strings.Join(parts, " "+g.op+" ")
+}
+
+func renderOperand(expr Expr, b *renderer) string {
+	sql := expr.render(b)
+	if expr.needsParens() {
+		return "(" + sql + ")"
+	}
+	return sql
+}
+
+// renderer numbers placeholders in the order they are rendered
+type renderer struct {
+	args []interface{}
+}
+
+func (b *renderer) bind(arg interface{}) string {
+	b.args = append(b.args, arg)
+	return "$" + strconv.Itoa(len(b.args))
+}
+
+// Builder assembles a search over the patient table
+type Builder struct {
+	tenant   []Expr
+	criteria []Expr
+	keyset   Expr
+	orderBy  string
+	limit    int
+}
+
+// ForHospital scopes the search to one hospital's records that are not deleted
+func ForHospital(hospital string) *Builder {
+	return &Builder{tenant: []Expr{Cond("hospital = ?", hospital), Cond("deleted_at IS NULL")}}
+}
+
+// AcrossHospitals searches the records of every hospital. Only callers that
+// have checked the caller's cross-hospital privilege may use it.
+func AcrossHospitals() *Builder {
+	return &Builder{tenant: []Expr{Cond("deleted_at IS NULL")}}
+}
+
+// Where adds a criterion that must match
+func (b *Builder) Where(expr Expr) *Builder {
+	b.criteria = append(b.criteria, expr)
+	return b
+}
+
+// Match adds the criteria of a patient search request. Names match either
+// the English or the Thai spelling.
+func (b *Builder) Match(request models.PatientSearchRequest) *Builder {
+	if request.NationalID != "" {
+		b.Where(Cond("national_id = ?", request.NationalID))
+	}
+	if request.PassportID != "" {
+		b.Where(Cond("passport_id = ?", request.PassportID))
+	}
+	if request.PatientHN != "" {
+		b.Where(Cond("patient_hn = ?", request.PatientHN))
+	}
+	if request.FirstName != "" {
+		b.Where(Or(Cond("first_name_en ILIKE ?", contains(request.FirstName)), Cond("first_name_th ILIKE ?", contains(request.FirstName))))
+	}
+	if request.MiddleName != "" {
+		b.Where(Or(Cond("middle_name_en ILIKE ?", contains(request.MiddleName)), Cond("middle_name_th ILIKE ?", contains(request.MiddleName))))
+	}
+	if request.LastName != "" {
+		b.Where(Or(Cond("last_name_en ILIKE ?", contains(request.LastName)), Cond("last_name_th ILIKE ?", contains(request.LastName))))
+	}
+	if request.DateOfBirth != "" {
+		b.Where(Cond("date_of_birth::text LIKE ?", contains(request.DateOfBirth)))
+	}
+	if request.PhoneNumber != "" {
+		b.Where(Cond("phone_number ILIKE ?", contains(request.PhoneNumber)))
+	}
+	if request.Email != "" {
+		b.Where(Cond("email ILIKE ?", contains(request.Email)))
+	}
+	return b
+}
+
+// HasCriteria reports whether any criterion was added
+func (b *Builder) HasCriteria() bool {
+	return len(b.criteria) > 0
+}
+
+// After restricts a Select to rows following a keyset cursor
+func (b *Builder) After(keyset Expr) *Builder {
+	b.keyset = keyset
+	return b
+}
+
+// OrderBy sets the ORDER BY clause of a Select, e.g. "created_at DESC, id DESC"
+func (b *Builder) OrderBy(clause string) *Builder {
+	b.orderBy = clause
+	return b
+}
+
+// Limit caps the number of rows returned by a Select
+func (b *Builder) Limit(limit int) *Builder {
+	b.limit = limit
+	return b
+}
+
+// Select returns the query for the given columns and its arguments
+func (b *Builder) Select(columns string) (string, []interface{}) {
+	r := &renderer{}
+	sql := "SELECT " + columns + " FROM patient WHERE " + b.where(r, b.keyset)
+	if b.orderBy != "" {
+		sql += " ORDER BY " + b.orderBy
+	}
+	if b.limit > 0 {
+		sql += " LIMIT " + strconv.Itoa(b.limit)
+	}
+	return sql, r.args
+}
+
+// Count returns a query counting every match, ignoring keyset, order and limit
+func (b *Builder) Count() (string, []interface{}) {
+	r := &renderer{}
+	return "SELECT COUNT(*) FROM patient WHERE " + b.where(r, nil), r.args
+}
+
+func (b *Builder) where(r *renderer, keyset Expr) string {
+	predicates := make([]string, 0, len(b.tenant)+2)
+	for _, expr := range b.tenant {
+		predicates = append(predicates, renderOperand(expr, r))
+	}
+	if criteria := And(b.criteria...); len(b.criteria) == 1 && criteria.needsParens() {
+		predicates = append(predicates, renderOperand(criteria, r))
+	} else if len(b.criteria) > 0 {
+		predicates = append(predicates, "("+criteria.render(r)+")")
+	}
+	if keyset != nil {
+		predicates = append(predicates, renderOperand(keyset, r))
+	}
+	return strings.Join(predicates, " AND ")
+}
+
+func contains(value string) string {
+	return "%" + value + "%"
+}
@@ -0,0 +1,96 @@
+package patientquery_test
+
+import (
+	"testing"
+
+	"github.com/roasted99/hospital-middleware/internal/db/patientquery"
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuilderTenantOnly(t *testing.T) {
+	sql, args := patientquery.ForHospital("Hospital A").Select("id")
+
+	assert.Equal(t, "SELECT id FROM patient WHERE hospital = $1 AND deleted_at IS NULL", sql)
+	assert.Equal(t, []interface{}{"Hospital A"}, args)
+}
+
+func TestBuilderGroupsNameCriteria(t *testing.T) {
+	sql, args := patientquery.ForHospital("Hospital A").
+		Match(models.PatientSearchRequest{FirstName: "Som", LastName: "Mee"}).
+		Select("id")
+
+	assert.Equal(t, "SELECT id FROM patient WHERE hospital = $1 AND deleted_at IS NULL AND "+
+		"((first_name_en ILIKE $2 OR first_name_th ILIKE $3) AND (last_name_en ILIKE $4 OR last_name_th ILIKE $5))", sql)
+	assert.Equal(t, []interface{}{"Hospital A", "%Som%", "%Som%", "%Mee%", "%Mee%"}, args)
+}
+
+func TestBuilderKeepsTenantPredicateOutsideCallerORs(t *testing.T) {
+	// A raw OR from a caller must not be able to escape its group
+	sql, args := patientquery.ForHospital("Hospital A").
+		Where(patientquery.Cond("national_id = ? OR passport_id = ?", "1101500234564", "AB123456")).
+		Select("id")
+
+	assert.Equal(t, "SELECT id FROM patient WHERE hospital = $1 AND deleted_at IS NULL AND (national_id = $2 OR passport_id = $3)", sql)
+	assert.Equal(t, []interface{}{"Hospital A", "1101500234564", "AB123456"}, args)
+}
+
+func TestBuilderNestedGroups(t *testing.T) {
+	sql, args := patientquery.ForHospital("Hospital A").
+		Where(patientquery.Or(
+			patientquery.And(patientquery.Cond("first_name_en = ?", "Somchai"), patientquery.Cond("last_name_en = ?", "Meesuk")),
+			patientquery.Cond("patient_hn = ?", "HN-00123"),
+		)).
+		Where(patientquery.Cond("gender = ?", "M")).
+		Select("id")
+
+	assert.Equal(t, "SELECT id FROM patient WHERE hospital = $1 AND deleted_at IS NULL AND "+
+		"(((first_name_en = $2 AND last_name_en = $3) OR patient_hn = $4) AND gender = $5)", sql)
+	assert.Equal(t, []interface{}{"Hospital A", "Somchai", "Meesuk", "HN-00123", "M"}, args)
+}
+
+func TestBuilderSinglePredicateGroups(t *testing.T) {
+	sql, _ := patientquery.ForHospital("Hospital A").
+		Where(patientquery.Or(patientquery.Cond("national_id = ?", "1101500234564"))).
+		Select("id")
+
+	assert.Equal(t, "SELECT id FROM patient WHERE hospital = $1 AND deleted_at IS NULL AND (national_id = $2)", sql)
+}
+
+func TestBuilderKeysetOrderAndLimit(t *testing.T) {
+	builder := patientquery.ForHospital("Hospital A").
+		Match(models.PatientSearchRequest{NationalID: "1101500234564"}).
+		After(patientquery.Cond("(created_at, id) > (?::timestamptz, ?)", "2024-01-01T00:00:00Z", 7)).
+		OrderBy("created_at ASC, id ASC").
+		Limit(21)
+
+	sql, args := builder.Select("id")
+	assert.Equal(t, "SELECT id FROM patient WHERE hospital = $1 AND deleted_at IS NULL AND (national_id = $2) AND "+
+		"(created_at, id) > ($3::timestamptz, $4) ORDER BY created_at ASC, id ASC LIMIT 21", sql)
+	assert.Equal(t, []interface{}{"Hospital A", "1101500234564", "2024-01-01T00:00:00Z", 7}, args)
+
+	// Counting ignores the cursor, order and limit
+	sql, args = builder.Count()
+	assert.Equal(t, "SELECT COUNT(*) FROM patient WHERE hospital = $1 AND deleted_at IS NULL AND (national_id = $2)", sql)
+	assert.Equal(t, []interface{}{"Hospital A", "1101500234564"}, args)
+}
+
+func TestBuilderAcrossHospitals(t *testing.T) {
+	builder := patientquery.AcrossHospitals().Match(models.PatientSearchRequest{PassportID: "AB123456"})
+	assert.True(t, builder.HasCriteria())
+
+	sql, args := builder.Select("id")
+	assert.Equal(t, "SELECT id FROM patient WHERE deleted_at IS NULL AND (passport_id = $1)", sql)
+	assert.Equal(t, []interface{}{"AB123456"}, args)
+
+	assert.False(t, patientquery.AcrossHospitals().Match(models.PatientSearchRequest{}).HasCriteria())
+}
+
+func TestBuilderMatchesHN(t *testing.T) {
+	sql, args := patientquery.ForHospital("Hospital B").
+		Match(models.PatientSearchRequest{PatientHN: "HN-B-0042"}).
+		Select("id")
+
+	assert.Equal(t, "SELECT id FROM patient WHERE hospital = $1 AND deleted_at IS NULL AND (patient_hn = $2)", sql)
+	assert.Equal(t, []interface{}{"Hospital B", "HN-B-0042"}, args)
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+type CacheInvalidationResponse struct {
+	Hospital    string `json:"hospital"`
+	PatientID   string `json:"patient_id"`
+	Invalidated int    `json:"invalidated"`
+}
+
+type BreakerStatus struct {
+	Hospital            string     `json:"hospital"`
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+	RetryAt             *time.Time `json:"retry_at,omitempty"`
+}
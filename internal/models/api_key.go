@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// APIKey authenticates an integration client, such as a kiosk or a batch
+// system, on behalf of a hospital
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Hospital   string     `json:"hospital"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedBy  *int       `json:"created_by,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type APIKeyRequest struct {
+	Name      string     `json:"name" binding:"required"`
+	Scopes    []string   `json:"scopes" binding:"required"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// APIKeyCreatedResponse is the only response that contains the key itself
+type APIKeyCreatedResponse struct {
+	APIKey
+	Key string `json:"key"`
+}
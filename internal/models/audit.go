@@ -0,0 +1,67 @@
+package models
+
+import "time"
+
+// Audited actions
+const (
+	AuditActionPatientSearch          = "patient.search"
+	AuditActionPatientSearchFederated = "patient.search.federated"
+	AuditActionPatientRead            = "patient.read"
+	AuditActionPatientCreate          = "patient.create"
+	AuditActionPatientUpdate          = "patient.update"
+	AuditActionPatientDelete          = "patient.delete"
+	AuditActionAuditRead              = "audit.read"
+	AuditActionConsentGrant           = "consent.grant"
+	AuditActionConsentList            = "consent.list"
+	AuditActionConsentRevoke          = "consent.revoke"
+	AuditActionBreakGlassStart        = "break_glass.start"
+	AuditActionBreakGlassReviewList   = "break_glass.review.list"
+	AuditActionBreakGlassReview       = "break_glass.review"
+	AuditActionStaffCrossHospital     = "staff.cross_hospital"
+)
+
+// Audit outcomes, derived from the response status
+const (
+	AuditOutcomeSuccess  = "success"
+	AuditOutcomeNotFound = "not_found"
+	AuditOutcomeDenied   = "denied"
+	AuditOutcomeRejected = "rejected"
+	AuditOutcomeError    = "error"
+)
+
+// AuditEntry records who accessed which patients through which endpoint
+type AuditEntry struct {
+	ID         int64     `json:"id"`
+	OccurredAt time.Time `json:"occurred_at"`
+	RequestID  string    `json:"request_id"`
+	Action     string    `json:"action"`
+	StaffID    *int      `json:"staff_id,omitempty"`
+	APIKeyID   *int      `json:"api_key_id,omitempty"`
+	Username   string    `json:"username"`
+	Hospital   string    `json:"hospital"`
+	Role       string    `json:"role,omitempty"`
+	// Criteria holds the search parameters or the requested patient ID
+	Criteria map[string]string `json:"criteria"`
+	// PatientIDs identifies the patients returned or changed. Federated
+	// results are prefixed with their source, e.g. "Hospital A:hn:HN-00123".
+	PatientIDs []string `json:"patient_ids"`
+	DataSource string   `json:"data_source,omitempty"`
+	ClientIP   string   `json:"client_ip"`
+	Outcome    string   `json:"outcome"`
+	StatusCode int      `json:"status_code"`
+	// BreakGlassID flags requests made with an emergency access token
+	BreakGlassID *int64 `json:"break_glass_id,omitempty"`
+	// PrevHash and Hash chain each entry to the one before it
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"entry_hash"`
+}
+
+// AuditCheckpoint seals the audit log up to LastEntryID. Signature is a JWT
+// over the entry ID and hash, signed with the access token keyset.
+type AuditCheckpoint struct {
+	ID            int64     `json:"id"`
+	LastEntryID   int64     `json:"last_entry_id"`
+	LastEntryHash string    `json:"last_entry_hash"`
+	Signature     string    `json:"signature"`
+	CreatedAt     time.Time `json:"created_at"`
+}
@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// BreakGlassRequest starts emergency access
+type BreakGlassRequest struct {
+	ReasonCode    string `json:"reason_code"`
+	Justification string `json:"justification"`
+}
+
+// BreakGlassResponse carries the elevated access token. It cannot be refreshed.
+type BreakGlassResponse struct {
+	BreakGlassID int64     `json:"break_glass_id"`
+	Token        string    `json:"token"`
+	ExpiresIn    int       `json:"expires_in"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// BreakGlassEvent is one emergency access and its review
+type BreakGlassEvent struct {
+	ID            int64     `json:"id"`
+	StaffID       *int      `json:"staff_id,omitempty"`
+	Username      string    `json:"username"`
+	Hospital      string    `json:"hospital"`
+	Role          string    `json:"role"`
+	ReasonCode    string    `json:"reason_code"`
+	Justification string    `json:"justification"`
+	ClientIP      string    `json:"client_ip"`
+	StartedAt     time.Time `json:"started_at"`
+	ExpiresAt     time.Time `json:"expires_at"`
+	// Accesses counts the audited requests made with the elevated token
+	Accesses       int        `json:"accesses"`
+	ReviewedAt     *time.Time `json:"reviewed_at,omitempty"`
+	ReviewedBy     *int       `json:"reviewed_by,omitempty"`
+	ReviewDecision string     `json:"review_decision,omitempty"`
+	ReviewNotes    string     `json:"review_notes,omitempty"`
+}
+
+// BreakGlassReviewRequest records a privacy officer's decision
+type BreakGlassReviewRequest struct {
+	Decision string `json:"decision"`
+	Notes    string `json:"notes"`
+}
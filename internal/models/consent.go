@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// Consent lets RequestingHospital look up the patient's records in other
+// hospital systems for Purpose until it expires or is revoked
+type Consent struct {
+	ID                 int        `json:"id"`
+	NationalID         string     `json:"national_id,omitempty"`
+	PassportID         string     `json:"passport_id,omitempty"`
+	RequestingHospital string     `json:"requesting_hospital"`
+	Purpose            string     `json:"purpose"`
+	GrantedAt          time.Time  `json:"granted_at"`
+	ExpiresAt          *time.Time `json:"expires_at,omitempty"`
+	RevokedAt          *time.Time `json:"revoked_at,omitempty"`
+	// Hospital is where the consent was recorded
+	Hospital   string    `json:"hospital"`
+	RecordedBy *int      `json:"recorded_by,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ConsentRequest records a consent. RequestingHospital defaults to the
+// hospital of the staff member recording it.
+type ConsentRequest struct {
+	NationalID         string     `json:"national_id"`
+	PassportID         string     `json:"passport_id"`
+	RequestingHospital string     `json:"requesting_hospital"`
+	Purpose            string     `json:"purpose"`
+	ExpiresAt          *time.Time `json:"expires_at"`
+}
@@ -27,10 +27,86 @@ type Patient struct {
 type PatientSearchRequest struct {
 	NationalID string `json:"national_id"`
 	PassportID string `json:"passport_id"`
+	PatientHN string `json:"patient_hn"`
 	FirstName string `json:"first_name"`
 	MiddleName string `json:"middle_name"`
 	LastName string `json:"last_name"`
 	DateOfBirth string `json:"date_of_birth"`
 	PhoneNumber string `json:"phone_number"`
 	Email string `json:"email"`
-}
\ No newline at end of file
+}
+
+// HasCriteria reports whether at least one search parameter is set
+func (r PatientSearchRequest) HasCriteria() bool {
+	return r != PatientSearchRequest{}
+}
+
+// Federated search source statuses
+const (
+	SourceStatusOK      = "ok"
+	SourceStatusTimeout = "timeout"
+	SourceStatusError   = "error"
+	// The hospital was not asked because the patient has not consented
+	SourceStatusConsentRequired = "consent_required"
+)
+
+type FederatedPatient struct {
+	Source string `json:"source"`
+	Patient
+}
+
+type FederatedSourceResult struct {
+	Source     string `json:"source"`
+	Status     string `json:"status"`
+	Count      int    `json:"count"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+type FederatedSearchResponse struct {
+	Patients []FederatedPatient      `json:"patients"`
+	Sources  []FederatedSourceResult `json:"sources"`
+}
+
+// PatientRequest is the body of POST /patient and PUT /patient/{id}
+type PatientRequest struct {
+	FirstNameTH  string `json:"first_name_th"`
+	MiddleNameTH string `json:"middle_name_th"`
+	LastNameTH   string `json:"last_name_th"`
+	FirstNameEN  string `json:"first_name_en"`
+	MiddleNameEN string `json:"middle_name_en"`
+	LastNameEN   string `json:"last_name_en"`
+	DateOfBirth  string `json:"date_of_birth"`
+	PatientHN    string `json:"patient_hn"`
+	NationalID   string `json:"national_id"`
+	PassportID   string `json:"passport_id"`
+	PhoneNumber  string `json:"phone_number"`
+	Email        string `json:"email"`
+	Gender       string `json:"gender"`
+}
+
+// PatientPatchRequest is the body of PATCH /patient/{id}; nil fields are left unchanged
+type PatientPatchRequest struct {
+	FirstNameTH  *string `json:"first_name_th"`
+	MiddleNameTH *string `json:"middle_name_th"`
+	LastNameTH   *string `json:"last_name_th"`
+	FirstNameEN  *string `json:"first_name_en"`
+	MiddleNameEN *string `json:"middle_name_en"`
+	LastNameEN   *string `json:"last_name_en"`
+	DateOfBirth  *string `json:"date_of_birth"`
+	PatientHN    *string `json:"patient_hn"`
+	NationalID   *string `json:"national_id"`
+	PassportID   *string `json:"passport_id"`
+	PhoneNumber  *string `json:"phone_number"`
+	Email        *string `json:"email"`
+	Gender       *string `json:"gender"`
+}
+
+// PageMeta describes one page of a paginated list
+type PageMeta struct {
+	Limit      int    `json:"limit"`
+	Sort       string `json:"sort,omitempty"`
+	HasMore    bool   `json:"has_more"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	Total      *int   `json:"total,omitempty"`
+}
@@ -7,14 +7,31 @@ type Staff struct {
 	Username  string    `json:"username" gorm:"unique;not null"`
 	Password  string    `json:"-"`
 	Hospital string		`json:"hospital" gorm:"not null"`
+	CrossHospital bool `json:"cross_hospital"`
+	Role string `json:"role"`
+	MFAEnabled bool `json:"mfa_enabled"`
+	PasswordChangeRequired bool `json:"password_change_required"`
+	// BreakGlassID is set while the staff member uses an emergency access token
+	BreakGlassID int64 `json:"-"`
 	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
 	UpdatedAt time.Time `json:"updated_at" gorm:"autoUpdateTime"`
 }
 
-type StaffCreateRequest struct {
-	Username  string `json:"username" binding:"required"`
-	Password  string `json:"password" binding:"required"`
-	Hospital string `json:"hospital" binding:"required"`
+type StaffInvitationRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+type StaffInvitationResponse struct {
+	Token string `json:"token"`
+	Hospital string `json:"hospital"`
+	Role string `json:"role"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type StaffInvitationRedeemRequest struct {
+	Token string `json:"token" binding:"required"`
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
 }
 
 type StaffLoginRequest struct {
@@ -25,8 +42,68 @@ type StaffLoginRequest struct {
 
 type AuthResponse struct {
 	Token string `json:"token"`
+	ExpiresIn int `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
 	StaffID int `json:"staff_id"`
 	Username string `json:"username"`
 	Hospital string `json:"hospital"`
+	Role string `json:"role"`
+	PasswordChangeRequired bool `json:"password_change_required,omitempty"`
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+}
+
+type StaffRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+type StaffCrossHospitalRequest struct {
+	CrossHospital *bool `json:"cross_hospital"`
+}
+
+type PasswordChangeRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
 }
 
+// PasswordResetResponse carries a temporary password that must be changed at
+// the next sign-in
+type PasswordResetResponse struct {
+	StaffID int `json:"staff_id"`
+	TemporaryPassword string `json:"temporary_password"`
+}
+
+type TokenRefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// MFAChallengeResponse is returned by login instead of a token when a second
+// factor is required
+type MFAChallengeResponse struct {
+	MFARequired bool `json:"mfa_required"`
+	EnrollmentRequired bool `json:"enrollment_required"`
+	MFAToken string `json:"mfa_token"`
+	ExpiresIn int `json:"expires_in"`
+}
+
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	Code string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type MFAEnrollmentRequest struct {
+	MFAToken string `json:"mfa_token"`
+}
+
+type MFAEnrollmentResponse struct {
+	Secret string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+type MFAConfirmRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type MFARecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
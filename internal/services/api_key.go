@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/roasted99/hospital-middleware/internal/models"
+)
+
+// ErrInvalidAPIKey is returned for unknown, revoked and expired keys alike
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// apiKeyMarker starts every API key so leaked keys are easy to recognize
+const apiKeyMarker = "hmk_"
+
+// apiKeyUsageInterval limits last_used_at updates to one per key and interval
+const apiKeyUsageInterval = time.Minute
+
+// GenerateAPIKey returns a new API key, the prefix shown to admins to tell keys
+// apart and the hash that is stored in place of the key
+func GenerateAPIKey() (key string, prefix string, hash string, err error) {
+	token, _, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", "", "", err
+	}
+	key = apiKeyMarker + token
+	return key, key[:len(apiKeyMarker)+8], HashOpaqueToken(key), nil
+}
+
+// APIKeyHasScope reports whether key was granted permission
+func APIKeyHasScope(key *models.APIKey, permission Permission) bool {
+	for _, scope := range key.Scopes {
+		if scope == string(permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyStore looks up the API keys presented by integration clients
+type APIKeyStore interface {
+	AuthenticateAPIKey(ctx context.Context, key string) (*models.APIKey, error)
+}
+
+// SQLAPIKeyStore keeps API keys in the api_keys table
+type SQLAPIKeyStore struct {
+	DB *sql.DB
+}
+
+func NewSQLAPIKeyStore(db *sql.DB) *SQLAPIKeyStore {
+	return &SQLAPIKeyStore{DB: db}
+}
+
+// AuthenticateAPIKey returns the active key matching key and records its use
+func (s *SQLAPIKeyStore) AuthenticateAPIKey(ctx context.Context, key string) (*models.APIKey, error) {
+	if !strings.HasPrefix(key, apiKeyMarker) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	var apiKey models.APIKey
+	var expiresAt sql.NullTime
+	err := s.DB.QueryRowContext(ctx, "SELECT id, name, hospital, key_prefix, scopes, expires_at FROM api_keys WHERE key_hash = $1 AND revoked_at IS NULL",
+		HashOpaqueToken(key)).Scan(&apiKey.ID, &apiKey.Name, &apiKey.Hospital, &apiKey.Prefix, pq.Array(&apiKey.Scopes), &expiresAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if expiresAt.Valid {
+		if !time.Now().Before(expiresAt.Time) {
+			return nil, ErrInvalidAPIKey
+		}
+		apiKey.ExpiresAt = &expiresAt.Time
+	}
+
+	// Busy kiosks would otherwise write on every request
+	_, err = s.DB.ExecContext(ctx, "UPDATE api_keys SET last_used_at = NOW() WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < $2)",
+		apiKey.ID, time.Now().Add(-apiKeyUsageInterval))
+	if err != nil {
+		return nil, err
+	}
+
+	return &apiKey, nil
+}
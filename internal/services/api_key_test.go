@@ -0,0 +1,56 @@
+package services_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLAPIKeyStore(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := services.NewSQLAPIKeyStore(db)
+	key, prefix, hash, err := services.GenerateAPIKey()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(key, prefix))
+	assert.Equal(t, services.HashOpaqueToken(key), hash)
+
+	columns := []string{"id", "name", "hospital", "key_prefix", "scopes", "expires_at"}
+	mock.ExpectQuery("SELECT id, name, hospital, key_prefix, scopes, expires_at FROM api_keys WHERE key_hash = \\$1 AND revoked_at IS NULL").
+		WithArgs(hash).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(4, "lobby kiosk", "Hospital A", prefix, "{patient:search,patient:read}", nil))
+	mock.ExpectExec("UPDATE api_keys SET last_used_at = NOW\\(\\) WHERE id = \\$1").
+		WithArgs(4, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	apiKey, err := store.AuthenticateAPIKey(context.Background(), key)
+	require.NoError(t, err)
+	assert.Equal(t, "Hospital A", apiKey.Hospital)
+	assert.True(t, services.APIKeyHasScope(apiKey, services.PermPatientSearch))
+	assert.False(t, services.APIKeyHasScope(apiKey, services.PermPatientWrite))
+
+	mock.ExpectQuery("SELECT id, name, hospital, key_prefix, scopes, expires_at FROM api_keys").
+		WithArgs(hash).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(4, "lobby kiosk", "Hospital A", prefix, "{patient:search}", time.Now().Add(-time.Hour)))
+	_, err = store.AuthenticateAPIKey(context.Background(), key)
+	assert.ErrorIs(t, err, services.ErrInvalidAPIKey, "expired keys are rejected")
+
+	mock.ExpectQuery("SELECT id, name, hospital, key_prefix, scopes, expires_at FROM api_keys").
+		WithArgs(hash).
+		WillReturnRows(sqlmock.NewRows(columns))
+	_, err = store.AuthenticateAPIKey(context.Background(), key)
+	assert.ErrorIs(t, err, services.ErrInvalidAPIKey, "unknown and revoked keys are rejected")
+
+	_, err = store.AuthenticateAPIKey(context.Background(), "Bearer something")
+	assert.ErrorIs(t, err, services.ErrInvalidAPIKey)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,444 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/lib/pq"
+	"github.com/roasted99/hospital-middleware/internal/config"
+	"github.com/roasted99/hospital-middleware/internal/models"
+)
+
+// auditChainLock is the advisory lock that serializes appends to the audit
+// chain, so every entry links to the one committed before it
+const auditChainLock = 7310512
+
+// AuditEntryColumns are the audit_log columns scanned by ScanAuditEntry, in order
+const AuditEntryColumns = "id, occurred_at, request_id, action, staff_id, api_key_id, username, hospital, role, criteria, patient_ids, data_source, client_ip, outcome, status_code, break_glass_id, prev_hash, entry_hash"
+
+// AuditLogger persists audit entries
+type AuditLogger interface {
+	Record(ctx context.Context, entry *models.AuditEntry) error
+}
+
+// SQLAuditLogger appends entries to the audit_log table. Each entry carries
+// the hash of the previous one, so editing or deleting an entry breaks every
+// link after it; signed checkpoints cover the end of the chain.
+type SQLAuditLogger struct {
+	DB *sql.DB
+}
+
+func NewSQLAuditLogger(db *sql.DB) *SQLAuditLogger {
+	return &SQLAuditLogger{DB: db}
+}
+
+func (l *SQLAuditLogger) Record(ctx context.Context, entry *models.AuditEntry) error {
+	criteria, err := json.Marshal(entry.Criteria)
+	if err != nil {
+		return err
+	}
+	if entry.PatientIDs == nil {
+		entry.PatientIDs = []string{}
+	}
+	// Postgres keeps microseconds; the hash must match what is read back
+	entry.OccurredAt = entry.OccurredAt.Truncate(time.Microsecond)
+
+	tx, err := l.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", auditChainLock); err != nil {
+		return err
+	}
+
+	var prevHash sql.NullString
+	err = tx.QueryRowContext(ctx, "SELECT entry_hash FROM audit_log ORDER BY id DESC LIMIT 1").Scan(&prevHash)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	entry.PrevHash = prevHash.String
+	entry.Hash = AuditEntryHash(entry)
+
+	err = tx.QueryRowContext(ctx, "INSERT INTO audit_log (occurred_at, request_id, action, staff_id, api_key_id, username, hospital, role, criteria, patient_ids, data_source, client_ip, outcome, status_code, break_glass_id, prev_hash, entry_hash) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17) RETURNING id",
+		entry.OccurredAt, entry.RequestID, entry.Action, entry.StaffID, entry.APIKeyID, entry.Username, entry.Hospital, entry.Role,
+		string(criteria), pq.Array(entry.PatientIDs), entry.DataSource, entry.ClientIP, entry.Outcome, entry.StatusCode, entry.BreakGlassID, entry.PrevHash, entry.Hash).Scan(&entry.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// AuditEntryHash returns the SHA-256 digest of entry's content and PrevHash.
+// The ID is left out because it is assigned by the database.
+func AuditEntryHash(entry *models.AuditEntry) string {
+	patientIDs := entry.PatientIDs
+	if patientIDs == nil {
+		patientIDs = []string{}
+	}
+	// Fields are encoded in a fixed order; map keys are sorted by encoding/json
+	fields := []interface{}{
+		entry.PrevHash,
+		entry.OccurredAt.UTC().Format(time.RFC3339Nano),
+		entry.RequestID,
+		entry.Action,
+		entry.StaffID,
+		entry.APIKeyID,
+		entry.Username,
+		entry.Hospital,
+		entry.Role,
+		entry.Criteria,
+		patientIDs,
+		entry.DataSource,
+		entry.ClientIP,
+		entry.Outcome,
+		entry.StatusCode,
+	}
+	// Appended only when set, so entries from before emergency access keep their hashes
+	if entry.BreakGlassID != nil {
+		fields = append(fields, *entry.BreakGlassID)
+	}
+	payload, _ := json.Marshal(fields)
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// auditCheckpointClaims is the signed content of a checkpoint
+type auditCheckpointClaims struct {
+	LastEntryID   int64  `json:"last_entry_id"`
+	LastEntryHash string `json:"last_entry_hash"`
+	jwt.RegisteredClaims
+}
+
+// Seal signs a checkpoint over the latest entry. It returns nil when nothing
+// was recorded since the previous checkpoint.
+func (l *SQLAuditLogger) Seal(ctx context.Context, now time.Time) (*models.AuditCheckpoint, error) {
+	checkpoint := &models.AuditCheckpoint{CreatedAt: now}
+	var lastHash sql.NullString
+	err := l.DB.QueryRowContext(ctx, "SELECT id, entry_hash FROM audit_log ORDER BY id DESC LIMIT 1").Scan(&checkpoint.LastEntryID, &lastHash)
+	if err == sql.ErrNoRows || (err == nil && !lastHash.Valid) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	checkpoint.LastEntryHash = lastHash.String
+
+	var sealed bool
+	err = l.DB.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM audit_checkpoints WHERE last_entry_id >= $1)", checkpoint.LastEntryID).Scan(&sealed)
+	if err != nil || sealed {
+		return nil, err
+	}
+
+	keyset := CurrentKeyset()
+	if keyset == nil {
+		return nil, ErrNoSigningKey
+	}
+	key, err := keyset.SigningKey(now)
+	if err != nil {
+		return nil, err
+	}
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), auditCheckpointClaims{
+		LastEntryID:   checkpoint.LastEntryID,
+		LastEntryHash: checkpoint.LastEntryHash,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:   config.GetJWTIssuer(),
+			IssuedAt: jwt.NewNumericDate(now),
+		},
+	})
+	token.Header["kid"] = key.KID
+	if checkpoint.Signature, err = token.SignedString(key.PrivateKey); err != nil {
+		return nil, err
+	}
+
+	err = l.DB.QueryRowContext(ctx, "INSERT INTO audit_checkpoints (last_entry_id, last_entry_hash, signature, created_at) VALUES ($1, $2, $3, $4) RETURNING id",
+		checkpoint.LastEntryID, checkpoint.LastEntryHash, checkpoint.Signature, checkpoint.CreatedAt).Scan(&checkpoint.ID)
+	if err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
+// SealAuditLog adds a checkpoint every interval until ctx is cancelled
+func SealAuditLog(ctx context.Context, logger *SQLAuditLogger, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			checkpoint, err := logger.Seal(ctx, now)
+			if err != nil {
+				log.Printf("Error sealing audit log: %v", err)
+				continue
+			}
+			if checkpoint != nil {
+				log.Printf("Sealed audit log up to entry %d", checkpoint.LastEntryID)
+			}
+		}
+	}
+}
+
+// AuditBreak is the first link of the audit chain that failed verification
+type AuditBreak struct {
+	EntryID      int64
+	CheckpointID int64
+	Reason       string
+}
+
+func (b *AuditBreak) Error() string {
+	if b.CheckpointID != 0 {
+		return fmt.Sprintf("audit chain broken at checkpoint %d (entry %d): %s", b.CheckpointID, b.EntryID, b.Reason)
+	}
+	return fmt.Sprintf("audit chain broken at entry %d: %s", b.EntryID, b.Reason)
+}
+
+// AuditVerification summarizes a verified range of the audit log
+type AuditVerification struct {
+	FirstEntryID int64
+	LastEntryID  int64
+	Entries      int
+	// Unchained counts entries written before the log was hash-chained
+	Unchained   int
+	Checkpoints int
+}
+
+// Verify checks every entry that occurred in [from, to) against its hash and
+// its predecessor, the link from the last of them into the entry that follows,
+// and every checkpoint that seals an entry in the range or was created within
+// it against its signature and the entry it seals. A range whose entries were
+// all deleted therefore still fails. The first failure is returned as an
+// *AuditBreak.
+func (l *SQLAuditLogger) Verify(ctx context.Context, from, to time.Time) (AuditVerification, error) {
+	var result AuditVerification
+
+	// Entries are chained in ID order, which can differ slightly from
+	// occurred_at order, so the range is converted to IDs first
+	var first, last sql.NullInt64
+	err := l.DB.QueryRowContext(ctx, "SELECT (SELECT MIN(id) FROM audit_log WHERE occurred_at >= $1 AND occurred_at < $2), (SELECT MAX(id) FROM audit_log WHERE occurred_at >= $1 AND occurred_at < $2)",
+		from, to).Scan(&first, &last)
+	if err != nil {
+		return result, err
+	}
+
+	// The entry before the range is found by ID when the range has entries and
+	// by time when it has none
+	var before sql.NullInt64
+	var prevHash sql.NullString
+	if first.Valid {
+		err = l.DB.QueryRowContext(ctx, "SELECT id, entry_hash FROM audit_log WHERE id < $1 ORDER BY id DESC LIMIT 1", first.Int64).Scan(&before, &prevHash)
+	} else {
+		err = l.DB.QueryRowContext(ctx, "SELECT id, entry_hash FROM audit_log WHERE occurred_at < $1 ORDER BY id DESC LIMIT 1", from).Scan(&before, &prevHash)
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return result, err
+	}
+
+	hashes := make(map[int64]string)
+	lastID, lastHash := before.Int64, prevHash.String
+	if first.Valid {
+		result.FirstEntryID, result.LastEntryID = first.Int64, last.Int64
+		if hashes, err = l.verifyEntries(ctx, first.Int64, last.Int64, prevHash.String, &result); err != nil {
+			return result, err
+		}
+		lastID, lastHash = last.Int64, hashes[last.Int64]
+	}
+
+	if err := l.verifyNextLink(ctx, lastID, lastHash); err != nil {
+		return result, err
+	}
+	return result, l.verifyCheckpoints(ctx, first, last, from, to, hashes, &result)
+}
+
+// verifyNextLink checks that the first entry after lastID links to lastHash,
+// which catches entries deleted from the end of the range
+func (l *SQLAuditLogger) verifyNextLink(ctx context.Context, lastID int64, lastHash string) error {
+	var next int64
+	var prevHash, hash sql.NullString
+	err := l.DB.QueryRowContext(ctx, "SELECT id, prev_hash, entry_hash FROM audit_log WHERE id > $1 ORDER BY id LIMIT 1", lastID).Scan(&next, &prevHash, &hash)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if hash.Valid && prevHash.String != lastHash {
+		return &AuditBreak{EntryID: next, Reason: "previous hash does not match the preceding entry"}
+	}
+	return nil
+}
+
+func (l *SQLAuditLogger) verifyEntries(ctx context.Context, first, last int64, prevHash string, result *AuditVerification) (map[int64]string, error) {
+	rows, err := l.DB.QueryContext(ctx, "SELECT "+AuditEntryColumns+" FROM audit_log WHERE id >= $1 AND id <= $2 ORDER BY id",
+		first, last)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hashes := make(map[int64]string)
+	for rows.Next() {
+		entry, err := ScanAuditEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		result.Entries++
+
+		switch {
+		case entry.Hash == "" && prevHash == "":
+			result.Unchained++
+			continue
+		case entry.Hash == "":
+			return nil, &AuditBreak{EntryID: entry.ID, Reason: "entry has no hash"}
+		case entry.PrevHash != prevHash:
+			return nil, &AuditBreak{EntryID: entry.ID, Reason: "previous hash does not match the preceding entry"}
+		case AuditEntryHash(entry) != entry.Hash:
+			return nil, &AuditBreak{EntryID: entry.ID, Reason: "entry hash does not match its content"}
+		}
+		prevHash = entry.Hash
+		hashes[entry.ID] = entry.Hash
+	}
+	return hashes, rows.Err()
+}
+
+func (l *SQLAuditLogger) verifyCheckpoints(ctx context.Context, first, last sql.NullInt64, from, to time.Time, hashes map[int64]string, result *AuditVerification) error {
+	rows, err := l.DB.QueryContext(ctx, "SELECT id, last_entry_id, last_entry_hash, signature, created_at FROM audit_checkpoints WHERE (last_entry_id >= $1 AND last_entry_id <= $2) OR (created_at >= $3 AND created_at < $4) ORDER BY id",
+		first, last, from, to)
+	if err != nil {
+		return err
+	}
+	var checkpoints []models.AuditCheckpoint
+	for rows.Next() {
+		var checkpoint models.AuditCheckpoint
+		if err := rows.Scan(&checkpoint.ID, &checkpoint.LastEntryID, &checkpoint.LastEntryHash, &checkpoint.Signature, &checkpoint.CreatedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		checkpoints = append(checkpoints, checkpoint)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, checkpoint := range checkpoints {
+		result.Checkpoints++
+
+		// A checkpoint created in the range can seal an entry before it
+		sealed, ok := hashes[checkpoint.LastEntryID]
+		if !ok {
+			var hash sql.NullString
+			err := l.DB.QueryRowContext(ctx, "SELECT entry_hash FROM audit_log WHERE id = $1", checkpoint.LastEntryID).Scan(&hash)
+			if err != nil && err != sql.ErrNoRows {
+				return err
+			}
+			sealed = hash.String
+		}
+
+		broken := &AuditBreak{EntryID: checkpoint.LastEntryID, CheckpointID: checkpoint.ID}
+		claims, err := verifyCheckpointSignature(checkpoint.Signature)
+		switch {
+		case err != nil:
+			broken.Reason = "invalid signature: " + err.Error()
+		case claims.LastEntryID != checkpoint.LastEntryID || claims.LastEntryHash != checkpoint.LastEntryHash:
+			broken.Reason = "signature does not cover the stored checkpoint"
+		case sealed == "":
+			broken.Reason = "sealed entry is missing"
+		case sealed != checkpoint.LastEntryHash:
+			broken.Reason = "sealed entry hash does not match"
+		default:
+			continue
+		}
+		return broken
+	}
+	return nil
+}
+
+// verifyCheckpointSignature checks a checkpoint with the configured key named
+// by its kid. Retired keys are still used, but a key removed from JWT_KEYS
+// leaves every checkpoint it signed unverifiable.
+func verifyCheckpointSignature(signature string) (*auditCheckpointClaims, error) {
+	claims := &auditCheckpointClaims{}
+	_, err := jwt.ParseWithClaims(signature, claims, func(token *jwt.Token) (interface{}, error) {
+		keyset := CurrentKeyset()
+		if keyset == nil {
+			return nil, ErrNoSigningKey
+		}
+		kid, _ := token.Header["kid"].(string)
+		key, ok := keyset.Key(kid)
+		if !ok {
+			return nil, errors.New("unknown signing key " + kid + "; keep retired keys in JWT_KEYS to verify their checkpoints")
+		}
+		if token.Method.Alg() != key.Algorithm {
+			return nil, errors.New("unexpected signing method")
+		}
+		return key.PrivateKey.Public(), nil
+	}, jwt.WithValidMethods([]string{AlgRS256, AlgES256}), jwt.WithIssuer(config.GetJWTIssuer()))
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// ScanAuditEntry reads an audit_log row selected with AuditEntryColumns
+func ScanAuditEntry(rows *sql.Rows) (*models.AuditEntry, error) {
+	var entry models.AuditEntry
+	var staffID, apiKeyID, breakGlassID sql.NullInt64
+	var role, dataSource, prevHash, hash sql.NullString
+	var criteria []byte
+	if err := rows.Scan(&entry.ID, &entry.OccurredAt, &entry.RequestID, &entry.Action, &staffID, &apiKeyID, &entry.Username, &entry.Hospital, &role,
+		&criteria, pq.Array(&entry.PatientIDs), &dataSource, &entry.ClientIP, &entry.Outcome, &entry.StatusCode, &breakGlassID, &prevHash, &hash); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(criteria, &entry.Criteria); err != nil {
+		return nil, err
+	}
+	if staffID.Valid {
+		id := int(staffID.Int64)
+		entry.StaffID = &id
+	}
+	if apiKeyID.Valid {
+		id := int(apiKeyID.Int64)
+		entry.APIKeyID = &id
+	}
+	if breakGlassID.Valid {
+		entry.BreakGlassID = &breakGlassID.Int64
+	}
+	if entry.PatientIDs == nil {
+		entry.PatientIDs = []string{}
+	}
+	entry.Role = role.String
+	entry.DataSource = dataSource.String
+	entry.PrevHash = prevHash.String
+	entry.Hash = hash.String
+	return &entry, nil
+}
+
+// AuditOutcome classifies a response status for the audit log
+func AuditOutcome(status int) string {
+	switch {
+	case status < 400:
+		return models.AuditOutcomeSuccess
+	case status == http.StatusNotFound:
+		return models.AuditOutcomeNotFound
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return models.AuditOutcomeDenied
+	case status < 500:
+		return models.AuditOutcomeRejected
+	default:
+		return models.AuditOutcomeError
+	}
+}
@@ -0,0 +1,226 @@
+package services_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLAuditLogger(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	staffID := 3
+	entry := &models.AuditEntry{
+		OccurredAt: time.Now(),
+		RequestID:  "req-1",
+		Action:     models.AuditActionPatientSearch,
+		StaffID:    &staffID,
+		Username:   "nurse1",
+		Hospital:   "Hospital A",
+		Role:       services.RoleNurse,
+		Criteria:   map[string]string{"national_id": "1101500234564"},
+		DataSource: "Hospital A",
+		ClientIP:   "192.0.2.1",
+		Outcome:    models.AuditOutcomeNotFound,
+		StatusCode: http.StatusNotFound,
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT entry_hash FROM audit_log ORDER BY id DESC LIMIT 1").
+		WillReturnRows(sqlmock.NewRows([]string{"entry_hash"}).AddRow("9f86d08"))
+	mock.ExpectQuery("INSERT INTO audit_log \\(occurred_at, request_id, action, staff_id, api_key_id, username, hospital, role, criteria, patient_ids, data_source, client_ip, outcome, status_code, break_glass_id, prev_hash, entry_hash\\)").
+		WithArgs(sqlmock.AnyArg(), "req-1", "patient.search", &staffID, nil, "nurse1", "Hospital A", services.RoleNurse,
+			`{"national_id":"1101500234564"}`, "{}", "Hospital A", "192.0.2.1", "not_found", 404, nil, "9f86d08", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11))
+	mock.ExpectCommit()
+
+	require.NoError(t, services.NewSQLAuditLogger(db).Record(context.Background(), entry))
+	assert.Equal(t, int64(11), entry.ID)
+	assert.Equal(t, "9f86d08", entry.PrevHash)
+	assert.Equal(t, services.AuditEntryHash(entry), entry.Hash)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Emergency access is covered by the hash
+	breakGlassID := int64(4)
+	entry.BreakGlassID = &breakGlassID
+	assert.NotEqual(t, entry.Hash, services.AuditEntryHash(entry))
+}
+
+// chainedAuditEntries returns n entries linked the way Record links them
+func chainedAuditEntries(n int) []*models.AuditEntry {
+	var entries []*models.AuditEntry
+	prevHash := ""
+	for i := 1; i <= n; i++ {
+		staffID := 3
+		entry := &models.AuditEntry{
+			ID:         int64(i),
+			OccurredAt: time.Date(2024, 5, 1, 8, i, 0, 123456000, time.UTC),
+			RequestID:  "req-" + string(rune('0'+i)),
+			Action:     models.AuditActionPatientRead,
+			StaffID:    &staffID,
+			Username:   "nurse1",
+			Hospital:   "Hospital A",
+			Role:       services.RoleNurse,
+			Criteria:   map[string]string{"patient_id": "7"},
+			PatientIDs: []string{"7"},
+			DataSource: "local",
+			ClientIP:   "192.0.2.1",
+			Outcome:    models.AuditOutcomeSuccess,
+			StatusCode: http.StatusOK,
+			PrevHash:   prevHash,
+		}
+		entry.Hash = services.AuditEntryHash(entry)
+		prevHash = entry.Hash
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func auditRows(entries []*models.AuditEntry) *sqlmock.Rows {
+	rows := sqlmock.NewRows(strings.Split(services.AuditEntryColumns, ", "))
+	for _, entry := range entries {
+		criteria, _ := json.Marshal(entry.Criteria)
+		rows.AddRow(entry.ID, entry.OccurredAt, entry.RequestID, entry.Action, *entry.StaffID, nil, entry.Username, entry.Hospital, entry.Role,
+			criteria, "{"+strings.Join(entry.PatientIDs, ",")+"}", entry.DataSource, entry.ClientIP, entry.Outcome, entry.StatusCode, entry.BreakGlassID, entry.PrevHash, entry.Hash)
+	}
+	return rows
+}
+
+func TestSQLAuditLoggerSealAndVerify(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	keyset, err := services.NewKeyset([]services.SigningKey{ecSigningKey(t, "audit")}, now)
+	require.NoError(t, err)
+	services.SetKeyset(keyset)
+
+	logger := services.NewSQLAuditLogger(db)
+	entries := chainedAuditEntries(3)
+	from, to := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("SELECT id, entry_hash FROM audit_log ORDER BY id DESC LIMIT 1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "entry_hash"}).AddRow(3, entries[2].Hash))
+	mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM audit_checkpoints WHERE last_entry_id >= \\$1\\)").
+		WithArgs(int64(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery("INSERT INTO audit_checkpoints \\(last_entry_id, last_entry_hash, signature, created_at\\)").
+		WithArgs(int64(3), entries[2].Hash, sqlmock.AnyArg(), now).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+	checkpoint, err := logger.Seal(context.Background(), now)
+	require.NoError(t, err)
+	require.NotNil(t, checkpoint)
+
+	checkpointColumns := []string{"id", "last_entry_id", "last_entry_hash", "signature", "created_at"}
+	expectVerify := func(entries []*models.AuditEntry, checkpoint *models.AuditCheckpoint) {
+		mock.ExpectQuery("SELECT \\(SELECT MIN\\(id\\) FROM audit_log").
+			WithArgs(from, to).
+			WillReturnRows(sqlmock.NewRows([]string{"min", "max"}).AddRow(1, 3))
+		mock.ExpectQuery("SELECT id, entry_hash FROM audit_log WHERE id < \\$1").
+			WithArgs(int64(1)).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "entry_hash"}))
+		mock.ExpectQuery("SELECT .+ FROM audit_log WHERE id >= \\$1 AND id <= \\$2 ORDER BY id").
+			WithArgs(int64(1), int64(3)).
+			WillReturnRows(auditRows(entries))
+		if checkpoint != nil {
+			mock.ExpectQuery("SELECT id, prev_hash, entry_hash FROM audit_log WHERE id > \\$1").
+				WithArgs(int64(3)).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "prev_hash", "entry_hash"}))
+			mock.ExpectQuery("SELECT id, last_entry_id, last_entry_hash, signature, created_at FROM audit_checkpoints").
+				WithArgs(int64(1), int64(3), from, to).
+				WillReturnRows(sqlmock.NewRows(checkpointColumns).
+					AddRow(checkpoint.ID, checkpoint.LastEntryID, checkpoint.LastEntryHash, checkpoint.Signature, checkpoint.CreatedAt))
+		}
+	}
+
+	expectVerify(entries, checkpoint)
+	result, err := logger.Verify(context.Background(), from, to)
+	require.NoError(t, err)
+	assert.Equal(t, 3, result.Entries)
+	assert.Equal(t, 1, result.Checkpoints)
+
+	// An edited entry no longer matches its hash
+	entries[1].PatientIDs = []string{"8"}
+	expectVerify(entries, nil)
+	_, err = logger.Verify(context.Background(), from, to)
+	var broken *services.AuditBreak
+	require.True(t, errors.As(err, &broken))
+	assert.Equal(t, int64(2), broken.EntryID)
+
+	// A deleted tail is caught by the checkpoint that sealed it
+	expectVerify(chainedAuditEntries(2), checkpoint)
+	mock.ExpectQuery("SELECT entry_hash FROM audit_log WHERE id = \\$1").
+		WithArgs(int64(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"entry_hash"}))
+	_, err = logger.Verify(context.Background(), from, to)
+	require.True(t, errors.As(err, &broken))
+	assert.Equal(t, int64(1), broken.CheckpointID)
+	assert.Equal(t, "sealed entry is missing", broken.Reason)
+
+	// A tampered signature is rejected
+	forged := *checkpoint
+	forged.Signature = checkpoint.Signature[:len(checkpoint.Signature)-4] + "AAAA"
+	expectVerify(chainedAuditEntries(3), &forged)
+	_, err = logger.Verify(context.Background(), from, to)
+	require.True(t, errors.As(err, &broken))
+	assert.Contains(t, broken.Reason, "invalid signature")
+
+	// A range whose entries were all deleted fails at the entry after it
+	expectEmptyRange := func() {
+		mock.ExpectQuery("SELECT \\(SELECT MIN\\(id\\) FROM audit_log").
+			WithArgs(from, to).
+			WillReturnRows(sqlmock.NewRows([]string{"min", "max"}).AddRow(nil, nil))
+		mock.ExpectQuery("SELECT id, entry_hash FROM audit_log WHERE occurred_at < \\$1").
+			WithArgs(from).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "entry_hash"}))
+	}
+	expectEmptyRange()
+	mock.ExpectQuery("SELECT id, prev_hash, entry_hash FROM audit_log WHERE id > \\$1").
+		WithArgs(int64(0)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "prev_hash", "entry_hash"}).AddRow(4, entries[2].Hash, "later"))
+	_, err = logger.Verify(context.Background(), from, to)
+	require.True(t, errors.As(err, &broken))
+	assert.Equal(t, int64(4), broken.EntryID)
+
+	// ...or at a checkpoint created in the range when nothing follows it
+	expectEmptyRange()
+	mock.ExpectQuery("SELECT id, prev_hash, entry_hash FROM audit_log WHERE id > \\$1").
+		WithArgs(int64(0)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "prev_hash", "entry_hash"}))
+	mock.ExpectQuery("SELECT id, last_entry_id, last_entry_hash, signature, created_at FROM audit_checkpoints").
+		WithArgs(nil, nil, from, to).
+		WillReturnRows(sqlmock.NewRows(checkpointColumns).
+			AddRow(checkpoint.ID, checkpoint.LastEntryID, checkpoint.LastEntryHash, checkpoint.Signature, checkpoint.CreatedAt))
+	mock.ExpectQuery("SELECT entry_hash FROM audit_log WHERE id = \\$1").
+		WithArgs(int64(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"entry_hash"}))
+	result, err = logger.Verify(context.Background(), from, to)
+	require.True(t, errors.As(err, &broken))
+	assert.Equal(t, "sealed entry is missing", broken.Reason)
+	assert.Equal(t, 0, result.Entries)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAuditOutcome(t *testing.T) {
+	assert.Equal(t, models.AuditOutcomeSuccess, services.AuditOutcome(http.StatusCreated))
+	assert.Equal(t, models.AuditOutcomeNotFound, services.AuditOutcome(http.StatusNotFound))
+	assert.Equal(t, models.AuditOutcomeDenied, services.AuditOutcome(http.StatusForbidden))
+	assert.Equal(t, models.AuditOutcomeRejected, services.AuditOutcome(http.StatusBadRequest))
+	assert.Equal(t, models.AuditOutcomeError, services.AuditOutcome(http.StatusBadGateway))
+}
@@ -1,6 +1,8 @@
 package services
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"time"
 
@@ -14,32 +16,82 @@ type JWTClaims struct {
 	StaffID  int    `json:"staff_id"`
 	Username string `json:"username"`
 	Hospital string `json:"hospital"`
+	CrossHospital bool `json:"cross_hospital,omitempty"`
+	Role string `json:"role"`
+	// PasswordChangeRequired limits the token to changing the password
+	PasswordChangeRequired bool `json:"pwd_change,omitempty"`
+	// BreakGlassID marks an emergency access token and its break_glass_events row
+	BreakGlassID int64 `json:"bgl,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func GenerateJWT(staffID int, username, hospital string) (string, error) {
+func GenerateJWT(staff models.Staff) (string, error) {
+	return signJWT(staff, time.Now().Add(config.GetAccessTokenTTL()))
+}
+
+// GenerateBreakGlassJWT issues an emergency access token for staff that expires
+// at expiresAt. It also allows cross-hospital search.
+func GenerateBreakGlassJWT(staff models.Staff, breakGlassID int64, expiresAt time.Time) (string, error) {
+	staff.CrossHospital = true
+	staff.BreakGlassID = breakGlassID
+	return signJWT(staff, expiresAt)
+}
+
+func signJWT(staff models.Staff, expiresAt time.Time) (string, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+
 	claims := JWTClaims{
-		StaffID:  staffID,
-		Username: username,
-		Hospital: hospital,
+		StaffID:  staff.ID,
+		Username: staff.Username,
+		Hospital: staff.Hospital,
+		CrossHospital: staff.CrossHospital,
+		Role: staff.Role,
+		PasswordChangeRequired: staff.PasswordChangeRequired,
+		BreakGlassID: staff.BreakGlassID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			Issuer:    "hospital-middleware",
+			ID:        hex.EncodeToString(jti),
+			Issuer:    config.GetJWTIssuer(),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(72 * time.Hour)), // Token expires in 72 hour
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(config.GetJWTSecret()))
+	keyset := CurrentKeyset()
+	if keyset == nil {
+		return "", ErrNoSigningKey
+	}
+	key, err := keyset.SigningKey(time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), claims)
+	token.Header["kid"] = key.KID
+	return token.SignedString(key.PrivateKey)
 }
 
-func ValidateToken(tokenString string) (*models.Staff, error) {
+// ValidateToken verifies the signature and lifetime of a token and returns its
+// claims. Revocation is checked separately against a TokenRevocationStore.
+func ValidateToken(tokenString string) (*JWTClaims, error) {
 	claims := &JWTClaims{}
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		keyset := CurrentKeyset()
+		if keyset == nil {
+			return nil, ErrNoSigningKey
+		}
+		kid, _ := token.Header["kid"].(string)
+		key, ok := keyset.VerificationKey(kid, time.Now())
+		if !ok {
+			return nil, errors.New("unknown signing key")
+		}
+		// The algorithm must match the key so an RSA key cannot be used as an HMAC secret
+		if token.Method.Alg() != key.Algorithm {
 			return nil, errors.New("unexpected signing method")
 		}
-		return []byte(config.GetJWTSecret()), nil
-	})
+		return key.PrivateKey.Public(), nil
+	}, jwt.WithValidMethods([]string{AlgRS256, AlgES256}), jwt.WithIssuer(config.GetJWTIssuer()))
 
 	if err != nil {
 		return nil, err
@@ -49,13 +101,25 @@ func ValidateToken(tokenString string) (*models.Staff, error) {
 		return nil, errors.New("invalid token")
 	}
 
-	staff := &models.Staff{
-		ID:      claims.StaffID,
-		Username: claims.Username,
-		Hospital: claims.Hospital,
+	// Tokens without an ID cannot be revoked, so they are not accepted
+	if claims.ID == "" || claims.IssuedAt == nil || claims.ExpiresAt == nil {
+		return nil, errors.New("token is missing jti, iat or exp")
 	}
 
-	return staff, nil
+	return claims, nil
+}
+
+// Staff returns the staff member the token was issued to
+func (c *JWTClaims) Staff() *models.Staff {
+	return &models.Staff{
+		ID:      c.StaffID,
+		Username: c.Username,
+		Hospital: c.Hospital,
+		CrossHospital: c.CrossHospital,
+		Role: c.Role,
+		PasswordChangeRequired: c.PasswordChangeRequired,
+		BreakGlassID: c.BreakGlassID,
+	}
 }
 
 func HashPassword(password string) (string, error) {
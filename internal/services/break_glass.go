@@ -0,0 +1,56 @@
+package services
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/roasted99/hospital-middleware/internal/models"
+)
+
+// Reasons a clinician may give for emergency access
+const (
+	BreakGlassReasonLifeThreatening    = "life_threatening"
+	BreakGlassReasonPatientUnconscious = "patient_unconscious"
+	BreakGlassReasonUrgentTreatment    = "urgent_treatment"
+	BreakGlassReasonOther              = "other"
+)
+
+var breakGlassReasons = []string{
+	BreakGlassReasonLifeThreatening, BreakGlassReasonPatientUnconscious, BreakGlassReasonUrgentTreatment, BreakGlassReasonOther,
+}
+
+// minBreakGlassJustification keeps justifications from being a token word
+const minBreakGlassJustification = 20
+
+// Review decisions of a privacy officer
+const (
+	BreakGlassJustified   = "justified"
+	BreakGlassUnjustified = "unjustified"
+)
+
+// ValidateBreakGlassRequest checks a trimmed emergency access request and
+// returns a *ValidationError describing every invalid field
+func ValidateBreakGlassRequest(request models.BreakGlassRequest) error {
+	fields := map[string]string{}
+
+	valid := false
+	for _, reason := range breakGlassReasons {
+		valid = valid || request.ReasonCode == reason
+	}
+	if !valid {
+		fields["reason_code"] = "must be one of " + strings.Join(breakGlassReasons, ", ")
+	}
+	if utf8.RuneCountInString(request.Justification) < minBreakGlassJustification {
+		fields["justification"] = "must describe the emergency in at least 20 characters"
+	}
+
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// IsValidBreakGlassDecision reports whether decision is a known review decision
+func IsValidBreakGlassDecision(decision string) bool {
+	return decision == BreakGlassJustified || decision == BreakGlassUnjustified
+}
@@ -0,0 +1,33 @@
+package services_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateBreakGlassRequest(t *testing.T) {
+	assert.NoError(t, services.ValidateBreakGlassRequest(models.BreakGlassRequest{
+		ReasonCode: services.BreakGlassReasonPatientUnconscious, Justification: "Unconscious patient brought in by ambulance",
+	}))
+
+	err := services.ValidateBreakGlassRequest(models.BreakGlassRequest{ReasonCode: "curiosity", Justification: "just looking"})
+	var validationErr *services.ValidationError
+	require.True(t, errors.As(err, &validationErr))
+	assert.Contains(t, validationErr.Fields, "reason_code")
+	assert.Contains(t, validationErr.Fields, "justification")
+}
+
+func TestStaffHasPermission(t *testing.T) {
+	clerk := &models.Staff{Role: services.RoleRegistrationClerk}
+	assert.False(t, services.StaffHasPermission(clerk, services.PermPatientSearchFederated))
+
+	clerk.BreakGlassID = 42
+	assert.True(t, services.StaffHasPermission(clerk, services.PermPatientSearchFederated))
+	assert.True(t, services.StaffHasPermission(clerk, services.PermPatientWrite), "the clerk's own permissions are kept")
+	assert.False(t, services.StaffHasPermission(clerk, services.PermPatientDelete))
+}
@@ -0,0 +1,233 @@
+package services
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/roasted99/hospital-middleware/internal/models"
+)
+
+// PatientCache stores hospital search results. Entries carry tags so every
+// result mentioning a patient can be invalidated at once. LRUPatientCache is
+// the in-process implementation; a shared cache can implement the same interface.
+type PatientCache interface {
+	Get(key string) (CacheEntry, bool)
+	Set(key string, entry CacheEntry, ttl time.Duration)
+	InvalidateTag(tag string) int
+}
+
+// CacheEntry is a cached search result. NotFound marks a cached miss.
+type CacheEntry struct {
+	Patients []models.Patient
+	NotFound bool
+	Tags     []string
+}
+
+// CachingHospitalClient is a read-through cache in front of a HospitalClient
+type CachingHospitalClient struct {
+	Hospital    string
+	Next        HospitalClient
+	Cache       PatientCache
+	TTL         time.Duration
+	NegativeTTL time.Duration
+}
+
+func NewCachingHospitalClient(hospital string, next HospitalClient, cache PatientCache, ttl, negativeTTL time.Duration) *CachingHospitalClient {
+	return &CachingHospitalClient{
+		Hospital:    hospital,
+		Next:        next,
+		Cache:       cache,
+		TTL:         ttl,
+		NegativeTTL: negativeTTL,
+	}
+}
+
+func (c *CachingHospitalClient) SearchPatients(ctx context.Context, query models.PatientSearchRequest) ([]models.Patient, error) {
+	key := patientCacheKey(query)
+	if entry, ok := c.Cache.Get(key); ok {
+		if entry.NotFound {
+			return nil, ErrPatientNotFound
+		}
+		return entry.Patients, nil
+	}
+
+	patients, err := c.Next.SearchPatients(ctx, query)
+	if errors.Is(err, ErrPatientNotFound) {
+		if c.NegativeTTL > 0 {
+			c.Cache.Set(key, CacheEntry{NotFound: true, Tags: patientCacheTags(query, nil)}, c.NegativeTTL)
+		}
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.Cache.Set(key, CacheEntry{Patients: patients, Tags: patientCacheTags(query, patients)}, c.TTL)
+	return patients, nil
+}
+
+// InvalidatePatient drops every cached result that was looked up by, or
+// contains, the given national ID, passport ID or HN
+func (c *CachingHospitalClient) InvalidatePatient(patientID string) int {
+	return c.Cache.InvalidateTag(patientCacheTag(patientID))
+}
+
+func patientCacheKey(query models.PatientSearchRequest) string {
+	params := url.Values{}
+	setParam(params, "national_id", normalizeCacheValue(query.NationalID))
+	setParam(params, "passport_id", normalizeCacheValue(query.PassportID))
+	setParam(params, "patient_hn", normalizeCacheValue(query.PatientHN))
+	setParam(params, "first_name", normalizeCacheValue(query.FirstName))
+	setParam(params, "middle_name", normalizeCacheValue(query.MiddleName))
+	setParam(params, "last_name", normalizeCacheValue(query.LastName))
+	setParam(params, "date_of_birth", normalizeCacheValue(query.DateOfBirth))
+	setParam(params, "phone_number", normalizeCacheValue(query.PhoneNumber))
+	setParam(params, "email", normalizeCacheValue(query.Email))
+	return params.Encode()
+}
+
+func patientCacheTags(query models.PatientSearchRequest, patients []models.Patient) []string {
+	var tags []string
+	addTag := func(id string) {
+		if id != "" {
+			tags = append(tags, patientCacheTag(id))
+		}
+	}
+
+	addTag(query.NationalID)
+	addTag(query.PassportID)
+	addTag(query.PatientHN)
+	for _, patient := range patients {
+		addTag(patient.NationalID)
+		addTag(patient.PassportID)
+		addTag(patient.PatientHN)
+	}
+	return tags
+}
+
+func patientCacheTag(patientID string) string {
+	return "patient:" + normalizeCacheValue(patientID)
+}
+
+func normalizeCacheValue(value string) string {
+	return strings.ToLower(strings.TrimSpace(value))
+}
+
+// LRUPatientCache is an in-process PatientCache bounded to maxEntries,
+// evicting the least recently used entry when full
+type LRUPatientCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element
+	order      *list.List
+	tags       map[string]map[string]struct{}
+}
+
+type lruItem struct {
+	key       string
+	entry     CacheEntry
+	expiresAt time.Time
+}
+
+func NewLRUPatientCache(maxEntries int) *LRUPatientCache {
+	return &LRUPatientCache{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+		tags:       make(map[string]map[string]struct{}),
+	}
+}
+
+func (c *LRUPatientCache) Get(key string) (CacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return CacheEntry{}, false
+	}
+
+	item := element.Value.(*lruItem)
+	if !time.Now().Before(item.expiresAt) {
+		c.remove(element)
+		return CacheEntry{}, false
+	}
+
+	c.order.MoveToFront(element)
+	return copyCacheEntry(item.entry), true
+}
+
+func (c *LRUPatientCache) Set(key string, entry CacheEntry, ttl time.Duration) {
+	if ttl <= 0 || c.maxEntries <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+
+	item := &lruItem{key: key, entry: copyCacheEntry(entry), expiresAt: time.Now().Add(ttl)}
+	c.entries[key] = c.order.PushFront(item)
+	for _, tag := range item.entry.Tags {
+		if c.tags[tag] == nil {
+			c.tags[tag] = make(map[string]struct{})
+		}
+		c.tags[tag][key] = struct{}{}
+	}
+
+	for c.order.Len() > c.maxEntries {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *LRUPatientCache) InvalidateTag(tag string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	for key := range c.tags[tag] {
+		if element, ok := c.entries[key]; ok {
+			c.remove(element)
+			removed++
+		}
+	}
+	delete(c.tags, tag)
+	return removed
+}
+
+// Len returns the number of entries, including expired ones not yet evicted
+func (c *LRUPatientCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *LRUPatientCache) remove(element *list.Element) {
+	item := element.Value.(*lruItem)
+	c.order.Remove(element)
+	delete(c.entries, item.key)
+	for _, tag := range item.entry.Tags {
+		delete(c.tags[tag], item.key)
+		if len(c.tags[tag]) == 0 {
+			delete(c.tags, tag)
+		}
+	}
+}
+
+func copyCacheEntry(entry CacheEntry) CacheEntry {
+	if entry.Patients != nil {
+		entry.Patients = append([]models.Patient(nil), entry.Patients...)
+	}
+	if entry.Tags != nil {
+		entry.Tags = append([]string(nil), entry.Tags...)
+	}
+	return entry
+}
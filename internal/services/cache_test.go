@@ -0,0 +1,117 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type countingHospitalClient struct {
+	calls    int
+	patients []models.Patient
+	err      error
+}
+
+func (c *countingHospitalClient) SearchPatients(ctx context.Context, query models.PatientSearchRequest) ([]models.Patient, error) {
+	c.calls++
+	return c.patients, c.err
+}
+
+func TestCachingHospitalClientServesRepeatLookupsFromCache(t *testing.T) {
+	upstream := &countingHospitalClient{patients: []models.Patient{{NationalID: "1101500234567", PatientHN: "HN-00123"}}}
+	client := services.NewCachingHospitalClient("Hospital A", upstream, services.NewLRUPatientCache(10), time.Minute, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		patients, err := client.SearchPatients(context.Background(), models.PatientSearchRequest{NationalID: "1101500234567"})
+		require.NoError(t, err)
+		require.Len(t, patients, 1)
+	}
+	assert.Equal(t, 1, upstream.calls)
+
+	// Criteria are normalized before building the cache key
+	_, err := client.SearchPatients(context.Background(), models.PatientSearchRequest{NationalID: " 1101500234567 "})
+	require.NoError(t, err)
+	assert.Equal(t, 1, upstream.calls)
+}
+
+func TestCachingHospitalClientExpiresEntries(t *testing.T) {
+	upstream := &countingHospitalClient{patients: []models.Patient{{NationalID: "1101500234567"}}}
+	client := services.NewCachingHospitalClient("Hospital A", upstream, services.NewLRUPatientCache(10), 20*time.Millisecond, 0)
+
+	query := models.PatientSearchRequest{NationalID: "1101500234567"}
+	_, err := client.SearchPatients(context.Background(), query)
+	require.NoError(t, err)
+
+	time.Sleep(30 * time.Millisecond)
+	_, err = client.SearchPatients(context.Background(), query)
+	require.NoError(t, err)
+	assert.Equal(t, 2, upstream.calls)
+}
+
+func TestCachingHospitalClientNegativeCaching(t *testing.T) {
+	upstream := &countingHospitalClient{err: services.ErrPatientNotFound}
+	client := services.NewCachingHospitalClient("Hospital A", upstream, services.NewLRUPatientCache(10), time.Minute, time.Minute)
+
+	query := models.PatientSearchRequest{NationalID: "0000000000000"}
+	for i := 0; i < 2; i++ {
+		_, err := client.SearchPatients(context.Background(), query)
+		assert.ErrorIs(t, err, services.ErrPatientNotFound)
+	}
+	assert.Equal(t, 1, upstream.calls)
+}
+
+func TestCachingHospitalClientDoesNotCacheFailures(t *testing.T) {
+	upstream := &countingHospitalClient{err: errors.New("connection refused")}
+	client := services.NewCachingHospitalClient("Hospital A", upstream, services.NewLRUPatientCache(10), time.Minute, time.Minute)
+
+	query := models.PatientSearchRequest{NationalID: "1101500234567"}
+	for i := 0; i < 2; i++ {
+		_, err := client.SearchPatients(context.Background(), query)
+		assert.Error(t, err)
+	}
+	assert.Equal(t, 2, upstream.calls)
+}
+
+func TestCachingHospitalClientInvalidatePatient(t *testing.T) {
+	upstream := &countingHospitalClient{patients: []models.Patient{{NationalID: "1101500234567", PatientHN: "HN-00123"}}}
+	client := services.NewCachingHospitalClient("Hospital A", upstream, services.NewLRUPatientCache(10), time.Minute, time.Minute)
+
+	byID := models.PatientSearchRequest{NationalID: "1101500234567"}
+	byName := models.PatientSearchRequest{FirstName: "Somchai"}
+	_, err := client.SearchPatients(context.Background(), byID)
+	require.NoError(t, err)
+	_, err = client.SearchPatients(context.Background(), byName)
+	require.NoError(t, err)
+
+	// Both the ID lookup and the name search that returned the patient are dropped
+	assert.Equal(t, 2, client.InvalidatePatient("1101500234567"))
+
+	_, err = client.SearchPatients(context.Background(), byName)
+	require.NoError(t, err)
+	assert.Equal(t, 3, upstream.calls)
+}
+
+func TestLRUPatientCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := services.NewLRUPatientCache(2)
+	cache.Set("a", services.CacheEntry{NotFound: true}, time.Minute)
+	cache.Set("b", services.CacheEntry{NotFound: true}, time.Minute)
+
+	_, ok := cache.Get("a")
+	require.True(t, ok)
+
+	cache.Set("c", services.CacheEntry{NotFound: true}, time.Minute)
+	assert.Equal(t, 2, cache.Len())
+
+	_, ok = cache.Get("b")
+	assert.False(t, ok, "b was least recently used and should be evicted")
+	_, ok = cache.Get("a")
+	assert.True(t, ok)
+	_, ok = cache.Get("c")
+	assert.True(t, ok)
+}
@@ -0,0 +1,174 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/roasted99/hospital-middleware/internal/models"
+)
+
+// Purposes a patient can consent to
+const (
+	ConsentPurposeTreatment = "treatment"
+	ConsentPurposeBilling   = "billing"
+	ConsentPurposeResearch  = "research"
+)
+
+// ConsentPurposeEmergency marks lookups made with an emergency access token.
+// No consent is required for them; they are flagged in the audit log instead.
+const ConsentPurposeEmergency = "emergency"
+
+// DefaultConsentPurpose applies to lookups that do not name a purpose
+const DefaultConsentPurpose = ConsentPurposeTreatment
+
+var consentPurposes = []string{ConsentPurposeTreatment, ConsentPurposeBilling, ConsentPurposeResearch}
+
+// IsValidConsentPurpose reports whether purpose is a known consent purpose
+func IsValidConsentPurpose(purpose string) bool {
+	for _, known := range consentPurposes {
+		if purpose == known {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateConsentRequest checks a trimmed consent request and returns a
+// *ValidationError describing every invalid field
+func ValidateConsentRequest(request models.ConsentRequest, now time.Time) error {
+	fields := map[string]string{}
+
+	if request.NationalID == "" && request.PassportID == "" {
+		fields["national_id"] = "or passport_id is required"
+	} else if request.NationalID != "" && !ValidateThaiNationalID(request.NationalID) {
+		fields["national_id"] = "is not a valid Thai national ID"
+	}
+	if request.RequestingHospital == "" {
+		fields["requesting_hospital"] = "is required"
+	}
+	if !IsValidConsentPurpose(request.Purpose) {
+		fields["purpose"] = "must be one of treatment, billing or research"
+	}
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(now) {
+		fields["expires_at"] = "must be in the future"
+	}
+
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// ConsentRequiredError is returned instead of contacting a hospital system
+// when the patient has not consented to the lookup
+type ConsentRequiredError struct {
+	Hospital string
+	Reason   string
+}
+
+func (e *ConsentRequiredError) Error() string {
+	return fmt.Sprintf("consent required to look up %s: %s", e.Hospital, e.Reason)
+}
+
+// ConsentStore answers whether a patient consented to a lookup
+type ConsentStore interface {
+	HasConsent(ctx context.Context, nationalID, passportID, requestingHospital, purpose string, at time.Time) (bool, error)
+}
+
+// SQLConsentStore keeps consents in the patient_consents table
+type SQLConsentStore struct {
+	DB *sql.DB
+}
+
+func NewSQLConsentStore(db *sql.DB) *SQLConsentStore {
+	return &SQLConsentStore{DB: db}
+}
+
+// HasConsent reports whether a consent matching either identifier was granted
+// to requestingHospital for purpose and is neither expired nor revoked at at.
+// Pass one identifier to check the consent of exactly that identifier.
+func (s *SQLConsentStore) HasConsent(ctx context.Context, nationalID, passportID, requestingHospital, purpose string, at time.Time) (bool, error) {
+	var found bool
+	err := s.DB.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM patient_consents WHERE (national_id = $1 OR passport_id = $2) AND requesting_hospital = $3 AND purpose = $4 AND revoked_at IS NULL AND granted_at <= $5 AND (expires_at IS NULL OR expires_at > $5))",
+		nullString(nationalID), nullString(passportID), requestingHospital, purpose, at).Scan(&found)
+	return found, err
+}
+
+func nullString(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+type consentContextKey struct{}
+
+type consentRequest struct {
+	hospital string
+	purpose  string
+}
+
+// WithConsentPurpose marks lookups made with ctx as made by requestingHospital for purpose
+func WithConsentPurpose(ctx context.Context, requestingHospital, purpose string) context.Context {
+	return context.WithValue(ctx, consentContextKey{}, consentRequest{hospital: requestingHospital, purpose: purpose})
+}
+
+// ConsentHospitalClient only lets a lookup reach the hospital system when the
+// patient consented to it. The patient must be identified by national ID or
+// passport ID, since consent cannot be checked for a search by name. Every
+// identifier in the lookup needs its own consent, as adapters pick which one
+// they send, and only patients carrying a consented identifier are returned.
+type ConsentHospitalClient struct {
+	Hospital string
+	Next     HospitalClient
+	Consents ConsentStore
+}
+
+func NewConsentHospitalClient(hospital string, next HospitalClient, consents ConsentStore) *ConsentHospitalClient {
+	return &ConsentHospitalClient{Hospital: hospital, Next: next, Consents: consents}
+}
+
+func (c *ConsentHospitalClient) SearchPatients(ctx context.Context, query models.PatientSearchRequest) ([]models.Patient, error) {
+	request, ok := ctx.Value(consentContextKey{}).(consentRequest)
+	if !ok {
+		return nil, &ConsentRequiredError{Hospital: c.Hospital, Reason: "the lookup has no purpose"}
+	}
+	if request.purpose == ConsentPurposeEmergency {
+		return c.Next.SearchPatients(ctx, query)
+	}
+	if query.NationalID == "" && query.PassportID == "" {
+		return nil, &ConsentRequiredError{Hospital: c.Hospital, Reason: "a national ID or passport ID is required to check consent"}
+	}
+
+	now := time.Now()
+	identifiers := [][2]string{{query.NationalID, ""}, {"", query.PassportID}}
+	for _, identifier := range identifiers {
+		if identifier == [2]string{} {
+			continue
+		}
+		consented, err := c.Consents.HasConsent(ctx, identifier[0], identifier[1], request.hospital, request.purpose, now)
+		if err != nil {
+			return nil, err
+		}
+		if !consented {
+			return nil, &ConsentRequiredError{Hospital: c.Hospital, Reason: fmt.Sprintf("the patient has not consented to %s lookups by %s", request.purpose, request.hospital)}
+		}
+	}
+
+	patients, err := c.Next.SearchPatients(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	consented := make([]models.Patient, 0, len(patients))
+	for _, patient := range patients {
+		if (query.NationalID != "" && patient.NationalID == query.NationalID) || (query.PassportID != "" && patient.PassportID == query.PassportID) {
+			consented = append(consented, patient)
+		}
+	}
+	if len(consented) == 0 {
+		return nil, &ConsentRequiredError{Hospital: c.Hospital, Reason: "the hospital returned no patient with a consented identifier"}
+	}
+	return consented, nil
+}
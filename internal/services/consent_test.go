@@ -0,0 +1,100 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryConsentStore grants the consents listed as national ID or passport ID,
+// requesting hospital and purpose
+type memoryConsentStore map[[3]string]bool
+
+func (s memoryConsentStore) HasConsent(ctx context.Context, nationalID, passportID, requestingHospital, purpose string, at time.Time) (bool, error) {
+	return s[[3]string{nationalID, requestingHospital, purpose}] || s[[3]string{passportID, requestingHospital, purpose}], nil
+}
+
+func TestConsentHospitalClient(t *testing.T) {
+	upstream := &countingHospitalClient{patients: []models.Patient{{NationalID: "1101500234567", PatientHN: "HN-00123"}}}
+	registry := services.NewHospitalRegistry()
+	registry.Register("Hospital A", upstream)
+	registry.RequireConsent(memoryConsentStore{
+		{"1101500234567", "Hospital A", services.ConsentPurposeTreatment}: true,
+		{"AA1234567", "Hospital A", services.ConsentPurposeTreatment}:     true,
+	})
+	client, ok := registry.Client("hospital a")
+	require.True(t, ok)
+
+	byID := models.PatientSearchRequest{NationalID: "1101500234567"}
+	treatment := services.WithConsentPurpose(context.Background(), "Hospital A", services.ConsentPurposeTreatment)
+
+	patients, err := client.SearchPatients(treatment, byID)
+	require.NoError(t, err)
+	assert.Len(t, patients, 1)
+
+	tests := []struct {
+		name  string
+		ctx   context.Context
+		query models.PatientSearchRequest
+	}{
+		{name: "lookups without a purpose", ctx: context.Background(), query: byID},
+		{name: "searches by name", ctx: treatment, query: models.PatientSearchRequest{LastName: "Meesuk"}},
+		{name: "other purposes", ctx: services.WithConsentPurpose(context.Background(), "Hospital A", services.ConsentPurposeResearch), query: byID},
+		{name: "other requesting hospitals", ctx: services.WithConsentPurpose(context.Background(), "Hospital B", services.ConsentPurposeTreatment), query: byID},
+		{name: "an unconsented national ID next to a consented passport ID", ctx: treatment, query: models.PatientSearchRequest{NationalID: "3100600123458", PassportID: "AA1234567"}},
+	}
+	for _, tt := range tests {
+		t.Run("refuses "+tt.name, func(t *testing.T) {
+			_, err := client.SearchPatients(tt.ctx, tt.query)
+			var consentErr *services.ConsentRequiredError
+			require.True(t, errors.As(err, &consentErr))
+			assert.Equal(t, "Hospital A", consentErr.Hospital)
+		})
+	}
+	assert.Equal(t, 1, upstream.calls, "refused lookups never reach the hospital system")
+
+	emergency := services.WithConsentPurpose(context.Background(), "Hospital B", services.ConsentPurposeEmergency)
+	patients, err = client.SearchPatients(emergency, byID)
+	require.NoError(t, err, "emergency access skips consent")
+	assert.Len(t, patients, 1)
+
+	// The hospital answers a consented passport ID with a patient who does not carry it
+	_, err = client.SearchPatients(treatment, models.PatientSearchRequest{PassportID: "AA1234567"})
+	var consentErr *services.ConsentRequiredError
+	assert.True(t, errors.As(err, &consentErr), "patients without a consented identifier are dropped")
+}
+
+func TestSQLConsentStore(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM patient_consents WHERE \\(national_id = \\$1 OR passport_id = \\$2\\) AND requesting_hospital = \\$3 AND purpose = \\$4 AND revoked_at IS NULL AND granted_at <= \\$5 AND \\(expires_at IS NULL OR expires_at > \\$5\\)\\)").
+		WithArgs("1101500234567", nil, "Hospital A", "treatment", now).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	found, err := services.NewSQLConsentStore(db).HasConsent(context.Background(), "1101500234567", "", "Hospital A", "treatment", now)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestValidateConsentRequest(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Hour)
+
+	assert.NoError(t, services.ValidateConsentRequest(models.ConsentRequest{PassportID: "AA1234567", RequestingHospital: "Hospital A", Purpose: "billing"}, now))
+
+	err := services.ValidateConsentRequest(models.ConsentRequest{NationalID: "1101500234568", Purpose: "marketing", ExpiresAt: &past}, now)
+	var validationErr *services.ValidationError
+	require.True(t, errors.As(err, &validationErr))
+	assert.Len(t, validationErr.Fields, 4)
+}
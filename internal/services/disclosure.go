@@ -0,0 +1,196 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/roasted99/hospital-middleware/internal/config"
+	"github.com/roasted99/hospital-middleware/internal/models"
+)
+
+// Sensitive patient fields covered by the disclosure policy
+const (
+	FieldNationalID  = "national_id"
+	FieldPassportID  = "passport_id"
+	FieldPhoneNumber = "phone_number"
+	FieldEmail       = "email"
+)
+
+var disclosureFields = []string{FieldNationalID, FieldPassportID, FieldPhoneNumber, FieldEmail}
+
+// Disclosure levels of a sensitive field
+const (
+	DisclosureFull = "full"
+	// Only the last 4 characters are shown, e.g. *********4564
+	DisclosureLast4 = "last4"
+	// The value is replaced by ***; emails keep their domain
+	DisclosureMask = "mask"
+	// The value is returned empty
+	DisclosureOmit = "omit"
+)
+
+// DisclosureRoleAPIKey is the role API key requests have in the disclosure policy
+const DisclosureRoleAPIKey = "api_key"
+
+// defaultDisclosure lists the fields each role does not see in full. Roles
+// missing here, like auditors, see none of the sensitive fields.
+var defaultDisclosure = map[string]map[string]string{
+	RoleAdmin:             {},
+	RoleDoctor:            {},
+	RoleNurse:             {},
+	RoleRegistrationClerk: {FieldNationalID: DisclosureLast4, FieldPassportID: DisclosureLast4},
+	DisclosureRoleAPIKey: {
+		FieldNationalID: DisclosureLast4, FieldPassportID: DisclosureLast4, FieldPhoneNumber: DisclosureLast4, FieldEmail: DisclosureMask,
+	},
+}
+
+// DisclosurePolicy decides how much of each sensitive patient field a role
+// sees. Configured rules of the caller's hospital take precedence over the
+// rules for every hospital, which take precedence over the defaults.
+type DisclosurePolicy struct {
+	rules     map[string]map[string]string
+	hospitals map[string]map[string]map[string]string
+}
+
+// NewDisclosurePolicy checks every configured role, field and level
+func NewDisclosurePolicy(cfg config.DisclosureConfig) (*DisclosurePolicy, error) {
+	if err := validateDisclosureRules(cfg.Rules); err != nil {
+		return nil, fmt.Errorf("PII_DISCLOSURE: %w", err)
+	}
+	policy := &DisclosurePolicy{rules: cfg.Rules, hospitals: make(map[string]map[string]map[string]string)}
+	for hospital, rules := range cfg.Hospitals {
+		if err := validateDisclosureRules(rules); err != nil {
+			return nil, fmt.Errorf("%s_PII_DISCLOSURE: %w", config.HospitalEnvPrefix(hospital), err)
+		}
+		policy.hospitals[registryKey(hospital)] = rules
+	}
+	return policy, nil
+}
+
+func validateDisclosureRules(rules map[string]map[string]string) error {
+	for role, fields := range rules {
+		if role != DisclosureRoleAPIKey && !IsValidRole(role) {
+			return fmt.Errorf("unknown role %q", role)
+		}
+		for field, level := range fields {
+			if !isDisclosureField(field) {
+				return fmt.Errorf("unknown field %q for %s", field, role)
+			}
+			if level != DisclosureFull && level != DisclosureLast4 && level != DisclosureMask && level != DisclosureOmit {
+				return fmt.Errorf("%s.%s must be full, last4, mask or omit", role, field)
+			}
+		}
+	}
+	return nil
+}
+
+func isDisclosureField(field string) bool {
+	for _, known := range disclosureFields {
+		if field == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Level returns the disclosure level of field for staff of hospital with role
+func (p *DisclosurePolicy) Level(hospital, role, field string) string {
+	if level, ok := p.hospitals[registryKey(hospital)][role][field]; ok {
+		return level
+	}
+	if level, ok := p.rules[role][field]; ok {
+		return level
+	}
+	defaults, ok := defaultDisclosure[role]
+	if !ok {
+		return DisclosureOmit
+	}
+	if level, ok := defaults[field]; ok {
+		return level
+	}
+	return DisclosureFull
+}
+
+// Disclose returns a copy of patient with its sensitive fields reduced to what
+// staff of hospital with role may see
+func (p *DisclosurePolicy) Disclose(hospital, role string, patient models.Patient) models.Patient {
+	patient.NationalID = discloseValue(p.Level(hospital, role, FieldNationalID), patient.NationalID)
+	patient.PassportID = discloseValue(p.Level(hospital, role, FieldPassportID), patient.PassportID)
+	patient.PhoneNumber = discloseValue(p.Level(hospital, role, FieldPhoneNumber), patient.PhoneNumber)
+	patient.Email = discloseValue(p.Level(hospital, role, FieldEmail), patient.Email)
+	return patient
+}
+
+// DiscloseCriteria returns a copy of audit criteria whose sensitive fields are
+// reduced like those of a patient. Omitted values are left empty, so the
+// criterion still shows that it was used.
+func (p *DisclosurePolicy) DiscloseCriteria(hospital, role string, criteria map[string]string) map[string]string {
+	disclosed := make(map[string]string, len(criteria))
+	for name, value := range criteria {
+		if isDisclosureField(name) {
+			value = discloseValue(p.Level(hospital, role, name), value)
+		}
+		disclosed[name] = value
+	}
+	return disclosed
+}
+
+// DisclosePatientIDs reduces audit patient IDs that carry an identifier, like
+// "national_id:1101500234564" or a federated "Hospital B:passport_id:AA1234567",
+// to what the reader may see of that field. Other IDs are returned unchanged.
+func (p *DisclosurePolicy) DisclosePatientIDs(hospital, role string, ids []string) []string {
+	disclosed := make([]string, len(ids))
+	for i, id := range ids {
+		for _, field := range []string{FieldNationalID, FieldPassportID} {
+			prefix := field + ":"
+			at := strings.Index(id, prefix)
+			if at == 0 || (at > 0 && id[at-1] == ':') {
+				end := at + len(prefix)
+				id = id[:end] + discloseValue(p.Level(hospital, role, field), id[end:])
+				break
+			}
+		}
+		disclosed[i] = id
+	}
+	return disclosed
+}
+
+func discloseValue(level, value string) string {
+	if value == "" {
+		return value
+	}
+	switch level {
+	case DisclosureFull:
+		return value
+	case DisclosureLast4:
+		runes := []rune(value)
+		if len(runes) <= 4 {
+			return strings.Repeat("*", len(runes))
+		}
+		return strings.Repeat("*", len(runes)-4) + string(runes[len(runes)-4:])
+	case DisclosureMask:
+		if at := strings.LastIndex(value, "@"); at >= 0 {
+			return "***" + value[at:]
+		}
+		return "***"
+	default:
+		return ""
+	}
+}
+
+var currentDisclosurePolicy atomic.Pointer[DisclosurePolicy]
+
+// SetDisclosurePolicy installs the policy applied to patient responses
+func SetDisclosurePolicy(policy *DisclosurePolicy) {
+	currentDisclosurePolicy.Store(policy)
+}
+
+// CurrentDisclosurePolicy returns the policy installed with
+// SetDisclosurePolicy, or the default policy
+func CurrentDisclosurePolicy() *DisclosurePolicy {
+	if policy := currentDisclosurePolicy.Load(); policy != nil {
+		return policy
+	}
+	return &DisclosurePolicy{}
+}
@@ -0,0 +1,89 @@
+package services_test
+
+import (
+	"testing"
+
+	"github.com/roasted99/hospital-middleware/internal/config"
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDisclosurePolicy(t *testing.T) {
+	policy, err := services.NewDisclosurePolicy(config.DisclosureConfig{
+		Rules: map[string]map[string]string{services.RoleNurse: {services.FieldEmail: services.DisclosureMask}},
+		Hospitals: map[string]map[string]map[string]string{
+			"Hospital B": {services.RoleNurse: {services.FieldEmail: services.DisclosureFull, services.FieldPassportID: services.DisclosureOmit}},
+		},
+	})
+	require.NoError(t, err)
+
+	patient := models.Patient{PatientHN: "HN-00123", NationalID: "1101500234564", PassportID: "AA1234567", PhoneNumber: "0812345678", Email: "jai@gmail.com"}
+
+	tests := []struct {
+		name     string
+		hospital string
+		role     string
+		expected models.Patient
+	}{
+		{name: "doctors see every field", hospital: "Hospital A", role: services.RoleDoctor, expected: patient},
+		{
+			name: "clerks see the last 4 characters of IDs", hospital: "Hospital A", role: services.RoleRegistrationClerk,
+			expected: models.Patient{PatientHN: "HN-00123", NationalID: "*********4564", PassportID: "*****4567", PhoneNumber: "0812345678", Email: "jai@gmail.com"},
+		},
+		{
+			name: "rules for every hospital override the defaults", hospital: "Hospital A", role: services.RoleNurse,
+			expected: models.Patient{PatientHN: "HN-00123", NationalID: "1101500234564", PassportID: "AA1234567", PhoneNumber: "0812345678", Email: "***@gmail.com"},
+		},
+		{
+			name: "hospital rules override the rules for every hospital", hospital: "hospital b", role: services.RoleNurse,
+			expected: models.Patient{PatientHN: "HN-00123", NationalID: "1101500234564", PhoneNumber: "0812345678", Email: "jai@gmail.com"},
+		},
+		{name: "unknown roles see no sensitive field", hospital: "Hospital A", role: "", expected: models.Patient{PatientHN: "HN-00123"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, policy.Disclose(tt.hospital, tt.role, patient))
+		})
+	}
+}
+
+func TestDiscloseCriteria(t *testing.T) {
+	policy, err := services.NewDisclosurePolicy(config.DisclosureConfig{})
+	require.NoError(t, err)
+
+	criteria := map[string]string{"national_id": "1101500234564", "email": "jai@gmail.com", "last_name": "Meesuk"}
+	assert.Equal(t, map[string]string{"national_id": "*********4564", "email": "***@gmail.com", "last_name": "Meesuk"},
+		policy.DiscloseCriteria("Hospital A", services.DisclosureRoleAPIKey, criteria))
+	assert.Equal(t, map[string]string{"national_id": "", "email": "", "last_name": "Meesuk"},
+		policy.DiscloseCriteria("Hospital A", services.RoleAuditor, criteria))
+	assert.Equal(t, "1101500234564", criteria["national_id"])
+}
+
+func TestDisclosePatientIDs(t *testing.T) {
+	policy, err := services.NewDisclosurePolicy(config.DisclosureConfig{})
+	require.NoError(t, err)
+
+	ids := []string{"7", "hn:HN-00123", "national_id:1101500234564", "Hospital B:passport_id:AA1234567"}
+	assert.Equal(t, []string{"7", "hn:HN-00123", "national_id:*********4564", "Hospital B:passport_id:*****4567"},
+		policy.DisclosePatientIDs("Hospital A", services.RoleRegistrationClerk, ids))
+	assert.Equal(t, []string{"7", "hn:HN-00123", "national_id:", "Hospital B:passport_id:"},
+		policy.DisclosePatientIDs("Hospital A", services.RoleAuditor, ids))
+	assert.Equal(t, "national_id:1101500234564", ids[2])
+}
+
+func TestNewDisclosurePolicyRejectsInvalidRules(t *testing.T) {
+	tests := map[string]map[string]map[string]string{
+		"unknown role":  {"janitor": {services.FieldEmail: services.DisclosureOmit}},
+		"unknown field": {services.RoleNurse: {"gender": services.DisclosureOmit}},
+		"unknown level": {services.RoleNurse: {services.FieldEmail: "hash"}},
+	}
+	for name, rules := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := services.NewDisclosurePolicy(config.DisclosureConfig{Hospitals: map[string]map[string]map[string]string{"Hospital A": rules}})
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "HOSPITAL_A_PII_DISCLOSURE")
+		})
+	}
+}
@@ -1,7 +1,9 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -11,19 +13,41 @@ import (
 	"github.com/roasted99/hospital-middleware/internal/models"
 )
 
+// HospitalClient searches a hospital system for patients matching the request
 type HospitalClient interface {
-	SearchPatient(patientID string) (*models.Patient, error)
+	SearchPatients(ctx context.Context, query models.PatientSearchRequest) ([]models.Patient, error)
+}
+
+// ErrPatientNotFound is returned when the hospital system has no matching patient
+var ErrPatientNotFound = errors.New("patient not found")
+
+// UpstreamError reports a failure returned by a hospital system
+type UpstreamError struct {
+	Hospital   string
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *UpstreamError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("%s: upstream error %s (HTTP %d): %s", e.Hospital, e.Code, e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("%s: upstream error (HTTP %d): %s", e.Hospital, e.StatusCode, e.Message)
 }
 
 type HospitalAClient struct {
+	Hospital   string
 	BaseURL    string
 	HTTPClient *http.Client
+	Auth       UpstreamAuth
 }
 
-func NewHospitalAClient() *HospitalAClient {
+func NewHospitalAClient(cfg config.HospitalConfig) *HospitalAClient {
 	return &HospitalAClient{
-		BaseURL:    config.GetHospitalAURL(),
-		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+		Hospital:   cfg.Name,
+		BaseURL:    cfg.BaseURL,
+		HTTPClient: &http.Client{Timeout: cfg.Timeout},
 	}
 }
 
@@ -43,32 +67,70 @@ type HospitalAResponse struct {
 	Gender string `json:"gender"`
 }
 
-func (c *HospitalAClient) SearchPatient(patientID string) (*models.Patient, error) {
-	apiURL := fmt.Sprintf("%s/api/v1/patients/%s", c.BaseURL, url.PathEscape(patientID))
+// SearchPatients looks up a single patient by ID through /api/v1/patients/{id}
+// and translates any other criteria into query parameters on /api/v1/patients
+func (c *HospitalAClient) SearchPatients(ctx context.Context, query models.PatientSearchRequest) ([]models.Patient, error) {
+	searchID := query.NationalID
+	if searchID == "" {
+		searchID = query.PassportID
+	}
 
-	req, err := http.NewRequest("GET", apiURL, nil)
-	if err != nil {
+	idOnly := query == models.PatientSearchRequest{NationalID: query.NationalID} || query == models.PatientSearchRequest{PassportID: query.PassportID}
+	if searchID != "" && idOnly {
+		var hospitalAResponse HospitalAResponse
+		if err := c.get(ctx, fmt.Sprintf("%s/api/v1/patients/%s", c.BaseURL, url.PathEscape(searchID)), &hospitalAResponse); err != nil {
+			return nil, err
+		}
+		return []models.Patient{c.toPatient(hospitalAResponse)}, nil
+	}
+
+	params := url.Values{}
+	setParam(params, "national_id", query.NationalID)
+	setParam(params, "passport_id", query.PassportID)
+	setParam(params, "patient_hn", query.PatientHN)
+	setParam(params, "first_name", query.FirstName)
+	setParam(params, "middle_name", query.MiddleName)
+	setParam(params, "last_name", query.LastName)
+	setParam(params, "date_of_birth", query.DateOfBirth)
+	setParam(params, "phone_number", query.PhoneNumber)
+	setParam(params, "email", query.Email)
+
+	var hospitalAResponses []HospitalAResponse
+	if err := c.get(ctx, fmt.Sprintf("%s/api/v1/patients?%s", c.BaseURL, params.Encode()), &hospitalAResponses); err != nil {
 		return nil, err
 	}
+	if len(hospitalAResponses) == 0 {
+		return nil, ErrPatientNotFound
+	}
 
-	req.Header.Set("Authorization", "Bearer "+config.GetJWTSecret())
+	patients := make([]models.Patient, 0, len(hospitalAResponses))
+	for _, hospitalAResponse := range hospitalAResponses {
+		patients = append(patients, c.toPatient(hospitalAResponse))
+	}
+	return patients, nil
+}
 
-	resp, err := c.HTTPClient.Do(req)
+func (c *HospitalAClient) get(ctx context.Context, apiURL string, target interface{}) error {
+	resp, err := doUpstream(ctx, c.HTTPClient, c.Auth, func() (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	})
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrPatientNotFound
+	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to search patient: %s", resp.Status)
+		return &UpstreamError{Hospital: c.Hospital, StatusCode: resp.StatusCode, Message: resp.Status}
 	}
 
-	var hospitalAResponse HospitalAResponse
-	if err := json.NewDecoder(resp.Body).Decode(&hospitalAResponse); err != nil {
-		return nil, err
-	}
+	return json.NewDecoder(resp.Body).Decode(target)
+}
 
-	patient := &models.Patient{
+func (c *HospitalAClient) toPatient(hospitalAResponse HospitalAResponse) models.Patient {
+	return models.Patient{
 		FirstNameTH: hospitalAResponse.FirstNameTH,
 		MiddleNameTH: hospitalAResponse.MiddleNameTH,
 		LastNameTH: hospitalAResponse.LastNameTH,
@@ -82,8 +144,12 @@ func (c *HospitalAClient) SearchPatient(patientID string) (*models.Patient, erro
 		PhoneNumber: hospitalAResponse.PhoneNumber,
 		Email: hospitalAResponse.Email,
 		Gender: hospitalAResponse.Gender,
-		Hospital: "Hospital A",
+		Hospital: c.Hospital,
 	}
+}
 
-	return patient, nil
-}
\ No newline at end of file
+func setParam(params url.Values, key, value string) {
+	if value != "" {
+		params.Set(key, value)
+	}
+}
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/roasted99/hospital-middleware/internal/config"
+	"github.com/roasted99/hospital-middleware/internal/models"
+)
+
+// HospitalBClient looks patients up through Hospital B's XML patient service
+type HospitalBClient struct {
+	Hospital   string
+	BaseURL    string
+	HTTPClient *http.Client
+	Auth       UpstreamAuth
+}
+
+func NewHospitalBClient(cfg config.HospitalConfig) *HospitalBClient {
+	return &HospitalBClient{
+		Hospital:   cfg.Name,
+		BaseURL:    cfg.BaseURL,
+		HTTPClient: &http.Client{Timeout: cfg.Timeout},
+	}
+}
+
+// HospitalBResponse is the XML document returned by Hospital B
+type HospitalBResponse struct {
+	XMLName  xml.Name           `xml:"PatientLookupResponse"`
+	Status   string             `xml:"Status"`
+	Error    *HospitalBError    `xml:"Error"`
+	Patients []HospitalBPatient `xml:"Patient"`
+}
+
+type HospitalBError struct {
+	Code    string `xml:"code,attr"`
+	Message string `xml:",chardata"`
+}
+
+type HospitalBPatient struct {
+	HN         string          `xml:"hn,attr"`
+	NationalID string          `xml:"NationalID"`
+	PassportNo string          `xml:"PassportNo"`
+	Names      []HospitalBName `xml:"Name"`
+	BirthDate  string          `xml:"BirthDate"`
+	Sex        string          `xml:"Sex"`
+	Phone      string          `xml:"Phone"`
+	Email      string          `xml:"Email"`
+}
+
+type HospitalBName struct {
+	Lang   string `xml:"lang,attr"`
+	First  string `xml:"First"`
+	Middle string `xml:"Middle"`
+	Last   string `xml:"Last"`
+}
+
+// SearchPatients sends the criteria as query parameters, added to any query
+// the base URL already has. Hospital B keys patients by HN, which is sent as
+// its own hn parameter. National ID and passport number share Hospital B's id
+// parameter, so when both are given the national ID is sent and the results
+// are narrowed to the passport number here.
+func (c *HospitalBClient) SearchPatients(ctx context.Context, query models.PatientSearchRequest) ([]models.Patient, error) {
+	apiURL, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s base URL: %w", c.Hospital, err)
+	}
+
+	params := apiURL.Query()
+	setParam(params, "hn", query.PatientHN)
+	setParam(params, "id", query.NationalID)
+	if query.NationalID == "" {
+		setParam(params, "id", query.PassportID)
+	}
+	setParam(params, "first_name", query.FirstName)
+	setParam(params, "middle_name", query.MiddleName)
+	setParam(params, "last_name", query.LastName)
+	setParam(params, "birth_date", query.DateOfBirth)
+	setParam(params, "phone", query.PhoneNumber)
+	setParam(params, "email", query.Email)
+
+	apiURL.RawQuery = params.Encode()
+
+	resp, err := doUpstream(ctx, c.HTTPClient, c.Auth, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", apiURL.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/xml")
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var hospitalBResponse HospitalBResponse
+	decodeErr := xml.Unmarshal(body, &hospitalBResponse)
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrPatientNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		upstreamErr := &UpstreamError{Hospital: c.Hospital, StatusCode: resp.StatusCode, Message: resp.Status}
+		if decodeErr == nil && hospitalBResponse.Error != nil {
+			upstreamErr.Code = hospitalBResponse.Error.Code
+			upstreamErr.Message = strings.TrimSpace(hospitalBResponse.Error.Message)
+		}
+		return nil, upstreamErr
+	}
+	if decodeErr != nil {
+		return nil, &UpstreamError{Hospital: c.Hospital, StatusCode: resp.StatusCode, Message: "invalid XML response: " + decodeErr.Error()}
+	}
+
+	if hospitalBResponse.Error != nil {
+		if strings.EqualFold(hospitalBResponse.Error.Code, "NOT_FOUND") {
+			return nil, ErrPatientNotFound
+		}
+		return nil, &UpstreamError{
+			Hospital:   c.Hospital,
+			StatusCode: resp.StatusCode,
+			Code:       hospitalBResponse.Error.Code,
+			Message:    strings.TrimSpace(hospitalBResponse.Error.Message),
+		}
+	}
+	if len(hospitalBResponse.Patients) == 0 {
+		return nil, ErrPatientNotFound
+	}
+
+	patients := make([]models.Patient, 0, len(hospitalBResponse.Patients))
+	for _, record := range hospitalBResponse.Patients {
+		patient, err := c.toPatient(record)
+		if err != nil {
+			return nil, err
+		}
+		if query.NationalID != "" && query.PassportID != "" && patient.PassportID != query.PassportID {
+			continue
+		}
+		if query.PatientHN != "" && patient.PatientHN != query.PatientHN {
+			continue
+		}
+		patients = append(patients, patient)
+	}
+	if len(patients) == 0 {
+		return nil, ErrPatientNotFound
+	}
+	return patients, nil
+}
+
+func (c *HospitalBClient) toPatient(record HospitalBPatient) (models.Patient, error) {
+	patient := models.Patient{
+		PatientHN:   strings.TrimSpace(record.HN),
+		NationalID:  strings.TrimSpace(record.NationalID),
+		PassportID:  strings.TrimSpace(record.PassportNo),
+		PhoneNumber: strings.TrimSpace(record.Phone),
+		Email:       strings.TrimSpace(record.Email),
+		Gender:      hospitalBGender(record.Sex),
+		Hospital:    c.Hospital,
+	}
+
+	for _, name := range record.Names {
+		switch strings.ToLower(strings.TrimSpace(name.Lang)) {
+		case "th":
+			patient.FirstNameTH = strings.TrimSpace(name.First)
+			patient.MiddleNameTH = strings.TrimSpace(name.Middle)
+			patient.LastNameTH = strings.TrimSpace(name.Last)
+		case "en":
+			patient.FirstNameEN = strings.TrimSpace(name.First)
+			patient.MiddleNameEN = strings.TrimSpace(name.Middle)
+			patient.LastNameEN = strings.TrimSpace(name.Last)
+		}
+	}
+
+	if birthDate := strings.TrimSpace(record.BirthDate); birthDate != "" {
+		dob, err := time.Parse("2006-01-02", birthDate)
+		if err != nil {
+			return patient, &UpstreamError{Hospital: c.Hospital, StatusCode: http.StatusOK, Message: "invalid birth date " + birthDate}
+		}
+		patient.DateOfBirth = dob
+	}
+
+	return patient, nil
+}
+
+// hospitalBGender maps Hospital B sex codes (1 = male, 2 = female) onto M/F
+func hospitalBGender(code string) string {
+	switch strings.ToUpper(strings.TrimSpace(code)) {
+	case "1", "M", "MALE":
+		return "M"
+	case "2", "F", "FEMALE":
+		return "F"
+	default:
+		return ""
+	}
+}
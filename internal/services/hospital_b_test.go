@@ -0,0 +1,170 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/roasted99/hospital-middleware/internal/config"
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const hospitalBPatientXML = `<?xml version="1.0" encoding="UTF-8"?>
+<PatientLookupResponse>
+  <Status>OK</Status>
+  <Patient hn="HN-B-0042">
+    <NationalID>1101500234567</NationalID>
+    <PassportNo></PassportNo>
+    <Name lang="th"><First>สมชาย</First><Middle>ใจดี</Middle><Last>มีสุข</Last></Name>
+    <Name lang="en"><First>Somchai</First><Middle>Jai Dee</Middle><Last>Meesuk</Last></Name>
+    <BirthDate>1980-08-20</BirthDate>
+    <Sex>1</Sex>
+    <Phone>0812345678</Phone>
+    <Email>jai@gmail.com</Email>
+  </Patient>
+</PatientLookupResponse>`
+
+func newHospitalBServer(t *testing.T, status int, body string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "1101500234567", r.URL.Query().Get("id"))
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHospitalBClientSearchPatient(t *testing.T) {
+	server := newHospitalBServer(t, http.StatusOK, hospitalBPatientXML)
+	client := services.NewHospitalBClient(config.HospitalConfig{Name: "Hospital B", BaseURL: server.URL + "/patient-lookup"})
+
+	patients, err := client.SearchPatients(context.Background(), models.PatientSearchRequest{NationalID: "1101500234567"})
+	require.NoError(t, err)
+	require.Len(t, patients, 1)
+
+	patient := patients[0]
+	assert.Equal(t, "HN-B-0042", patient.PatientHN)
+	assert.Equal(t, "1101500234567", patient.NationalID)
+	assert.Equal(t, "สมชาย", patient.FirstNameTH)
+	assert.Equal(t, "ใจดี", patient.MiddleNameTH)
+	assert.Equal(t, "มีสุข", patient.LastNameTH)
+	assert.Equal(t, "Somchai", patient.FirstNameEN)
+	assert.Equal(t, "Jai Dee", patient.MiddleNameEN)
+	assert.Equal(t, "Meesuk", patient.LastNameEN)
+	assert.Equal(t, time.Date(1980, 8, 20, 0, 0, 0, 0, time.UTC), patient.DateOfBirth)
+	assert.Equal(t, "M", patient.Gender)
+	assert.Equal(t, "0812345678", patient.PhoneNumber)
+	assert.Equal(t, "jai@gmail.com", patient.Email)
+	assert.Equal(t, "Hospital B", patient.Hospital)
+}
+
+func TestHospitalBClientKeepsBaseQueryAndBothIdentifiers(t *testing.T) {
+	var query map[string][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write([]byte(hospitalBPatientXML))
+	}))
+	t.Cleanup(server.Close)
+	client := services.NewHospitalBClient(config.HospitalConfig{Name: "Hospital B", BaseURL: server.URL + "/patient-lookup?site=main"})
+
+	patients, err := client.SearchPatients(context.Background(), models.PatientSearchRequest{NationalID: "1101500234567", LastName: "Meesuk"})
+	require.NoError(t, err)
+	assert.Len(t, patients, 1)
+	assert.Equal(t, []string{"main"}, query["site"])
+	assert.Equal(t, []string{"1101500234567"}, query["id"])
+	assert.Equal(t, []string{"Meesuk"}, query["last_name"])
+
+	// The passport number cannot be sent with the national ID, so a patient
+	// without it is not returned
+	_, err = client.SearchPatients(context.Background(), models.PatientSearchRequest{NationalID: "1101500234567", PassportID: "AB123456"})
+	assert.True(t, errors.Is(err, services.ErrPatientNotFound))
+}
+
+func TestHospitalBClientSearchesByHN(t *testing.T) {
+	var query map[string][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write([]byte(hospitalBPatientXML))
+	}))
+	t.Cleanup(server.Close)
+	client := services.NewHospitalBClient(config.HospitalConfig{Name: "Hospital B", BaseURL: server.URL + "/patient-lookup"})
+
+	patients, err := client.SearchPatients(context.Background(), models.PatientSearchRequest{PatientHN: "HN-B-0042"})
+	require.NoError(t, err)
+	require.Len(t, patients, 1)
+	assert.Equal(t, "HN-B-0042", patients[0].PatientHN)
+	assert.Equal(t, []string{"HN-B-0042"}, query["hn"])
+	assert.Empty(t, query["id"])
+
+	// A patient filed under another HN is not returned
+	_, err = client.SearchPatients(context.Background(), models.PatientSearchRequest{PatientHN: "HN-B-0043"})
+	assert.True(t, errors.Is(err, services.ErrPatientNotFound))
+}
+
+func TestHospitalBClientErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		body         string
+		wantNotFound bool
+		wantCode     string
+		wantStatus   int
+	}{
+		{
+			name:         "HTTP not found",
+			status:       http.StatusNotFound,
+			body:         `<PatientLookupResponse><Status>ERROR</Status><Error code="NOT_FOUND">no patient</Error></PatientLookupResponse>`,
+			wantNotFound: true,
+		},
+		{
+			name:         "Not found reported in document",
+			status:       http.StatusOK,
+			body:         `<PatientLookupResponse><Status>ERROR</Status><Error code="NOT_FOUND">no patient</Error></PatientLookupResponse>`,
+			wantNotFound: true,
+		},
+		{
+			name:       "Upstream fault with error code",
+			status:     http.StatusInternalServerError,
+			body:       `<PatientLookupResponse><Status>ERROR</Status><Error code="DB_DOWN">registry unavailable</Error></PatientLookupResponse>`,
+			wantCode:   "DB_DOWN",
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "Malformed document",
+			status:     http.StatusOK,
+			body:       `<PatientLookupResponse><Patient>`,
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newHospitalBServer(t, tt.status, tt.body)
+			client := services.NewHospitalBClient(config.HospitalConfig{Name: "Hospital B", BaseURL: server.URL})
+
+			patients, err := client.SearchPatients(context.Background(), models.PatientSearchRequest{NationalID: "1101500234567"})
+			require.Error(t, err)
+			assert.Nil(t, patients)
+
+			if tt.wantNotFound {
+				assert.ErrorIs(t, err, services.ErrPatientNotFound)
+				return
+			}
+
+			var upstreamErr *services.UpstreamError
+			require.True(t, errors.As(err, &upstreamErr))
+			assert.Equal(t, "Hospital B", upstreamErr.Hospital)
+			assert.Equal(t, tt.wantStatus, upstreamErr.StatusCode)
+			assert.Equal(t, tt.wantCode, upstreamErr.Code)
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package services_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/roasted99/hospital-middleware/internal/config"
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHospitalAClientSearchPatients(t *testing.T) {
+	tests := []struct {
+		name         string
+		query        models.PatientSearchRequest
+		expectedPath string
+		expectedArgs map[string]string
+		response     interface{}
+		expectedHNs  []string
+	}{
+		{
+			name:         "National ID uses the patient resource",
+			query:        models.PatientSearchRequest{NationalID: "1101500234567"},
+			expectedPath: "/api/v1/patients/1101500234567",
+			response:     services.HospitalAResponse{PatientHN: "HN-00123", NationalID: "1101500234567"},
+			expectedHNs:  []string{"HN-00123"},
+		},
+		{
+			name:         "Passport ID uses the patient resource",
+			query:        models.PatientSearchRequest{PassportID: "AB123456"},
+			expectedPath: "/api/v1/patients/AB123456",
+			response:     services.HospitalAResponse{PatientHN: "HN-11032", PassportID: "AB123456"},
+			expectedHNs:  []string{"HN-11032"},
+		},
+		{
+			name:         "Demographic criteria become query parameters",
+			query:        models.PatientSearchRequest{FirstName: "Som", LastName: "Meesuk", DateOfBirth: "1980-08-20"},
+			expectedPath: "/api/v1/patients",
+			expectedArgs: map[string]string{"first_name": "Som", "last_name": "Meesuk", "date_of_birth": "1980-08-20"},
+			response:     []services.HospitalAResponse{{PatientHN: "HN-00123"}, {PatientHN: "HN-00456"}},
+			expectedHNs:  []string{"HN-00123", "HN-00456"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, tt.expectedPath, r.URL.Path)
+				assert.Len(t, r.URL.Query(), len(tt.expectedArgs))
+				for key, value := range tt.expectedArgs {
+					assert.Equal(t, value, r.URL.Query().Get(key))
+				}
+				json.NewEncoder(w).Encode(tt.response)
+			}))
+			defer server.Close()
+
+			client := services.NewHospitalAClient(config.HospitalConfig{Name: "Hospital A", BaseURL: server.URL})
+			patients, err := client.SearchPatients(context.Background(), tt.query)
+			require.NoError(t, err)
+
+			var hns []string
+			for _, patient := range patients {
+				assert.Equal(t, "Hospital A", patient.Hospital)
+				hns = append(hns, patient.PatientHN)
+			}
+			assert.Equal(t, tt.expectedHNs, hns)
+		})
+	}
+}
+
+func TestHospitalAClientNoMatches(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+
+	client := services.NewHospitalAClient(config.HospitalConfig{Name: "Hospital A", BaseURL: server.URL})
+	_, err := client.SearchPatients(context.Background(), models.PatientSearchRequest{LastName: "Nobody"})
+	assert.ErrorIs(t, err, services.ErrPatientNotFound)
+}
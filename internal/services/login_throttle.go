@@ -0,0 +1,83 @@
+package services
+
+import (
+	"time"
+
+	"github.com/roasted99/hospital-middleware/internal/config"
+)
+
+// LoginThrottle is the failed login state of an account or client address
+type LoginThrottle struct {
+	FailedCount  int
+	LastFailedAt time.Time
+	LockedUntil  time.Time
+}
+
+// LoginThrottlePolicy slows down repeated failed logins: every failure doubles
+// the wait before the next attempt, and MaxFailures failures lock out further
+// attempts for LockoutDuration
+type LoginThrottlePolicy struct {
+	MaxFailures     int
+	LockoutDuration time.Duration
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+}
+
+func NewLoginThrottlePolicy(cfg config.LoginThrottleConfig) LoginThrottlePolicy {
+	return LoginThrottlePolicy{
+		MaxFailures:     cfg.MaxFailures,
+		LockoutDuration: cfg.LockoutDuration,
+		BaseDelay:       cfg.BaseDelay,
+		MaxDelay:        cfg.MaxDelay,
+	}
+}
+
+// RetryAfter returns how long the next attempt has to wait; zero means it may
+// proceed
+func (p LoginThrottlePolicy) RetryAfter(throttle LoginThrottle, now time.Time) time.Duration {
+	if now.Before(throttle.LockedUntil) {
+		return throttle.LockedUntil.Sub(now)
+	}
+	if p.expired(throttle, now) || throttle.FailedCount == 0 {
+		return 0
+	}
+
+	if next := throttle.LastFailedAt.Add(p.delay(throttle.FailedCount)); now.Before(next) {
+		return next.Sub(now)
+	}
+	return 0
+}
+
+// RecordFailure returns throttle after another failed attempt at now
+func (p LoginThrottlePolicy) RecordFailure(throttle LoginThrottle, now time.Time) LoginThrottle {
+	if p.expired(throttle, now) {
+		throttle = LoginThrottle{}
+	}
+
+	throttle.FailedCount++
+	throttle.LastFailedAt = now
+	if p.MaxFailures > 0 && throttle.FailedCount >= p.MaxFailures {
+		throttle.LockedUntil = now.Add(p.LockoutDuration)
+	}
+	return throttle
+}
+
+// expired reports whether earlier failures no longer count: a lockout has run
+// its course, or nothing failed for a whole lockout period
+func (p LoginThrottlePolicy) expired(throttle LoginThrottle, now time.Time) bool {
+	if !throttle.LockedUntil.IsZero() {
+		return !now.Before(throttle.LockedUntil)
+	}
+	return !throttle.LastFailedAt.IsZero() && now.Sub(throttle.LastFailedAt) >= p.LockoutDuration
+}
+
+func (p LoginThrottlePolicy) delay(failures int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
@@ -0,0 +1,37 @@
+package services_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginThrottlePolicy(t *testing.T) {
+	policy := services.LoginThrottlePolicy{MaxFailures: 4, LockoutDuration: 15 * time.Minute, BaseDelay: time.Second, MaxDelay: 3 * time.Second}
+	now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+
+	var throttle services.LoginThrottle
+	assert.Zero(t, policy.RetryAfter(throttle, now))
+
+	// Each failure doubles the wait, up to MaxDelay
+	throttle = policy.RecordFailure(throttle, now)
+	assert.Equal(t, time.Second, policy.RetryAfter(throttle, now))
+	throttle = policy.RecordFailure(throttle, now)
+	assert.Equal(t, 2*time.Second, policy.RetryAfter(throttle, now))
+	throttle = policy.RecordFailure(throttle, now)
+	assert.Equal(t, 3*time.Second, policy.RetryAfter(throttle, now))
+	assert.Zero(t, policy.RetryAfter(throttle, now.Add(3*time.Second)))
+
+	// The fourth failure locks the account
+	throttle = policy.RecordFailure(throttle, now)
+	assert.Equal(t, 15*time.Minute, policy.RetryAfter(throttle, now))
+
+	// After the lockout the count starts over
+	later := now.Add(16 * time.Minute)
+	assert.Zero(t, policy.RetryAfter(throttle, later))
+	throttle = policy.RecordFailure(throttle, later)
+	assert.Equal(t, 1, throttle.FailedCount)
+	assert.Equal(t, time.Second, policy.RetryAfter(throttle, later))
+}
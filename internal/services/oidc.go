@@ -0,0 +1,370 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/roasted99/hospital-middleware/internal/config"
+)
+
+// OIDCIdentity is the signed-in user as described by the IdP's ID token
+type OIDCIdentity struct {
+	Issuer   string
+	Subject  string
+	Username string
+	Email    string
+	Name     string
+	// Roles holds the values of the configured role claim
+	Roles []string
+}
+
+// OIDCProvider signs staff of one hospital in with the authorization code flow
+// and PKCE. Provider metadata and keys are discovered on first use.
+type OIDCProvider struct {
+	Config     config.OIDCConfig
+	HTTPClient *http.Client
+
+	mu       sync.Mutex
+	metadata *oidcMetadata
+	keys     map[string]crypto.PublicKey
+}
+
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcTokenResponse struct {
+	IDToken   string `json:"id_token"`
+	TokenType string `json:"token_type"`
+}
+
+func NewOIDCProvider(cfg config.OIDCConfig) (*OIDCProvider, error) {
+	if cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, errors.New("OIDC requires an issuer, client ID and redirect URL")
+	}
+	if cfg.UsernameClaim == "" {
+		cfg.UsernameClaim = "preferred_username"
+	}
+	if cfg.DefaultRole == "" {
+		cfg.DefaultRole = DefaultRole
+	}
+	if !IsValidRole(cfg.DefaultRole) {
+		return nil, fmt.Errorf("unknown OIDC default role %q", cfg.DefaultRole)
+	}
+	for idpValue, role := range cfg.RoleMap {
+		if !IsValidRole(role) {
+			return nil, fmt.Errorf("OIDC role map entry %q: unknown role %q", idpValue, role)
+		}
+	}
+
+	return &OIDCProvider{
+		Config:     cfg,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// NewOIDCProviders builds the SSO provider of every configured hospital, keyed
+// like the hospital registry
+func NewOIDCProviders(cfgs []config.OIDCConfig) (map[string]*OIDCProvider, error) {
+	providers := make(map[string]*OIDCProvider)
+	for _, cfg := range cfgs {
+		provider, err := NewOIDCProvider(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure SSO for %s: %w", cfg.Hospital, err)
+		}
+		providers[registryKey(cfg.Hospital)] = provider
+	}
+	return providers, nil
+}
+
+// LookupOIDCProvider returns the SSO provider of hospital, if it has one
+func LookupOIDCProvider(providers map[string]*OIDCProvider, hospital string) (*OIDCProvider, bool) {
+	provider, ok := providers[registryKey(hospital)]
+	return provider, ok
+}
+
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	var metadata oidcMetadata
+	if err := p.getJSON(ctx, p.Config.Issuer+"/.well-known/openid-configuration", &metadata); err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+	// The issuer must match exactly so metadata from another IdP is not trusted
+	if strings.TrimSuffix(metadata.Issuer, "/") != p.Config.Issuer {
+		return nil, fmt.Errorf("OIDC discovery returned issuer %q", metadata.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, errors.New("OIDC discovery document is incomplete")
+	}
+
+	p.metadata = &metadata
+	return p.metadata, nil
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, url string, target interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+// AuthorizationURL returns where to send the user to sign in at the IdP
+func (p *OIDCProvider) AuthorizationURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.Config.ClientID)
+	query.Set("redirect_uri", p.Config.RedirectURL)
+	query.Set("scope", strings.Join(p.Config.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", "S256")
+	authURL.RawQuery = query.Encode()
+	return authURL.String(), nil
+}
+
+// Exchange redeems an authorization code and returns the identity from the
+// verified ID token. nonce must be the value sent with the authorization request.
+func (p *OIDCProvider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (OIDCIdentity, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return OIDCIdentity{}, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.Config.RedirectURL)
+	form.Set("code_verifier", codeVerifier)
+	if p.Config.ClientSecret == "" {
+		// Public clients identify themselves and rely on PKCE alone
+		form.Set("client_id", p.Config.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return OIDCIdentity{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if p.Config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.Config.ClientID), url.QueryEscape(p.Config.ClientSecret))
+	}
+
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return OIDCIdentity{}, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return OIDCIdentity{}, fmt.Errorf("token request failed: %s", resp.Status)
+	}
+
+	var tokenResponse oidcTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResponse); err != nil {
+		return OIDCIdentity{}, fmt.Errorf("invalid token response: %w", err)
+	}
+	if tokenResponse.IDToken == "" {
+		return OIDCIdentity{}, errors.New("token response has no id_token")
+	}
+
+	return p.verifyIDToken(ctx, metadata, tokenResponse.IDToken, nonce)
+}
+
+func (p *OIDCProvider) verifyIDToken(ctx context.Context, metadata *oidcMetadata, idToken, nonce string) (OIDCIdentity, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.verificationKey(ctx, metadata, kid)
+	},
+		jwt.WithValidMethods([]string{AlgRS256, AlgES256}),
+		jwt.WithIssuer(p.Config.Issuer),
+		jwt.WithAudience(p.Config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return OIDCIdentity{}, fmt.Errorf("invalid ID token: %w", err)
+	}
+
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce == "" || tokenNonce != nonce {
+		return OIDCIdentity{}, errors.New("invalid ID token: nonce mismatch")
+	}
+	// With several audiences the token must have been issued to us
+	if audience, _ := claims.GetAudience(); len(audience) > 1 {
+		if azp, _ := claims["azp"].(string); azp != p.Config.ClientID {
+			return OIDCIdentity{}, errors.New("invalid ID token: unexpected azp")
+		}
+	}
+
+	identity := OIDCIdentity{Issuer: p.Config.Issuer}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Username, _ = claims[p.Config.UsernameClaim].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["name"].(string)
+	if p.Config.RoleClaim != "" {
+		identity.Roles = stringsClaim(claims[p.Config.RoleClaim])
+	}
+
+	if identity.Subject == "" {
+		return OIDCIdentity{}, errors.New("invalid ID token: no subject")
+	}
+	if identity.Username == "" {
+		return OIDCIdentity{}, fmt.Errorf("ID token has no %s claim", p.Config.UsernameClaim)
+	}
+	return identity, nil
+}
+
+// verificationKey returns the IdP key for kid, refetching the key set once
+// when kid is unknown so IdP key rotation is picked up
+func (p *OIDCProvider) verificationKey(ctx context.Context, metadata *oidcMetadata, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	var jwks JWKS
+	if err := p.getJSON(ctx, metadata.JWKSURI, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to fetch IdP keys: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.PublicKey(); err == nil {
+			keys[jwk.KeyID] = key
+		}
+	}
+	p.keys = keys
+
+	key, ok := keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown IdP signing key %q", kid)
+	}
+	return key, nil
+}
+
+// Role maps the IdP roles of identity to a middleware role. The first value
+// with a mapping wins; without one the configured default role is used.
+func (p *OIDCProvider) Role(identity OIDCIdentity) string {
+	for _, value := range identity.Roles {
+		if role, ok := p.Config.RoleMap[value]; ok {
+			return role
+		}
+	}
+	return p.Config.DefaultRole
+}
+
+func stringsClaim(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+// PublicKey decodes an RSA or P-256 key published in a JWKS
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	decode := func(value string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+
+	switch k.KeyType {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Curve != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.KeyType)
+	}
+}
+
+// GeneratePKCEVerifier returns a random PKCE code verifier (RFC 7636)
+func GeneratePKCEVerifier() (string, error) {
+	verifier, _, err := GenerateOpaqueToken()
+	return verifier, err
+}
+
+// PKCEChallenge returns the S256 code challenge for verifier
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
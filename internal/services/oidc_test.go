@@ -0,0 +1,82 @@
+package services_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/roasted99/hospital-middleware/internal/config"
+	"github.com/roasted99/hospital-middleware/internal/oidctest"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOIDCProviderLogin(t *testing.T) {
+	idp := oidctest.NewServer("middleware", "s3cret")
+	defer idp.Close()
+	idp.SetUser(map[string]interface{}{
+		"sub":                "idp-42",
+		"preferred_username": "dr.somchai",
+		"email":              "somchai@hospital-a.example",
+		"groups":             []string{"staff", "physicians"},
+	})
+
+	provider, err := services.NewOIDCProvider(config.OIDCConfig{
+		Hospital:     "Hospital A",
+		Issuer:       idp.Issuer(),
+		ClientID:     "middleware",
+		ClientSecret: "s3cret",
+		RedirectURL:  "https://middleware.example/staff/login/oidc/callback",
+		Scopes:       []string{"openid", "profile"},
+		RoleClaim:    "groups",
+		RoleMap:      map[string]string{"physicians": services.RoleDoctor},
+	})
+	require.NoError(t, err)
+
+	verifier, err := services.GeneratePKCEVerifier()
+	require.NoError(t, err)
+	authURL, err := provider.AuthorizationURL(context.Background(), "state-1", "nonce-1", services.PKCEChallenge(verifier))
+	require.NoError(t, err)
+
+	// The fake IdP signs the user in right away and redirects back with a code
+	noRedirects := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noRedirects.Get(authURL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "state-1", callback.Query().Get("state"))
+	code := callback.Query().Get("code")
+
+	_, err = provider.Exchange(context.Background(), code, verifier, "other-nonce")
+	assert.Error(t, err, "a code is bound to the nonce of its request")
+
+	code = idp.IssueCode(provider.Config.RedirectURL, services.PKCEChallenge(verifier), "nonce-1")
+	_, err = provider.Exchange(context.Background(), code, "wrong-verifier", "nonce-1")
+	assert.Error(t, err, "the IdP rejects a wrong PKCE verifier")
+
+	code = idp.IssueCode(provider.Config.RedirectURL, services.PKCEChallenge(verifier), "nonce-1")
+	identity, err := provider.Exchange(context.Background(), code, verifier, "nonce-1")
+	require.NoError(t, err)
+	assert.Equal(t, idp.Issuer(), identity.Issuer)
+	assert.Equal(t, "idp-42", identity.Subject)
+	assert.Equal(t, "dr.somchai", identity.Username)
+	assert.Equal(t, "somchai@hospital-a.example", identity.Email)
+	assert.Equal(t, services.RoleDoctor, provider.Role(identity))
+
+	identity.Roles = []string{"staff"}
+	assert.Equal(t, services.DefaultRole, provider.Role(identity))
+}
+
+func TestNewOIDCProviderRejectsUnknownRoles(t *testing.T) {
+	_, err := services.NewOIDCProvider(config.OIDCConfig{
+		Issuer:      "https://idp.example",
+		ClientID:    "middleware",
+		RedirectURL: "https://middleware.example/staff/login/oidc/callback",
+		RoleMap:     map[string]string{"admins": "superuser"},
+	})
+	assert.Error(t, err)
+}
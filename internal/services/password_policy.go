@@ -0,0 +1,130 @@
+package services
+
+import (
+	"bufio"
+	"crypto/rand"
+	_ "embed"
+	"fmt"
+	"math/big"
+	"strings"
+	"unicode"
+
+	"github.com/roasted99/hospital-middleware/internal/config"
+)
+
+// bcrypt ignores everything after 72 bytes, so longer passwords are refused
+// rather than silently truncated
+const maxPasswordBytes = 72
+
+//go:embed passwords/common.txt
+var commonPasswordList string
+
+var commonPasswords = func() map[string]bool {
+	passwords := make(map[string]bool)
+	scanner := bufio.NewScanner(strings.NewReader(commonPasswordList))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			passwords[strings.ToLower(line)] = true
+		}
+	}
+	return passwords
+}()
+
+// PasswordPolicy decides which passwords staff may choose
+type PasswordPolicy struct {
+	MinLength           int
+	MinCharacterClasses int
+	// HistorySize is how many previous passwords may not be reused
+	HistorySize int
+}
+
+func NewPasswordPolicy(cfg config.PasswordPolicyConfig) PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:           cfg.MinLength,
+		MinCharacterClasses: cfg.MinCharacterClasses,
+		HistorySize:         cfg.HistorySize,
+	}
+}
+
+// Validate returns a *ValidationError for the password field when password
+// breaks the policy. Reuse is checked separately against the password history.
+func (p PasswordPolicy) Validate(password, username string) error {
+	var problem string
+	switch {
+	case len([]rune(password)) < p.MinLength:
+		problem = fmt.Sprintf("must be at least %d characters", p.MinLength)
+	case len(password) > maxPasswordBytes:
+		problem = fmt.Sprintf("must be at most %d bytes", maxPasswordBytes)
+	case characterClasses(password) < p.MinCharacterClasses:
+		problem = fmt.Sprintf("must contain at least %d of: lowercase letters, uppercase letters, digits, symbols", p.MinCharacterClasses)
+	case isCommonPassword(password):
+		problem = "is too common"
+	case username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)):
+		problem = "must not contain the username"
+	default:
+		return nil
+	}
+	return &ValidationError{Fields: map[string]string{"password": problem}}
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower + upper + digit + symbol
+}
+
+// isCommonPassword also catches denylisted passwords padded with digits and
+// symbols, e.g. "Password123!!"
+func isCommonPassword(password string) bool {
+	lower := strings.ToLower(password)
+	if commonPasswords[lower] {
+		return true
+	}
+	trimmed := strings.TrimRightFunc(lower, func(r rune) bool {
+		return unicode.IsDigit(r) || unicode.IsPunct(r) || unicode.IsSymbol(r)
+	})
+	return trimmed != lower && commonPasswords[trimmed]
+}
+
+// GenerateTemporaryPassword returns a random password that satisfies any
+// reasonable policy, for admin-initiated resets
+func GenerateTemporaryPassword() (string, error) {
+	classes := []string{"abcdefghijkmnpqrstuvwxyz", "ABCDEFGHJKLMNPQRSTUVWXYZ", "23456789", "!@#$%^&*-_+="}
+	all := strings.Join(classes, "")
+
+	password := make([]byte, 0, 20)
+	for i := 0; i < 20; i++ {
+		// The first characters cover every class; the rest are drawn from all of them
+		alphabet := all
+		if i < len(classes) {
+			alphabet = classes[i]
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+		if err != nil {
+			return "", err
+		}
+		password = append(password, alphabet[n.Int64()])
+	}
+
+	// Shuffle so the class order is not predictable
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j.Int64()] = password[j.Int64()], password[i]
+	}
+	return string(password), nil
+}
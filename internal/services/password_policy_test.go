@@ -0,0 +1,51 @@
+package services_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	policy := services.PasswordPolicy{MinLength: 12, MinCharacterClasses: 3, HistorySize: 5}
+
+	tests := []struct {
+		name     string
+		password string
+		problem  string
+	}{
+		{name: "strong password", password: "Ward-7-Rounds!"},
+		{name: "too short", password: "Ab1!", problem: "must be at least 12 characters"},
+		{name: "longer than bcrypt accepts", password: strings.Repeat("Ab1!", 19), problem: "must be at most 72 bytes"},
+		{name: "too few character classes", password: "alllowercaseletters", problem: "must contain at least 3 of"},
+		{name: "common password", password: "Password123!", problem: "is too common"},
+		{name: "common password with padding", password: "Qwertyuiop123!!", problem: "is too common"},
+		{name: "contains the username", password: "Xx-DrSomchai-99", problem: "must not contain the username"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Validate(tt.password, "drsomchai")
+			if tt.problem == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var validationErr *services.ValidationError
+			require.ErrorAs(t, err, &validationErr)
+			assert.Contains(t, validationErr.Fields["password"], tt.problem)
+		})
+	}
+}
+
+func TestGenerateTemporaryPassword(t *testing.T) {
+	policy := services.PasswordPolicy{MinLength: 16, MinCharacterClasses: 4}
+
+	for i := 0; i < 20; i++ {
+		password, err := services.GenerateTemporaryPassword()
+		require.NoError(t, err)
+		assert.NoError(t, policy.Validate(password, "staff"))
+	}
+}
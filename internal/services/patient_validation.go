@@ -0,0 +1,144 @@
+package services
+
+import (
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/roasted99/hospital-middleware/internal/models"
+)
+
+// ValidationError lists the invalid fields of a request
+type ValidationError struct {
+	Fields map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	fields := make([]string, 0, len(e.Fields))
+	for field := range e.Fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	messages := make([]string, 0, len(fields))
+	for _, field := range fields {
+		messages = append(messages, field+" "+e.Fields[field])
+	}
+	return strings.Join(messages, "; ")
+}
+
+// NormalizePatientRequest trims every field and upper-cases the gender code
+func NormalizePatientRequest(request *models.PatientRequest) {
+	for _, field := range []*string{
+		&request.FirstNameTH, &request.MiddleNameTH, &request.LastNameTH,
+		&request.FirstNameEN, &request.MiddleNameEN, &request.LastNameEN,
+		&request.DateOfBirth, &request.PatientHN, &request.NationalID,
+		&request.PassportID, &request.PhoneNumber, &request.Email, &request.Gender,
+	} {
+		*field = strings.TrimSpace(*field)
+	}
+	request.Gender = strings.ToUpper(request.Gender)
+}
+
+// ValidatePatientRequest checks a normalized patient record and returns a
+// *ValidationError describing every invalid field
+func ValidatePatientRequest(request models.PatientRequest) error {
+	fields := map[string]string{}
+
+	if request.FirstNameTH == "" && request.FirstNameEN == "" {
+		fields["first_name"] = "is required in Thai or English"
+	}
+	if request.LastNameTH == "" && request.LastNameEN == "" {
+		fields["last_name"] = "is required in Thai or English"
+	}
+	if request.PatientHN == "" {
+		fields["patient_hn"] = "is required"
+	}
+
+	if request.NationalID == "" && request.PassportID == "" {
+		fields["national_id"] = "or passport_id is required"
+	} else if request.NationalID != "" && !ValidateThaiNationalID(request.NationalID) {
+		fields["national_id"] = "is not a valid Thai national ID"
+	}
+
+	if request.Gender != "M" && request.Gender != "F" {
+		fields["gender"] = "must be M or F"
+	}
+
+	if request.DateOfBirth == "" {
+		fields["date_of_birth"] = "is required"
+	} else if dob, err := time.Parse("2006-01-02", request.DateOfBirth); err != nil {
+		fields["date_of_birth"] = "must be formatted as YYYY-MM-DD"
+	} else if dob.After(time.Now()) || dob.Year() < 1900 {
+		fields["date_of_birth"] = "must be between 1900-01-01 and today"
+	}
+
+	if request.Email != "" {
+		if address, err := mail.ParseAddress(request.Email); err != nil || address.Address != request.Email {
+			fields["email"] = "is not a valid email address"
+		}
+	}
+
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// ValidatePatientPatch validates a patched record like ValidatePatientRequest
+// but ignores errors on fields the patch left as they were stored, so records
+// imported before validation existed can still be partially updated
+func ValidatePatientPatch(stored, patched models.PatientRequest) error {
+	err := ValidatePatientRequest(patched)
+	validationErr, ok := err.(*ValidationError)
+	if !ok {
+		return err
+	}
+
+	before, after := validatedInputs(stored), validatedInputs(patched)
+	for field := range validationErr.Fields {
+		if before[field] == after[field] {
+			delete(validationErr.Fields, field)
+		}
+	}
+
+	if len(validationErr.Fields) > 0 {
+		return validationErr
+	}
+	return nil
+}
+
+// validatedInputs maps each ValidationError field to the record values it checks
+func validatedInputs(request models.PatientRequest) map[string][2]string {
+	return map[string][2]string{
+		"first_name":    {request.FirstNameTH, request.FirstNameEN},
+		"last_name":     {request.LastNameTH, request.LastNameEN},
+		"patient_hn":    {request.PatientHN},
+		"national_id":   {request.NationalID, request.PassportID},
+		"gender":        {request.Gender},
+		"date_of_birth": {request.DateOfBirth},
+		"email":         {request.Email},
+	}
+}
+
+// ValidateThaiNationalID verifies the 13-digit format and mod-11 check digit
+// of a Thai national ID
+func ValidateThaiNationalID(id string) bool {
+	if len(id) != 13 {
+		return false
+	}
+
+	sum := 0
+	for i := 0; i < 13; i++ {
+		if id[i] < '0' || id[i] > '9' {
+			return false
+		}
+		if i < 12 {
+			sum += int(id[i]-'0') * (13 - i)
+		}
+	}
+
+	checkDigit := (11 - sum%11) % 10
+	return int(id[12]-'0') == checkDigit
+}
@@ -0,0 +1,95 @@
+package services_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateThaiNationalID(t *testing.T) {
+	assert.True(t, services.ValidateThaiNationalID("1101500234564"))
+	assert.True(t, services.ValidateThaiNationalID("1234567890121"))
+	assert.True(t, services.ValidateThaiNationalID("3101234567893"))
+
+	assert.False(t, services.ValidateThaiNationalID("1101500234567"), "wrong check digit")
+	assert.False(t, services.ValidateThaiNationalID("110150023456"), "too short")
+	assert.False(t, services.ValidateThaiNationalID("11015002345640"), "too long")
+	assert.False(t, services.ValidateThaiNationalID("1-01500234564"), "non-digit")
+}
+
+func validPatientRequest() models.PatientRequest {
+	return models.PatientRequest{
+		FirstNameTH: "สมชาย",
+		LastNameTH:  "มีสุข",
+		FirstNameEN: "Somchai",
+		LastNameEN:  "Meesuk",
+		DateOfBirth: "1980-08-20",
+		PatientHN:   "HN-00123",
+		NationalID:  "1101500234564",
+		PhoneNumber: "0812345678",
+		Email:       "jai@gmail.com",
+		Gender:      "M",
+	}
+}
+
+func TestValidatePatientRequest(t *testing.T) {
+	require.NoError(t, services.ValidatePatientRequest(validPatientRequest()))
+
+	tests := []struct {
+		name   string
+		modify func(*models.PatientRequest)
+		field  string
+	}{
+		{"Invalid national ID", func(r *models.PatientRequest) { r.NationalID = "1101500234567" }, "national_id"},
+		{"Missing identifiers", func(r *models.PatientRequest) { r.NationalID = "" }, "national_id"},
+		{"Unknown gender", func(r *models.PatientRequest) { r.Gender = "X" }, "gender"},
+		{"Malformed date of birth", func(r *models.PatientRequest) { r.DateOfBirth = "20/08/1980" }, "date_of_birth"},
+		{"Date of birth in the future", func(r *models.PatientRequest) { r.DateOfBirth = time.Now().AddDate(1, 0, 0).Format("2006-01-02") }, "date_of_birth"},
+		{"Invalid email", func(r *models.PatientRequest) { r.Email = "not-an-email" }, "email"},
+		{"Email with display name", func(r *models.PatientRequest) { r.Email = "Jai <jai@gmail.com>" }, "email"},
+		{"Missing names", func(r *models.PatientRequest) { r.FirstNameTH, r.FirstNameEN = "", "" }, "first_name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := validPatientRequest()
+			tt.modify(&request)
+
+			err := services.ValidatePatientRequest(request)
+			var validationErr *services.ValidationError
+			require.True(t, errors.As(err, &validationErr))
+			assert.Contains(t, validationErr.Fields, tt.field)
+		})
+	}
+
+	passportOnly := validPatientRequest()
+	passportOnly.NationalID = ""
+	passportOnly.PassportID = "AB123456"
+	assert.NoError(t, services.ValidatePatientRequest(passportOnly))
+}
+
+func TestValidatePatientPatch(t *testing.T) {
+	stored := validPatientRequest()
+	stored.NationalID = "1101500234567"
+
+	patched := stored
+	patched.Email = "somchai@gmail.com"
+	assert.NoError(t, services.ValidatePatientPatch(stored, patched))
+
+	patched.NationalID = "2109876543210"
+	err := services.ValidatePatientPatch(stored, patched)
+	var validationErr *services.ValidationError
+	require.True(t, errors.As(err, &validationErr))
+	assert.Contains(t, validationErr.Fields, "national_id")
+
+	patched = stored
+	patched.Gender = "X"
+	require.True(t, errors.As(services.ValidatePatientPatch(stored, patched), &validationErr))
+	assert.Contains(t, validationErr.Fields, "gender")
+	assert.NotContains(t, validationErr.Fields, "national_id")
+}
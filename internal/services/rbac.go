@@ -0,0 +1,96 @@
+package services
+
+import "github.com/roasted99/hospital-middleware/internal/models"
+
+// Permission is an action a staff member may be allowed to perform
+type Permission string
+
+const (
+	PermPatientSearch          Permission = "patient:search"
+	PermPatientSearchFederated Permission = "patient:search:federated"
+	PermPatientRead            Permission = "patient:read"
+	PermPatientWrite           Permission = "patient:write"
+	PermPatientDelete          Permission = "patient:delete"
+	PermStaffManage            Permission = "staff:manage"
+	PermHospitalManage         Permission = "hospital:manage"
+	PermAuditRead              Permission = "audit:read"
+	PermConsentManage          Permission = "consent:manage"
+	PermBreakGlass             Permission = "patient:break_glass"
+	PermBreakGlassReview       Permission = "break_glass:review"
+)
+
+// Roles stored in the roles table
+const (
+	RoleAdmin             = "admin"
+	RoleDoctor            = "doctor"
+	RoleNurse             = "nurse"
+	RoleRegistrationClerk = "registration_clerk"
+	RoleAuditor           = "auditor"
+	RolePrivacyOfficer    = "privacy_officer"
+)
+
+// DefaultRole is given to staff created without an explicit role
+const DefaultRole = RoleRegistrationClerk
+
+var rolePermissions = map[string][]Permission{
+	RoleAdmin: {
+		PermPatientSearch, PermPatientSearchFederated, PermPatientRead, PermPatientWrite, PermPatientDelete,
+		PermConsentManage, PermStaffManage, PermHospitalManage,
+	},
+	RoleDoctor:            {PermPatientSearch, PermPatientSearchFederated, PermPatientRead, PermPatientWrite, PermBreakGlass},
+	RoleNurse:             {PermPatientSearch, PermPatientRead, PermPatientWrite, PermBreakGlass},
+	RoleRegistrationClerk: {PermPatientSearch, PermPatientRead, PermPatientWrite, PermConsentManage},
+	RoleAuditor:           {PermAuditRead},
+	RolePrivacyOfficer:    {PermBreakGlassReview, PermAuditRead},
+}
+
+// breakGlassPermissions are granted on top of the role while a clinician uses
+// an emergency access token. Records can be found and read, not changed.
+var breakGlassPermissions = []Permission{PermPatientSearch, PermPatientSearchFederated, PermPatientRead}
+
+// apiKeyScopes are the permissions an API key may be granted. Managing staff
+// and hospitals is left to signed-in admins.
+var apiKeyScopes = []Permission{PermPatientSearch, PermPatientSearchFederated, PermPatientRead, PermPatientWrite}
+
+// IsValidAPIKeyScope reports whether scope may be granted to an API key
+func IsValidAPIKeyScope(scope string) bool {
+	for _, permission := range apiKeyScopes {
+		if string(permission) == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// IsValidRole reports whether role is a known role
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// HasPermission reports whether role grants permission
+func HasPermission(role string, permission Permission) bool {
+	for _, granted := range rolePermissions[role] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// StaffHasPermission reports whether staff may use permission, taking an
+// emergency access token into account
+func StaffHasPermission(staff *models.Staff, permission Permission) bool {
+	if HasPermission(staff.Role, permission) {
+		return true
+	}
+	if staff.BreakGlassID == 0 {
+		return false
+	}
+	for _, granted := range breakGlassPermissions {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
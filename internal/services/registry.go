@@ -0,0 +1,150 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/roasted99/hospital-middleware/internal/config"
+	"github.com/roasted99/hospital-middleware/internal/models"
+)
+
+// HospitalClientFactory builds an adapter for a configured hospital
+type HospitalClientFactory func(cfg config.HospitalConfig) (HospitalClient, error)
+
+var hospitalAdapters = map[string]HospitalClientFactory{
+	"hospital_a": func(cfg config.HospitalConfig) (HospitalClient, error) {
+		client := NewHospitalAClient(cfg)
+		auth, err := NewUpstreamAuth(cfg.Auth, client.HTTPClient)
+		if err != nil {
+			return nil, err
+		}
+		client.Auth = auth
+		return client, nil
+	},
+	"hospital_b": func(cfg config.HospitalConfig) (HospitalClient, error) {
+		client := NewHospitalBClient(cfg)
+		auth, err := NewUpstreamAuth(cfg.Auth, client.HTTPClient)
+		if err != nil {
+			return nil, err
+		}
+		client.Auth = auth
+		return client, nil
+	},
+}
+
+// HospitalRegistry resolves the HospitalClient for a hospital identifier
+type HospitalRegistry struct {
+	clients  map[string]HospitalClient
+	names    map[string]string
+	breakers map[string]*CircuitBreaker
+	consents ConsentStore
+}
+
+func NewHospitalRegistry() *HospitalRegistry {
+	return &HospitalRegistry{
+		clients:  make(map[string]HospitalClient),
+		names:    make(map[string]string),
+		breakers: make(map[string]*CircuitBreaker),
+	}
+}
+
+// NewHospitalRegistryFromConfig registers an adapter for every configured hospital
+func NewHospitalRegistryFromConfig(hospitals []config.HospitalConfig) (*HospitalRegistry, error) {
+	registry := NewHospitalRegistry()
+	for _, cfg := range hospitals {
+		factory, ok := hospitalAdapters[strings.ToLower(cfg.Adapter)]
+		if !ok {
+			return nil, fmt.Errorf("unknown adapter %q for %s", cfg.Adapter, cfg.Name)
+		}
+		if cfg.BaseURL == "" {
+			return nil, fmt.Errorf("no URL configured for %s", cfg.Name)
+		}
+
+		client, err := factory(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create adapter for %s: %w", cfg.Name, err)
+		}
+		if cfg.RetryMaxAttempts > 1 {
+			client = NewRetryingHospitalClient(client, RetryPolicy{
+				MaxAttempts: cfg.RetryMaxAttempts,
+				BaseDelay:   cfg.RetryBaseDelay,
+				MaxDelay:    cfg.RetryMaxDelay,
+				Budget:      cfg.RetryBudget,
+			})
+		}
+		if cfg.BreakerFailureThreshold > 0 {
+			breaker := NewCircuitBreaker(cfg.BreakerFailureThreshold, cfg.BreakerOpenTimeout)
+			client = NewCircuitBreakerHospitalClient(client, breaker)
+			registry.breakers[registryKey(cfg.Name)] = breaker
+		}
+		if cfg.CacheTTL > 0 && cfg.CacheMaxEntries > 0 {
+			client = NewCachingHospitalClient(cfg.Name, client, NewLRUPatientCache(cfg.CacheMaxEntries), cfg.CacheTTL, cfg.CacheNegativeTTL)
+		}
+		registry.Register(cfg.Name, client)
+	}
+	return registry, nil
+}
+
+// Register adds or replaces the adapter for a hospital
+func (r *HospitalRegistry) Register(hospital string, client HospitalClient) {
+	key := registryKey(hospital)
+	r.clients[key] = client
+	r.names[key] = strings.TrimSpace(hospital)
+}
+
+// RequireConsent checks every lookup made through Client against consents
+// before it reaches the hospital system, including cached results
+func (r *HospitalRegistry) RequireConsent(consents ConsentStore) {
+	r.consents = consents
+}
+
+// Client returns the adapter registered for a hospital, matched case-insensitively
+func (r *HospitalRegistry) Client(hospital string) (HospitalClient, bool) {
+	key := registryKey(hospital)
+	client, ok := r.clients[key]
+	if ok && r.consents != nil {
+		client = NewConsentHospitalClient(r.names[key], client, r.consents)
+	}
+	return client, ok
+}
+
+// InvalidatePatient drops cached results for a patient at a hospital. It reports
+// false when the hospital has no cache configured.
+func (r *HospitalRegistry) InvalidatePatient(hospital, patientID string) (int, bool) {
+	client, ok := r.clients[registryKey(hospital)]
+	if !ok {
+		return 0, false
+	}
+
+	cache, ok := client.(interface{ InvalidatePatient(patientID string) int })
+	if !ok {
+		return 0, false
+	}
+	return cache.InvalidatePatient(patientID), true
+}
+
+// BreakerStatuses returns the circuit breaker state of every hospital that has one
+func (r *HospitalRegistry) BreakerStatuses() []models.BreakerStatus {
+	statuses := []models.BreakerStatus{}
+	for _, hospital := range r.Hospitals() {
+		if breaker, ok := r.breakers[registryKey(hospital)]; ok {
+			statuses = append(statuses, breaker.Status(hospital))
+		}
+	}
+	return statuses
+}
+
+// Hospitals returns the names of all registered hospitals in sorted order
+func (r *HospitalRegistry) Hospitals() []string {
+	hospitals := make([]string, 0, len(r.names))
+	for _, name := range r.names {
+		hospitals = append(hospitals, name)
+	}
+	sort.Strings(hospitals)
+	return hospitals
+}
+
+func registryKey(hospital string) string {
+	return strings.ToLower(strings.TrimSpace(hospital))
+}
@@ -0,0 +1,231 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/roasted99/hospital-middleware/internal/models"
+)
+
+// ErrCircuitOpen is returned without calling the hospital while its breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// RetryPolicy retries transient failures with exponential backoff and full
+// jitter. Budget bounds all attempts and delays together; zero means no bound.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Budget      time.Duration
+}
+
+// RetryingHospitalClient retries lookups that failed for a transient reason.
+// Hospital searches are read-only, so every call is safe to repeat.
+type RetryingHospitalClient struct {
+	Next   HospitalClient
+	Policy RetryPolicy
+}
+
+func NewRetryingHospitalClient(next HospitalClient, policy RetryPolicy) *RetryingHospitalClient {
+	return &RetryingHospitalClient{Next: next, Policy: policy}
+}
+
+func (c *RetryingHospitalClient) SearchPatients(ctx context.Context, query models.PatientSearchRequest) ([]models.Patient, error) {
+	var patients []models.Patient
+	var err error
+
+	if c.Policy.Budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Policy.Budget)
+		defer cancel()
+	}
+
+	for attempt := 1; ; attempt++ {
+		patients, err = c.Next.SearchPatients(ctx, query)
+		if err == nil || attempt >= c.Policy.MaxAttempts || !IsTransientError(ctx, err) {
+			return patients, err
+		}
+
+		timer := time.NewTimer(c.Policy.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns a random delay in [0, min(MaxDelay, BaseDelay*2^(attempt-1))]
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay << (attempt - 1)
+	if delay <= 0 || (p.MaxDelay > 0 && delay > p.MaxDelay) {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int64N(int64(delay) + 1))
+}
+
+// IsTransientError reports whether a failed lookup is worth retrying: network
+// errors, upstream timeouts and 429/502/503/504 responses. Errors caused by the
+// caller's own context ending are not transient.
+func IsTransientError(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	if errors.Is(err, ErrPatientNotFound) || errors.Is(err, ErrCircuitOpen) {
+		return false
+	}
+
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) {
+		switch upstreamErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+// CircuitBreaker opens after FailureThreshold consecutive failures and lets a
+// single probe through once OpenTimeout has passed
+type CircuitBreaker struct {
+	FailureThreshold int
+	OpenTimeout      time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func NewCircuitBreaker(failureThreshold int, openTimeout time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		FailureThreshold: failureThreshold,
+		OpenTimeout:      openTimeout,
+		state:            BreakerClosed,
+	}
+}
+
+// Allow reports whether a call may proceed
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.OpenTimeout {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return true
+	case BreakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.state = BreakerClosed
+	b.failures = 0
+	b.probing = false
+}
+
+func (b *CircuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.probing = false
+	if b.state == BreakerHalfOpen || b.failures >= b.FailureThreshold {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// Release ends a call without recording an outcome
+func (b *CircuitBreaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// Status returns a snapshot of the breaker for the given hospital
+func (b *CircuitBreaker) Status(hospital string) models.BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := models.BreakerStatus{
+		Hospital:            hospital,
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+	}
+	if b.state != BreakerClosed {
+		openedAt := b.openedAt
+		retryAt := b.openedAt.Add(b.OpenTimeout)
+		status.OpenedAt = &openedAt
+		status.RetryAt = &retryAt
+	}
+	return status
+}
+
+// CircuitBreakerHospitalClient fails fast with ErrCircuitOpen while the breaker
+// is open so callers can fall back to the local database
+type CircuitBreakerHospitalClient struct {
+	Next    HospitalClient
+	Breaker *CircuitBreaker
+}
+
+func NewCircuitBreakerHospitalClient(next HospitalClient, breaker *CircuitBreaker) *CircuitBreakerHospitalClient {
+	return &CircuitBreakerHospitalClient{Next: next, Breaker: breaker}
+}
+
+func (c *CircuitBreakerHospitalClient) SearchPatients(ctx context.Context, query models.PatientSearchRequest) ([]models.Patient, error) {
+	if !c.Breaker.Allow() {
+		return nil, ErrCircuitOpen
+	}
+
+	patients, err := c.Next.SearchPatients(ctx, query)
+	switch {
+	case err == nil || errors.Is(err, ErrPatientNotFound):
+		c.Breaker.Success()
+	case errors.Is(err, context.Canceled) && ctx.Err() != nil:
+		// The caller went away; this says nothing about the hospital
+		c.Breaker.Release()
+	default:
+		c.Breaker.Failure()
+	}
+	return patients, err
+}
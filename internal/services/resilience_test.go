@@ -0,0 +1,137 @@
+package services_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/roasted99/hospital-middleware/internal/config"
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flappingServer answers with the given statuses in order, repeating the last one
+type flappingServer struct {
+	*httptest.Server
+	requests atomic.Int32
+}
+
+func newFlappingServer(t *testing.T, statuses ...int) *flappingServer {
+	server := &flappingServer{}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(server.requests.Add(1))
+		status := statuses[len(statuses)-1]
+		if n <= len(statuses) {
+			status = statuses[n-1]
+		}
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			json.NewEncoder(w).Encode(services.HospitalAResponse{NationalID: "1101500234567"})
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestHospitalAClient(url string) *services.HospitalAClient {
+	return services.NewHospitalAClient(config.HospitalConfig{Name: "Hospital A", BaseURL: url, Timeout: time.Second})
+}
+
+var retryPolicy = services.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+func TestRetryingHospitalClientRecoversFromTransientFailures(t *testing.T) {
+	server := newFlappingServer(t, http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusOK)
+	client := services.NewRetryingHospitalClient(newTestHospitalAClient(server.URL), retryPolicy)
+
+	patients, err := client.SearchPatients(context.Background(), models.PatientSearchRequest{NationalID: "1101500234567"})
+	require.NoError(t, err)
+	assert.Len(t, patients, 1)
+	assert.EqualValues(t, 3, server.requests.Load())
+}
+
+func TestRetryingHospitalClientDoesNotRetryPermanentFailures(t *testing.T) {
+	for _, status := range []int{http.StatusNotFound, http.StatusBadRequest, http.StatusUnauthorized} {
+		server := newFlappingServer(t, status, http.StatusOK)
+		client := services.NewRetryingHospitalClient(newTestHospitalAClient(server.URL), retryPolicy)
+
+		_, err := client.SearchPatients(context.Background(), models.PatientSearchRequest{NationalID: "1101500234567"})
+		assert.Error(t, err)
+		assert.EqualValues(t, 1, server.requests.Load(), "status %d should not be retried", status)
+	}
+}
+
+func TestRetryingHospitalClientGivesUpAfterMaxAttempts(t *testing.T) {
+	server := newFlappingServer(t, http.StatusServiceUnavailable)
+	client := services.NewRetryingHospitalClient(newTestHospitalAClient(server.URL), retryPolicy)
+
+	_, err := client.SearchPatients(context.Background(), models.PatientSearchRequest{NationalID: "1101500234567"})
+	assert.Error(t, err)
+	assert.EqualValues(t, 3, server.requests.Load())
+}
+
+func TestRetryingHospitalClientStopsAtBudget(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-r.Context().Done()
+	}))
+	t.Cleanup(server.Close)
+
+	policy := retryPolicy
+	policy.Budget = 100 * time.Millisecond
+	client := services.NewRetryingHospitalClient(newTestHospitalAClient(server.URL), policy)
+
+	started := time.Now()
+	_, err := client.SearchPatients(context.Background(), models.PatientSearchRequest{NationalID: "1101500234567"})
+	assert.Error(t, err)
+	assert.Less(t, time.Since(started), 500*time.Millisecond, "a hung hospital is given up on at the budget")
+	assert.EqualValues(t, 1, requests.Load())
+}
+
+func TestCircuitBreakerHospitalClient(t *testing.T) {
+	server := newFlappingServer(t, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusOK)
+	breaker := services.NewCircuitBreaker(2, 50*time.Millisecond)
+	client := services.NewCircuitBreakerHospitalClient(newTestHospitalAClient(server.URL), breaker)
+	query := models.PatientSearchRequest{NationalID: "1101500234567"}
+
+	for i := 0; i < 2; i++ {
+		_, err := client.SearchPatients(context.Background(), query)
+		assert.Error(t, err)
+	}
+	assert.Equal(t, services.BreakerOpen, breaker.Status("Hospital A").State)
+
+	// While open, calls fail fast without reaching the hospital
+	_, err := client.SearchPatients(context.Background(), query)
+	assert.ErrorIs(t, err, services.ErrCircuitOpen)
+	assert.EqualValues(t, 2, server.requests.Load())
+
+	// After the open timeout a probe is let through and closes the breaker
+	time.Sleep(60 * time.Millisecond)
+	patients, err := client.SearchPatients(context.Background(), query)
+	require.NoError(t, err)
+	assert.Len(t, patients, 1)
+
+	status := breaker.Status("Hospital A")
+	assert.Equal(t, services.BreakerClosed, status.State)
+	assert.Equal(t, 0, status.ConsecutiveFailures)
+}
+
+func TestCircuitBreakerReopensWhenProbeFails(t *testing.T) {
+	breaker := services.NewCircuitBreaker(1, 10*time.Millisecond)
+	breaker.Failure()
+	require.False(t, breaker.Allow())
+
+	time.Sleep(15 * time.Millisecond)
+	require.True(t, breaker.Allow())
+	assert.False(t, breaker.Allow(), "only one probe is allowed while half-open")
+
+	breaker.Failure()
+	assert.Equal(t, services.BreakerOpen, breaker.Status("Hospital A").State)
+	assert.False(t, breaker.Allow())
+}
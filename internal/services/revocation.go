@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+// TokenRevocationStore records access tokens that must no longer be accepted
+// before they expire
+type TokenRevocationStore interface {
+	// RevokeToken revokes a single token until its expiry
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+	// RevokeStaff revokes every token issued to a staff member up to at
+	RevokeStaff(ctx context.Context, staffID int, at time.Time) error
+	IsRevoked(ctx context.Context, claims *JWTClaims) (bool, error)
+	// Prune deletes entries that can no longer match an unexpired token
+	Prune(ctx context.Context, now time.Time) (int64, error)
+}
+
+// SQLTokenRevocationStore keeps revocations in the revoked_tokens and
+// staff_session_revocations tables
+type SQLTokenRevocationStore struct {
+	DB *sql.DB
+	// MaxTokenAge is the longest lifetime of any access token, including
+	// emergency access tokens; a staff-wide revocation older than that no
+	// longer matches any valid token
+	MaxTokenAge time.Duration
+}
+
+func NewSQLTokenRevocationStore(db *sql.DB, maxTokenAge time.Duration) *SQLTokenRevocationStore {
+	return &SQLTokenRevocationStore{DB: db, MaxTokenAge: maxTokenAge}
+}
+
+func (s *SQLTokenRevocationStore) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	_, err := s.DB.ExecContext(ctx, "INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, $2) ON CONFLICT (jti) DO NOTHING", jti, expiresAt)
+	return err
+}
+
+func (s *SQLTokenRevocationStore) RevokeStaff(ctx context.Context, staffID int, at time.Time) error {
+	_, err := s.DB.ExecContext(ctx, "INSERT INTO staff_session_revocations (staff_id, revoked_before) VALUES ($1, $2) ON CONFLICT (staff_id) DO UPDATE SET revoked_before = EXCLUDED.revoked_before", staffID, at)
+	return err
+}
+
+// IsRevoked reports whether the token itself or every session of its staff
+// member was revoked. iat only has second precision, so a staff-wide revocation
+// also covers tokens issued later within the same second.
+func (s *SQLTokenRevocationStore) IsRevoked(ctx context.Context, claims *JWTClaims) (bool, error) {
+	var revoked bool
+	err := s.DB.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1) OR EXISTS (SELECT 1 FROM staff_session_revocations WHERE staff_id = $2 AND revoked_before >= $3)",
+		claims.ID, claims.StaffID, claims.IssuedAt.Time).Scan(&revoked)
+	return revoked, err
+}
+
+func (s *SQLTokenRevocationStore) Prune(ctx context.Context, now time.Time) (int64, error) {
+	tokens, err := s.DB.ExecContext(ctx, "DELETE FROM revoked_tokens WHERE expires_at < $1", now)
+	if err != nil {
+		return 0, err
+	}
+	staff, err := s.DB.ExecContext(ctx, "DELETE FROM staff_session_revocations WHERE revoked_before < $1", now.Add(-s.MaxTokenAge))
+	if err != nil {
+		return 0, err
+	}
+
+	prunedTokens, _ := tokens.RowsAffected()
+	prunedStaff, _ := staff.RowsAffected()
+	return prunedTokens + prunedStaff, nil
+}
+
+// PruneRevocations prunes store every interval until ctx is done
+func PruneRevocations(ctx context.Context, store TokenRevocationStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			pruned, err := store.Prune(ctx, now)
+			if err != nil {
+				log.Printf("Error pruning token revocations: %v", err)
+				continue
+			}
+			if pruned > 0 {
+				log.Printf("Pruned %d expired token revocations", pruned)
+			}
+		}
+	}
+}
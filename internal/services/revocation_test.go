@@ -0,0 +1,44 @@
+package services_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLTokenRevocationStore(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := services.NewSQLTokenRevocationStore(db, 15*time.Minute)
+	issuedAt := time.Now().Add(-time.Minute)
+	claims := &services.JWTClaims{StaffID: 7, RegisteredClaims: jwt.RegisteredClaims{ID: "abc", IssuedAt: jwt.NewNumericDate(issuedAt)}}
+
+	mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM revoked_tokens WHERE jti = \\$1\\) OR EXISTS \\(SELECT 1 FROM staff_session_revocations WHERE staff_id = \\$2 AND revoked_before >= \\$3\\)").
+		WithArgs("abc", 7, claims.IssuedAt.Time).
+		WillReturnRows(sqlmock.NewRows([]string{"revoked"}).AddRow(true))
+
+	revoked, err := store.IsRevoked(context.Background(), claims)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	now := time.Now()
+	mock.ExpectExec("DELETE FROM revoked_tokens WHERE expires_at < \\$1").
+		WithArgs(now).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec("DELETE FROM staff_session_revocations WHERE revoked_before < \\$1").
+		WithArgs(now.Add(-15 * time.Minute)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	pruned, err := store.Prune(context.Background(), now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), pruned)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,221 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/roasted99/hospital-middleware/internal/config"
+)
+
+// Supported token signing algorithms
+const (
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+)
+
+// ErrNoSigningKey is returned when no configured key is active
+var ErrNoSigningKey = errors.New("no active JWT signing key")
+
+// SigningKey is a private key that signs access tokens during its active window
+type SigningKey struct {
+	KID        string
+	Algorithm  string
+	PrivateKey crypto.Signer
+	ActivateAt time.Time
+	RetireAt   time.Time
+}
+
+func (k SigningKey) activeAt(now time.Time) bool {
+	return !now.Before(k.ActivateAt) && !k.retiredAt(now)
+}
+
+func (k SigningKey) retiredAt(now time.Time) bool {
+	return !k.RetireAt.IsZero() && !now.Before(k.RetireAt)
+}
+
+// Keyset holds every configured signing key. The key that signs is chosen by
+// time, so rotation happens on schedule as keys activate and retire.
+type Keyset struct {
+	keys []SigningKey
+}
+
+// NewKeyset validates keys; at least one of them must be active now
+func NewKeyset(keys []SigningKey, now time.Time) (*Keyset, error) {
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if key.KID == "" {
+			return nil, errors.New("JWT key without kid")
+		}
+		if seen[key.KID] {
+			return nil, fmt.Errorf("duplicate JWT key %q", key.KID)
+		}
+		seen[key.KID] = true
+	}
+
+	keyset := &Keyset{keys: keys}
+	if _, err := keyset.SigningKey(now); err != nil {
+		return nil, err
+	}
+	return keyset, nil
+}
+
+// LoadKeyset reads the PEM-encoded private keys of cfgs
+func LoadKeyset(cfgs []config.JWTKeyConfig, now time.Time) (*Keyset, error) {
+	keys := make([]SigningKey, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		data, err := os.ReadFile(cfg.File)
+		if err != nil {
+			return nil, fmt.Errorf("JWT key %q: %w", cfg.KID, err)
+		}
+		key, err := ParseSigningKey(cfg.KID, data)
+		if err != nil {
+			return nil, err
+		}
+		key.ActivateAt = cfg.ActivateAt
+		key.RetireAt = cfg.RetireAt
+		keys = append(keys, key)
+	}
+	return NewKeyset(keys, now)
+}
+
+// ParseSigningKey parses a PKCS#8, PKCS#1 or SEC 1 PEM private key. RSA keys
+// sign with RS256 and P-256 keys with ES256.
+func ParseSigningKey(kid string, data []byte) (SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return SigningKey{}, fmt.Errorf("JWT key %q: no PEM data", kid)
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return SigningKey{}, fmt.Errorf("JWT key %q: %w", kid, err)
+	}
+
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		if key.N.BitLen() < 2048 {
+			return SigningKey{}, fmt.Errorf("JWT key %q: RSA keys must be at least 2048 bits", kid)
+		}
+		return SigningKey{KID: kid, Algorithm: AlgRS256, PrivateKey: key}, nil
+	case *ecdsa.PrivateKey:
+		if key.Curve != elliptic.P256() {
+			return SigningKey{}, fmt.Errorf("JWT key %q: only P-256 EC keys are supported", kid)
+		}
+		return SigningKey{KID: kid, Algorithm: AlgES256, PrivateKey: key}, nil
+	default:
+		return SigningKey{}, fmt.Errorf("JWT key %q: unsupported key type %T", kid, parsed)
+	}
+}
+
+// SigningKey returns the most recently activated key that has not retired
+func (k *Keyset) SigningKey(now time.Time) (SigningKey, error) {
+	var current *SigningKey
+	for i := range k.keys {
+		key := &k.keys[i]
+		if key.activeAt(now) && (current == nil || !key.ActivateAt.Before(current.ActivateAt)) {
+			current = key
+		}
+	}
+	if current == nil {
+		return SigningKey{}, ErrNoSigningKey
+	}
+	return *current, nil
+}
+
+// VerificationKey returns the public key for kid. Keys that are not active yet
+// are accepted so a key can be published before it starts signing.
+func (k *Keyset) VerificationKey(kid string, now time.Time) (SigningKey, bool) {
+	for _, key := range k.keys {
+		if key.KID == kid && !key.retiredAt(now) {
+			return key, true
+		}
+	}
+	return SigningKey{}, false
+}
+
+// Key returns the key for kid regardless of its active window. It verifies
+// long-lived signatures such as audit checkpoints, which stay valid after the
+// key retires as long as it remains configured.
+func (k *Keyset) Key(kid string) (SigningKey, bool) {
+	for _, key := range k.keys {
+		if key.KID == kid {
+			return key, true
+		}
+	}
+	return SigningKey{}, false
+}
+
+// JWK is the public part of a signing key as published in the JWKS
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set (RFC 7517)
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of every key that has not retired
+func (k *Keyset) JWKS(now time.Time) JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+	for _, key := range k.keys {
+		if key.retiredAt(now) {
+			continue
+		}
+
+		jwk := JWK{KeyID: key.KID, Use: "sig", Algorithm: key.Algorithm}
+		switch public := key.PrivateKey.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case *ecdsa.PublicKey:
+			jwk.KeyType = "EC"
+			jwk.Curve = "P-256"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public.X.FillBytes(make([]byte, 32)))
+			jwk.Y = base64.RawURLEncoding.EncodeToString(public.Y.FillBytes(make([]byte, 32)))
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks
+}
+
+var currentKeyset atomic.Pointer[Keyset]
+
+// SetKeyset installs the keys used by GenerateJWT and ValidateToken
+func SetKeyset(keyset *Keyset) {
+	currentKeyset.Store(keyset)
+}
+
+// CurrentKeyset returns the keys installed with SetKeyset, or nil
+func CurrentKeyset() *Keyset {
+	return currentKeyset.Load()
+}
@@ -0,0 +1,110 @@
+package services_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func rsaSigningKey(t *testing.T, kid string) services.SigningKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	signingKey, err := services.ParseSigningKey(kid, data)
+	require.NoError(t, err)
+	return signingKey
+}
+
+func ecSigningKey(t *testing.T, kid string) services.SigningKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	signingKey, err := services.ParseSigningKey(kid, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	require.NoError(t, err)
+	return signingKey
+}
+
+func TestKeysetRotation(t *testing.T) {
+	now := time.Now()
+	old := rsaSigningKey(t, "old")
+	old.RetireAt = now.Add(2 * time.Hour)
+	next := ecSigningKey(t, "next")
+	next.ActivateAt = now.Add(time.Hour)
+
+	keyset, err := services.NewKeyset([]services.SigningKey{old, next}, now)
+	require.NoError(t, err)
+
+	key, err := keyset.SigningKey(now)
+	require.NoError(t, err)
+	assert.Equal(t, "old", key.KID)
+	assert.Equal(t, services.AlgRS256, key.Algorithm)
+
+	// The next key is published before it signs, and takes over once active
+	assert.Len(t, keyset.JWKS(now).Keys, 2)
+	key, err = keyset.SigningKey(now.Add(90 * time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, "next", key.KID)
+	assert.Equal(t, services.AlgES256, key.Algorithm)
+
+	jwks := keyset.JWKS(now.Add(3 * time.Hour))
+	require.Len(t, jwks.Keys, 1)
+	assert.Equal(t, "next", jwks.Keys[0].KeyID)
+	assert.Equal(t, "EC", jwks.Keys[0].KeyType)
+	assert.Equal(t, "P-256", jwks.Keys[0].Curve)
+
+	// Nothing is active yet, so the keyset is refused
+	_, err = services.NewKeyset([]services.SigningKey{next}, now)
+	assert.ErrorIs(t, err, services.ErrNoSigningKey)
+}
+
+func TestParseSigningKeyRejectsWeakRSA(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	_, err = services.ParseSigningKey("weak", data)
+	assert.Error(t, err)
+}
+
+func TestValidateToken(t *testing.T) {
+	first := rsaSigningKey(t, "first")
+	keyset, err := services.NewKeyset([]services.SigningKey{first}, time.Now())
+	require.NoError(t, err)
+	services.SetKeyset(keyset)
+
+	token, err := services.GenerateJWT(models.Staff{ID: 3, Username: "staff3", Hospital: "Hospital A", Role: "doctor"})
+	require.NoError(t, err)
+
+	claims, err := services.ValidateToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, 3, claims.StaffID)
+	assert.Equal(t, "doctor", claims.Staff().Role)
+	assert.NotEmpty(t, claims.ID)
+
+	// The public key must not be usable as an HMAC secret
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	forged.Header["kid"] = "first"
+	forgedToken, err := forged.SignedString([]byte("secret"))
+	require.NoError(t, err)
+	_, err = services.ValidateToken(forgedToken)
+	assert.Error(t, err)
+
+	// Tokens of keys that are no longer configured are rejected
+	keyset, err = services.NewKeyset([]services.SigningKey{ecSigningKey(t, "second")}, time.Now())
+	require.NoError(t, err)
+	services.SetKeyset(keyset)
+	_, err = services.ValidateToken(token)
+	assert.Error(t, err)
+}
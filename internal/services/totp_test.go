@@ -0,0 +1,63 @@
+package services_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfc6238Secret is the SHA-1 test key of RFC 6238 Appendix B, base32 encoded
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeMatchesRFC6238(t *testing.T) {
+	// The RFC lists 8-digit codes; 6-digit codes are their last six digits
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, expected := range vectors {
+		code, err := services.TOTPCode(rfc6238Secret, time.Unix(unix, 0))
+		require.NoError(t, err)
+		assert.Equal(t, expected, code, "T=%d", unix)
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	secret, err := services.GenerateTOTPSecret()
+	require.NoError(t, err)
+
+	now := time.Now()
+	code, err := services.TOTPCode(secret, now.Add(-30*time.Second))
+	require.NoError(t, err)
+
+	step, ok := services.VerifyTOTP(secret, code, now)
+	assert.True(t, ok)
+	assert.Equal(t, now.Unix()/30-1, step)
+
+	_, ok = services.VerifyTOTP(secret, code, now.Add(2*time.Minute))
+	assert.False(t, ok)
+	_, ok = services.VerifyTOTP(secret, "12345", now)
+	assert.False(t, ok)
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri := services.TOTPProvisioningURI("Hospital Middleware", "nurse1@Hospital A", rfc6238Secret)
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/Hospital%20Middleware:nurse1@Hospital%20A?"))
+	assert.Contains(t, uri, "secret="+rfc6238Secret)
+	assert.Contains(t, uri, "issuer=Hospital+Middleware")
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := services.GenerateRecoveryCodes(10)
+	require.NoError(t, err)
+	assert.Len(t, codes, 10)
+	assert.Regexp(t, "^[a-z2-7]{5}-[a-z2-7]{5}$", codes[0])
+	assert.Equal(t, strings.ReplaceAll(codes[0], "-", ""), services.NormalizeRecoveryCode(" "+strings.ToUpper(codes[0])+" "))
+}
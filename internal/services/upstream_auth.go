@@ -0,0 +1,226 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/roasted99/hospital-middleware/internal/config"
+)
+
+// UpstreamAuth adds hospital-specific credentials to outgoing requests
+type UpstreamAuth interface {
+	Apply(ctx context.Context, req *http.Request) error
+}
+
+// NewUpstreamAuth builds the credentials configured for a hospital. Client
+// certificates, when configured, are installed on httpClient for every auth type.
+func NewUpstreamAuth(cfg config.UpstreamAuthConfig, httpClient *http.Client) (UpstreamAuth, error) {
+	if cfg.CertFile != "" || cfg.KeyFile != "" || cfg.CAFile != "" {
+		if err := configureClientTLS(cfg, httpClient); err != nil {
+			return nil, err
+		}
+	}
+
+	switch strings.ToLower(cfg.Type) {
+	case "", "none":
+		return nil, nil
+	case "api_key":
+		if cfg.APIKey == "" {
+			return nil, errors.New("api_key auth requires an API key")
+		}
+		return &APIKeyAuth{Header: cfg.APIKeyHeader, Key: cfg.APIKey}, nil
+	case "oauth2":
+		if cfg.TokenURL == "" || cfg.ClientID == "" || cfg.ClientSecret == "" {
+			return nil, errors.New("oauth2 auth requires a token URL, client ID and client secret")
+		}
+		return NewOAuth2ClientCredentials(cfg.TokenURL, cfg.ClientID, cfg.ClientSecret, cfg.Scopes, httpClient), nil
+	case "mtls":
+		if cfg.CertFile == "" || cfg.KeyFile == "" {
+			return nil, errors.New("mtls auth requires a client certificate and key")
+		}
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown upstream auth type %q", cfg.Type)
+	}
+}
+
+func configureClientTLS(cfg config.UpstreamAuthConfig, httpClient *http.Client) error {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	if cfg.CAFile != "" {
+		caPEM, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return errors.New("no certificates found in CA file")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	httpClient.Transport = transport
+	return nil
+}
+
+// APIKeyAuth sends a static API key in a request header
+type APIKeyAuth struct {
+	Header string
+	Key    string
+}
+
+func (a *APIKeyAuth) Apply(ctx context.Context, req *http.Request) error {
+	header := a.Header
+	if header == "" {
+		header = "X-API-Key"
+	}
+	req.Header.Set(header, a.Key)
+	return nil
+}
+
+// OAuth2ClientCredentials obtains bearer tokens with the client credentials
+// grant and caches them until shortly before they expire
+type OAuth2ClientCredentials struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	HTTPClient   *http.Client
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+type oauth2TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func NewOAuth2ClientCredentials(tokenURL, clientID, clientSecret string, scopes []string, httpClient *http.Client) *OAuth2ClientCredentials {
+	return &OAuth2ClientCredentials{
+		TokenURL:     tokenURL,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Scopes:       scopes,
+		HTTPClient:   httpClient,
+	}
+}
+
+func (a *OAuth2ClientCredentials) Apply(ctx context.Context, req *http.Request) error {
+	token, err := a.Token(ctx)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// Token returns the cached access token, fetching a new one when it is missing
+// or about to expire
+func (a *OAuth2ClientCredentials) Token(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.token != "" && time.Now().Before(a.expiresAt) {
+		return a.token, nil
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	if len(a.Scopes) > 0 {
+		form.Set("scope", strings.Join(a.Scopes, " "))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", a.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(a.ClientID), url.QueryEscape(a.ClientSecret))
+
+	resp, err := a.HTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request failed: %s", resp.Status)
+	}
+
+	var tokenResponse oauth2TokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResponse); err != nil {
+		return "", fmt.Errorf("invalid token response: %w", err)
+	}
+	if tokenResponse.AccessToken == "" {
+		return "", errors.New("token response has no access_token")
+	}
+
+	lifetime := time.Duration(tokenResponse.ExpiresIn) * time.Second
+	if lifetime <= 0 {
+		lifetime = 5 * time.Minute
+	}
+	// Refresh ahead of expiry so a token never expires in flight
+	leeway := min(30*time.Second, lifetime/10)
+
+	a.token = tokenResponse.AccessToken
+	a.expiresAt = time.Now().Add(lifetime - leeway)
+	return a.token, nil
+}
+
+// Invalidate drops the cached token, e.g. after the hospital rejected it
+func (a *OAuth2ClientCredentials) Invalidate() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.token = ""
+}
+
+// doUpstream sends req with the hospital's credentials. A 401 response drops
+// cached credentials and the request is retried once with fresh ones.
+func doUpstream(ctx context.Context, httpClient *http.Client, auth UpstreamAuth, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		if auth != nil {
+			if err := auth.Apply(ctx, req); err != nil {
+				return nil, fmt.Errorf("failed to authenticate upstream request: %w", err)
+			}
+		}
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		invalidator, ok := auth.(interface{ Invalidate() })
+		if resp.StatusCode != http.StatusUnauthorized || !ok || attempt > 1 {
+			return resp, nil
+		}
+		resp.Body.Close()
+		invalidator.Invalidate()
+	}
+}
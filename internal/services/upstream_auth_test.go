@@ -0,0 +1,117 @@
+package services_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/roasted99/hospital-middleware/internal/config"
+	"github.com/roasted99/hospital-middleware/internal/models"
+	"github.com/roasted99/hospital-middleware/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHospitalAClientSendsNoCredentialsByDefault(t *testing.T) {
+	t.Setenv("JWT_SECRET", "must-not-leak")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.Header.Get("Authorization"))
+		json.NewEncoder(w).Encode(services.HospitalAResponse{NationalID: "1101500234567"})
+	}))
+	defer server.Close()
+
+	client := services.NewHospitalAClient(config.HospitalConfig{Name: "Hospital A", BaseURL: server.URL})
+	_, err := client.SearchPatients(context.Background(), models.PatientSearchRequest{NationalID: "1101500234567"})
+	require.NoError(t, err)
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "static-key", r.Header.Get("X-Hospital-Key"))
+		json.NewEncoder(w).Encode(services.HospitalAResponse{NationalID: "1101500234567"})
+	}))
+	defer server.Close()
+
+	client := services.NewHospitalAClient(config.HospitalConfig{Name: "Hospital A", BaseURL: server.URL})
+	auth, err := services.NewUpstreamAuth(config.UpstreamAuthConfig{Type: "api_key", APIKey: "static-key", APIKeyHeader: "X-Hospital-Key"}, client.HTTPClient)
+	require.NoError(t, err)
+	client.Auth = auth
+
+	_, err = client.SearchPatients(context.Background(), models.PatientSearchRequest{NationalID: "1101500234567"})
+	require.NoError(t, err)
+}
+
+func TestOAuth2ClientCredentials(t *testing.T) {
+	var tokensIssued atomic.Int32
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientID, clientSecret, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "middleware", clientID)
+		assert.Equal(t, "s3cret", clientSecret)
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "client_credentials", r.PostForm.Get("grant_type"))
+		assert.Equal(t, "patients.read", r.PostForm.Get("scope"))
+
+		n := tokensIssued.Add(1)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": fmt.Sprintf("token-%d", n),
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+	}))
+	defer tokenServer.Close()
+
+	// The hospital rejects token-1 once, as if it had been revoked
+	var rejected atomic.Bool
+	hospitalServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("email") == "revoke" && !rejected.Load() {
+			rejected.Store(true)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		assert.Equal(t, fmt.Sprintf("Bearer token-%d", tokensIssued.Load()), r.Header.Get("Authorization"))
+		json.NewEncoder(w).Encode([]services.HospitalAResponse{{NationalID: "1101500234567"}})
+	}))
+	defer hospitalServer.Close()
+
+	client := services.NewHospitalAClient(config.HospitalConfig{Name: "Hospital A", BaseURL: hospitalServer.URL})
+	auth, err := services.NewUpstreamAuth(config.UpstreamAuthConfig{
+		Type:         "oauth2",
+		TokenURL:     tokenServer.URL,
+		ClientID:     "middleware",
+		ClientSecret: "s3cret",
+		Scopes:       []string{"patients.read"},
+	}, client.HTTPClient)
+	require.NoError(t, err)
+	client.Auth = auth
+
+	for i := 0; i < 3; i++ {
+		_, err := client.SearchPatients(context.Background(), models.PatientSearchRequest{LastName: "Meesuk"})
+		require.NoError(t, err)
+	}
+	assert.EqualValues(t, 1, tokensIssued.Load(), "token should be cached between requests")
+
+	_, err = client.SearchPatients(context.Background(), models.PatientSearchRequest{LastName: "Meesuk", Email: "revoke"})
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, tokensIssued.Load(), "a rejected token should be refreshed")
+}
+
+func TestNewUpstreamAuthRejectsIncompleteConfig(t *testing.T) {
+	tests := []config.UpstreamAuthConfig{
+		{Type: "api_key"},
+		{Type: "oauth2", TokenURL: "https://idp.example/token"},
+		{Type: "mtls"},
+		{Type: "mtls", CertFile: "missing.pem", KeyFile: "missing.key"},
+		{Type: "kerberos"},
+	}
+
+	for _, cfg := range tests {
+		_, err := services.NewUpstreamAuth(cfg, &http.Client{})
+		assert.Error(t, err, "%+v", cfg)
+	}
+}
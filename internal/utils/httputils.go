@@ -2,13 +2,16 @@ package utils
 
 import (
 	"encoding/json"
+	"net"
 	"net/http"
+	"strings"
 )
 
 type Response struct {
 	Status  string      `json:"status"`
 	Message string      `json:"message"`
 	Data    interface{} `json:"data,omitempty"`
+	Meta    interface{} `json:"meta,omitempty"`
 }
 
 func ResponseWithJSON(w http.ResponseWriter, statusCode int, message string, data interface{}) {
@@ -48,3 +51,32 @@ func ResponseWithSuccess(w http.ResponseWriter, statusCode int, data interface{}
 
 	json.NewEncoder(w).Encode(response)
 }
+
+func ResponseWithPage(w http.ResponseWriter, statusCode int, data interface{}, meta interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	response := Response{
+		Status:  http.StatusText(statusCode),
+		Message: "Success",
+		Data:    data,
+		Meta:    meta,
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// ClientIP returns the address of the client. X-Real-IP, as set by the nginx
+// proxy, is only used when trustProxy is set since clients can forge it.
+func ClientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}